@@ -0,0 +1,92 @@
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+)
+
+// CA is a CA to be created and where it's saved
+type CA struct {
+	Name     string
+	CertFile string
+	KeyFile  string
+	// Config is the subject of the CA
+	Config certutil.Config
+	// Validity is how long the CA is valid for (defaults to pkiutil.DefaultCaValidity)
+	Validity time.Duration
+	// KeyType is the type of key generated (see pkiutil.KeyTypes, blank is the default)
+	KeyType string
+}
+
+// InitCAs creates each CA and saves its cert and key (the key only readable by its owner)
+// Nothing is written if any CA already exists unless force, when the previous CA files are kept in a
+// timestamped backup set in backupDir. All the CAs are written as a single set so either all or none are replaced.
+func InitCAs(cas []CA, force bool, backupDir string) error {
+	for _, ca := range cas {
+		if len(ca.CertFile) == 0 || len(ca.KeyFile) == 0 {
+			return fmt.Errorf("the cert and key files must both be specified for the %s CA", ca.Name)
+		}
+		if err := pkiutil.ValidateKeyType(ca.KeyType); err != nil {
+			return err
+		}
+		for _, file := range []string{ca.CertFile, ca.KeyFile} {
+			if !fileutil.ExistFile(file) {
+				continue
+			}
+			if !force {
+				return fmt.Errorf("the %s CA already exists at %q, it can only be replaced when forced", ca.Name, file)
+			}
+			log.Warnf("Replacing the %s CA %q, all certs it signed must be re-issued", ca.Name, file)
+		}
+	}
+	var files []fileutil.AtomicFile
+	certs := make([]*x509.Certificate, len(cas))
+	for i, ca := range cas {
+		caFiles, cert, err := newCA(ca)
+		if err != nil {
+			return err
+		}
+		files = append(files, caFiles...)
+		certs[i] = cert
+	}
+	if err := fileutil.WriteFilesAtomically(files, backupDir); err != nil {
+		return fmt.Errorf("failure while saving the CAs [%v]", err)
+	}
+	for i, ca := range cas {
+		log.Printf("Created the %s CA %q (%s) valid until %v", ca.Name, ca.CertFile, name(certs[i].Subject), certs[i].NotAfter)
+	}
+	return nil
+}
+
+// newCA generates a CA returning its cert and the files to save it to
+func newCA(ca CA) ([]fileutil.AtomicFile, *x509.Certificate, error) {
+	validity := ca.Validity
+	if validity == 0 {
+		validity = pkiutil.DefaultCaValidity
+	}
+	cert, key, err := pkiutil.NewCertificateAuthorityWithConfig(ca.Config, validity, ca.KeyType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure while creating the %s CA [%v]", ca.Name, err)
+	}
+	encodedKey, err := certutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure while encoding the %s CA key [%v]", ca.Name, err)
+	}
+	// A new directory for the key is only accessible by its owner
+	if err = os.MkdirAll(filepath.Dir(ca.KeyFile), 0700); err != nil {
+		return nil, nil, err
+	}
+	files := []fileutil.AtomicFile{
+		{Path: ca.CertFile, Content: certutil.EncodeCertPEM(cert), Mode: 0644},
+		{Path: ca.KeyFile, Content: encodedKey, Mode: 0600},
+	}
+	return files, cert, nil
+}
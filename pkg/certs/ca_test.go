@@ -0,0 +1,103 @@
+package certs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+)
+
+func TestInitCAs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+	backupDir := filepath.Join(tmpdir, "backup")
+	cas := []CA{
+		{
+			Name:     "kube",
+			CertFile: filepath.Join(tmpdir, "kube", "ca.crt"),
+			KeyFile:  filepath.Join(tmpdir, "kube", "ca.key"),
+			Config:   certutil.Config{CommonName: "kubernetes"},
+		},
+		{
+			Name:     "etcd",
+			CertFile: filepath.Join(tmpdir, "etcd", "ca.crt"),
+			KeyFile:  filepath.Join(tmpdir, "etcd", "ca.key"),
+			Config:   certutil.Config{CommonName: "Keto ETCD CA", Organization: []string{"ETCD"}},
+			Validity: 24 * time.Hour,
+			KeyType:  pkiutil.KeyTypeECDSAP256,
+		},
+	}
+	if err = InitCAs(cas, false, backupDir); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := Check([]Cert{{Name: "etcd", CertFile: cas[1].CertFile}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !infos[0].CA || infos[0].Subject != "CN=Keto ETCD CA,O=ETCD" || infos[0].NotAfter.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("unexpected CA %v", infos[0])
+	}
+	key, err := pkiutil.TryLoadAnyKeyFromDisk(cas[1].KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyType, _ := pkiutil.KeyTypeOf(key); keyType != pkiutil.KeyTypeECDSAP256 {
+		t.Errorf("expected a %s key but got %s", pkiutil.KeyTypeECDSAP256, keyType)
+	}
+	if info, err := os.Stat(cas[1].KeyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the key only readable by its owner [%v]", err)
+	}
+	previous, err := Load(cas[0].CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing CAs are kept unless forced
+	if err = InitCAs(cas, false, backupDir); err == nil {
+		t.Errorf("expected an error replacing existing CAs")
+	}
+	if cert, err := Load(cas[0].CertFile); err != nil || !cert.Equal(previous) {
+		t.Errorf("expected the CA to be kept [%v]", err)
+	}
+	if err = InitCAs(cas, true, backupDir); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := Load(cas[0].CertFile); err != nil || cert.Equal(previous) {
+		t.Errorf("expected the CA to be replaced [%v]", err)
+	}
+	// Every CA is backed up in the same set
+	for _, ca := range cas {
+		backups, err := filepath.Glob(filepath.Join(backupDir, "*", ca.KeyFile))
+		if err != nil || len(backups) != 1 {
+			t.Errorf("expected the previous %s CA key backed up but got %v [%v]", ca.Name, backups, err)
+		}
+	}
+	if sets, err := ioutil.ReadDir(backupDir); err != nil || len(sets) != 1 {
+		t.Errorf("expected a single backup set for all the CAs [%v]", err)
+	}
+
+	// No CA is replaced unless all of them are
+	previous, err = Load(cas[0].CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwritable := []CA{cas[0], {Name: "etcd", CertFile: tmpdir, KeyFile: cas[1].KeyFile}}
+	if err = InitCAs(unwritable, true, backupDir); err == nil {
+		t.Errorf("expected an error saving a CA over a directory")
+	}
+	if cert, err := Load(cas[0].CertFile); err != nil || !cert.Equal(previous) {
+		t.Errorf("expected the %s CA to be kept when another CA can't be saved [%v]", cas[0].Name, err)
+	}
+
+	// Both files must be specified
+	if err = InitCAs([]CA{{Name: "kube", CertFile: cas[0].CertFile}}, true, backupDir); err == nil {
+		t.Errorf("expected an error without a key file")
+	}
+}
@@ -0,0 +1,248 @@
+package certs
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+)
+
+// DefaultThreshold is how long before expiry a certificate should be renewed
+const DefaultThreshold time.Duration = 30 * 24 * time.Hour
+
+// Cert is a certificate file, its key and the CA which signed it
+// The CA files are blank when the signer isn't known (e.g. for a CA).
+type Cert struct {
+	Name       string
+	CertFile   string
+	KeyFile    string
+	CaCertFile string
+	CaKeyFile  string
+}
+
+// Info describes a certificate for people
+type Info struct {
+	Name     string    `json:"name"`
+	File     string    `json:"file"`
+	Subject  string    `json:"subject"`
+	SANs     []string  `json:"sans,omitempty"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"notAfter"`
+	CA       bool      `json:"ca"`
+	Expiring bool      `json:"expiring"`
+}
+
+// Load loads the first certificate in a file (even when it has expired)
+func Load(file string) (*x509.Certificate, error) {
+	certs, err := certutil.CertsFromFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the certificate file %s: %v", file, err)
+	}
+	return certs[0], nil
+}
+
+// Expiring is true when a certificate expires within threshold
+func Expiring(cert *x509.Certificate, threshold time.Duration) bool {
+	return expiring(cert, threshold, time.Now())
+}
+
+func expiring(cert *x509.Certificate, threshold time.Duration, now time.Time) bool {
+	return cert.NotAfter.Before(now.Add(threshold))
+}
+
+// KubePki returns the certificates in a kubeadm pki directory (none if it doesn't exist)
+// Each certificate is matched with the CA in the directory which signed it.
+func KubePki(dir string) ([]Cert, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]*x509.Certificate{}
+	for _, file := range files {
+		if loaded[file], err = Load(file); err != nil {
+			return nil, err
+		}
+	}
+	var certs []Cert
+	for _, file := range files {
+		c := Cert{
+			Name:     strings.TrimSuffix(filepath.Base(file), ".crt"),
+			CertFile: file,
+			KeyFile:  strings.TrimSuffix(file, ".crt") + ".key",
+		}
+		if !loaded[file].IsCA {
+			for _, caFile := range files {
+				if ca := loaded[caFile]; ca.IsCA && loaded[file].CheckSignatureFrom(ca) == nil {
+					c.CaCertFile = caFile
+					c.CaKeyFile = strings.TrimSuffix(caFile, ".crt") + ".key"
+					break
+				}
+			}
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
+
+// Check describes each certificate, marking those which expire within threshold
+func Check(certs []Cert, threshold time.Duration) ([]Info, error) {
+	return check(certs, threshold, time.Now())
+}
+
+func check(certs []Cert, threshold time.Duration, now time.Time) ([]Info, error) {
+	infos := []Info{}
+	for _, c := range certs {
+		cert, err := Load(c.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		info := Info{
+			Name:     c.Name,
+			File:     c.CertFile,
+			Subject:  name(cert.Subject),
+			Issuer:   name(cert.Issuer),
+			NotAfter: cert.NotAfter,
+			CA:       cert.IsCA,
+			Expiring: expiring(cert, threshold, now),
+		}
+		info.SANs = append(info.SANs, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			info.SANs = append(info.SANs, ip.String())
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Expired returns the certificates which expire within the threshold used by Check
+func Expired(infos []Info) []Info {
+	var expired []Info
+	for _, info := range infos {
+		if info.Expiring {
+			expired = append(expired, info)
+		}
+	}
+	return expired
+}
+
+// WriteTable writes certificate descriptions as a table for people
+func WriteTable(w io.Writer, infos []Info) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSUBJECT\tSANS\tISSUER\tEXPIRES\t")
+	for _, info := range infos {
+		expires := info.NotAfter.UTC().Format(time.RFC3339)
+		if info.Expiring {
+			expires = expires + " (renew)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", info.Name, info.Subject, strings.Join(info.SANs, ","), info.Issuer, expires)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes certificate descriptions as json
+func WriteJSON(w io.Writer, infos []Info) error {
+	out, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// RenewExpiring re-issues the certificates which expire within threshold (see Renew)
+// A CA can't be renewed in place so is only reported.
+func RenewExpiring(certs []Cert, threshold time.Duration, backupDir string) ([]string, error) {
+	var renewed []string
+	for _, c := range certs {
+		cert, err := Load(c.CertFile)
+		if err != nil {
+			return renewed, err
+		}
+		if !Expiring(cert, threshold) {
+			continue
+		}
+		if cert.IsCA {
+			log.Warnf("CA %s expires %v and must be replaced (it can't be renewed in place)", c.CertFile, cert.NotAfter)
+			continue
+		}
+		if err = Renew(c, backupDir); err != nil {
+			return renewed, err
+		}
+		renewed = append(renewed, c.Name)
+	}
+	return renewed, nil
+}
+
+// Renew re-issues a certificate from its CA with the same subject, SANs, usages and key
+// The previous certificate is kept in a timestamped backup set in backupDir.
+func Renew(c Cert, backupDir string) error {
+	if len(c.CaCertFile) == 0 || len(c.CaKeyFile) == 0 {
+		return fmt.Errorf("the CA which signed %q isn't known, it can't be renewed", c.CertFile)
+	}
+	cert, err := Load(c.CertFile)
+	if err != nil {
+		return err
+	}
+	caCert, err := pkiutil.TryLoadAnyCertFromDisk(c.CaCertFile)
+	if err != nil {
+		return fmt.Errorf("CA certificate %q could not be loaded to renew %q [%v]", c.CaCertFile, c.CertFile, err)
+	}
+	caKey, err := pkiutil.TryLoadAnyKeyFromDisk(c.CaKeyFile)
+	if err != nil {
+		return fmt.Errorf("CA key %q could not be loaded to renew %q [%v]", c.CaKeyFile, c.CertFile, err)
+	}
+	if err = cert.CheckSignatureFrom(caCert); err != nil {
+		return fmt.Errorf("certificate %q wasn't signed by CA %q [%v]", c.CertFile, c.CaCertFile, err)
+	}
+	key, err := pkiutil.TryLoadAnyKeyFromDisk(c.KeyFile)
+	if err != nil {
+		return err
+	}
+	renewed, err := certutil.NewSignedCert(Config(cert), key, caCert, caKey)
+	if err != nil {
+		return fmt.Errorf("failure while renewing certificate %q [%v]", c.CertFile, err)
+	}
+	info, err := os.Stat(c.CertFile)
+	if err != nil {
+		return err
+	}
+	files := []fileutil.AtomicFile{{Path: c.CertFile, Content: certutil.EncodeCertPEM(renewed), Mode: info.Mode().Perm()}}
+	if err = fileutil.WriteFilesAtomically(files, backupDir); err != nil {
+		return err
+	}
+	log.Printf("Renewed cert %q until %v", c.CertFile, renewed.NotAfter)
+	return nil
+}
+
+// Config returns the configuration a certificate was issued with
+func Config(cert *x509.Certificate) certutil.Config {
+	return certutil.Config{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		AltNames: certutil.AltNames{
+			DNSNames: cert.DNSNames,
+			IPs:      cert.IPAddresses,
+		},
+		Usages: cert.ExtKeyUsage,
+	}
+}
+
+// name formats a certificate subject or issuer (common name then organizations)
+func name(n pkix.Name) string {
+	parts := []string{"CN=" + n.CommonName}
+	for _, o := range n.Organization {
+		parts = append(parts, "O="+o)
+	}
+	return strings.Join(parts, ",")
+}
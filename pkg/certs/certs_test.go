@@ -0,0 +1,203 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+)
+
+// writeTestPki writes a CA and a cert it signed as a kubeadm pki directory
+func writeTestPki(t *testing.T, dir, keyType string) {
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(keyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pkiutil.WriteCertAndKey(dir, "ca", caCert, caKey); err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := pkiutil.NewCertAndKey(caCert, caKey, certutil.Config{
+		CommonName:   "kube-apiserver",
+		Organization: []string{"system:masters"},
+		AltNames: certutil.AltNames{
+			DNSNames: []string{"kubernetes"},
+			IPs:      []net.IP{net.ParseIP("10.200.0.1")},
+		},
+		Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, keyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pkiutil.WriteCertAndKey(dir, "apiserver", cert, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKubePki(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+	writeTestPki(t, tmpdir, pkiutil.DefaultKeyType)
+
+	certs, err := KubePki(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Cert{
+		{
+			Name:       "apiserver",
+			CertFile:   filepath.Join(tmpdir, "apiserver.crt"),
+			KeyFile:    filepath.Join(tmpdir, "apiserver.key"),
+			CaCertFile: filepath.Join(tmpdir, "ca.crt"),
+			CaKeyFile:  filepath.Join(tmpdir, "ca.key"),
+		},
+		{
+			Name:     "ca",
+			CertFile: filepath.Join(tmpdir, "ca.crt"),
+			KeyFile:  filepath.Join(tmpdir, "ca.key"),
+		},
+	}
+	if !reflect.DeepEqual(certs, expected) {
+		t.Errorf("expected %v but got %v", expected, certs)
+	}
+
+	// A missing directory has no certs
+	if certs, err = KubePki(filepath.Join(tmpdir, "missing")); err != nil || len(certs) != 0 {
+		t.Errorf("expected no certs but got %v [%v]", certs, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+	writeTestPki(t, tmpdir, pkiutil.DefaultKeyType)
+	certs, err := KubePki(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cert expires after a year, the CA after ten
+	infos, err := check(certs, DefaultThreshold, time.Now().Add(364*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 certs but got %v", infos)
+	}
+	apiserver := infos[0]
+	if apiserver.Subject != "CN=kube-apiserver,O=system:masters" || apiserver.Issuer != "CN=kubernetes" {
+		t.Errorf("unexpected subject %q or issuer %q", apiserver.Subject, apiserver.Issuer)
+	}
+	if strings.Join(apiserver.SANs, ",") != "kubernetes,10.200.0.1" {
+		t.Errorf("unexpected SANs %v", apiserver.SANs)
+	}
+	if !apiserver.Expiring || apiserver.CA {
+		t.Errorf("expected the apiserver cert to be expiring")
+	}
+	if infos[1].Expiring || !infos[1].CA {
+		t.Errorf("expected the CA not to be expiring")
+	}
+	if expired := Expired(infos); len(expired) != 1 || expired[0].Name != "apiserver" {
+		t.Errorf("expected only the apiserver cert expired but got %v", expired)
+	}
+
+	if infos, err = Check(certs, DefaultThreshold); err != nil {
+		t.Fatal(err)
+	}
+	if expired := Expired(infos); len(expired) != 0 {
+		t.Errorf("expected no certs expired but got %v", expired)
+	}
+
+	var b bytes.Buffer
+	if err = WriteTable(&b, infos); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[1], "apiserver ") {
+		t.Errorf("unexpected table:\n%s", b.String())
+	}
+	b.Reset()
+	if err = WriteJSON(&b, infos); err != nil {
+		t.Fatal(err)
+	}
+	var parsed []Info
+	if err = json.Unmarshal(b.Bytes(), &parsed); err != nil || len(parsed) != 2 || parsed[0].Name != "apiserver" {
+		t.Errorf("unexpected json %v:\n%s", err, b.String())
+	}
+}
+
+func TestRenew(t *testing.T) {
+	for _, keyType := range []string{pkiutil.KeyTypeRSA2048, pkiutil.KeyTypeECDSAP256} {
+		testRenew(t, keyType)
+	}
+}
+
+func testRenew(t *testing.T, keyType string) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+	pki := filepath.Join(tmpdir, "pki")
+	backupDir := filepath.Join(tmpdir, "backup")
+	writeTestPki(t, pki, keyType)
+	certs, err := KubePki(pki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := Load(certs[0].CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to renew
+	renewed, err := RenewExpiring(certs, 0, backupDir)
+	if err != nil || len(renewed) != 0 {
+		t.Errorf("expected nothing renewed but got %v [%v]", renewed, err)
+	}
+
+	// The CA is only reported
+	renewed, err = RenewExpiring(certs, 20*365*24*time.Hour, backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(renewed, []string{"apiserver"}) {
+		t.Errorf("expected the apiserver cert renewed but got %v", renewed)
+	}
+	cert, err := Load(certs[0].CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Cmp(previous.SerialNumber) == 0 {
+		t.Errorf("expected a new cert")
+	}
+	if !reflect.DeepEqual(Config(cert), Config(previous)) {
+		t.Errorf("expected %v but got %v", Config(previous), Config(cert))
+	}
+	if !reflect.DeepEqual(cert.PublicKey, previous.PublicKey) {
+		t.Errorf("expected the key to be kept")
+	}
+	backups, err := filepath.Glob(filepath.Join(backupDir, "*", certs[0].CertFile))
+	if err != nil || len(backups) != 1 {
+		t.Errorf("expected the previous cert backed up but got %v [%v]", backups, err)
+	}
+
+	// A CA can't be renewed
+	if err = Renew(certs[1], backupDir); err == nil {
+		t.Errorf("expected an error renewing a CA")
+	}
+}
@@ -0,0 +1,99 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+)
+
+// usageNames names the extended key usages in drift descriptions
+var usageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:        "any",
+	x509.ExtKeyUsageServerAuth: "server auth",
+	x509.ExtKeyUsageClientAuth: "client auth",
+}
+
+// Drift describes how a certificate and its key differ from the configuration and CA they should be issued with
+// The subject, SANs, usages, issuer, key and key type (unless blank) are compared (none are returned when
+// the certificate matches).
+func Drift(cert *x509.Certificate, key crypto.Signer, caCert *x509.Certificate, config certutil.Config, keyType string) []string {
+	var drift []string
+	subject := pkix.Name{CommonName: config.CommonName, Organization: config.Organization}
+	if name(cert.Subject) != name(subject) {
+		drift = append(drift, fmt.Sprintf("subject %s not %s", name(cert.Subject), name(subject)))
+	}
+	have := sans(cert.DNSNames, cert.IPAddresses)
+	want := sans(config.AltNames.DNSNames, config.AltNames.IPs)
+	if strings.Join(have, ",") != strings.Join(want, ",") {
+		drift = append(drift, fmt.Sprintf("SANs %v not %v", have, want))
+	}
+	have = usages(cert.ExtKeyUsage)
+	want = usages(config.Usages)
+	if strings.Join(have, ",") != strings.Join(want, ",") {
+		drift = append(drift, fmt.Sprintf("usages %v not %v", have, want))
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		drift = append(drift, fmt.Sprintf("issued by %s not the CA %s", name(cert.Issuer), name(caCert.Subject)))
+	}
+	if !keyMatches(cert, key) {
+		drift = append(drift, "key doesn't match")
+	}
+	if len(keyType) > 0 {
+		if have, err := pkiutil.KeyTypeOf(key); err != nil || have != keyType {
+			drift = append(drift, fmt.Sprintf("key type %s not %s", have, keyType))
+		}
+	}
+	return drift
+}
+
+// keyMatches is true when key is the private key of a certificate
+func keyMatches(cert *x509.Certificate, key crypto.Signer) bool {
+	have, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false
+	}
+	want, err := x509.MarshalPKIXPublicKey(key.Public())
+	return err == nil && bytes.Equal(have, want)
+}
+
+// sans returns the DNS names and IPs sorted and without duplicates
+func sans(dnsNames []string, ips []net.IP) []string {
+	unique := map[string]bool{}
+	for _, dnsName := range dnsNames {
+		unique[dnsName] = true
+	}
+	for _, ip := range ips {
+		unique[ip.String()] = true
+	}
+	return sorted(unique)
+}
+
+// usages returns the names of extended key usages sorted and without duplicates
+func usages(extKeyUsages []x509.ExtKeyUsage) []string {
+	unique := map[string]bool{}
+	for _, usage := range extKeyUsages {
+		if usageName, ok := usageNames[usage]; ok {
+			unique[usageName] = true
+		} else {
+			unique[fmt.Sprintf("usage %d", usage)] = true
+		}
+	}
+	return sorted(unique)
+}
+
+func sorted(set map[string]bool) []string {
+	values := []string{}
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,81 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/x509"
+	"net"
+	"strings"
+	"testing"
+
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+)
+
+func TestDrift(t *testing.T) {
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(pkiutil.DefaultKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCaCert, otherCaKey, err := pkiutil.NewCertificateAuthority(pkiutil.DefaultKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := certutil.Config{
+		CommonName: "etcd0",
+		AltNames: certutil.AltNames{
+			DNSNames: []string{"localhost", "etcd0"},
+			IPs:      []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("127.0.0.1")},
+		},
+		Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	cert, key, err := pkiutil.NewCertAndKey(caCert, caKey, config, pkiutil.DefaultKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := pkiutil.NewCertAndKey(caCert, caKey, config, pkiutil.DefaultKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCert, _, err := pkiutil.NewCertAndKey(otherCaCert, otherCaKey, config, pkiutil.DefaultKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The order of SANs and usages doesn't matter
+	reordered := config
+	reordered.AltNames = certutil.AltNames{
+		DNSNames: []string{"etcd0", "localhost"},
+		IPs:      []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("10.0.0.1")},
+	}
+	reordered.Usages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	if drift := Drift(cert, key, caCert, reordered, pkiutil.KeyTypeRSA2048); len(drift) != 0 {
+		t.Errorf("expected no drift but got %v", drift)
+	}
+
+	renamed := config
+	renamed.AltNames.IPs = []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("127.0.0.1")}
+	member := config
+	member.CommonName = "etcd1"
+	serverOnly := config
+	serverOnly.Usages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	var tests = []struct {
+		cert     *x509.Certificate
+		key      crypto.Signer
+		config   certutil.Config
+		keyType  string
+		expected string
+	}{
+		{cert: cert, key: key, config: member, expected: "subject CN=etcd0 not CN=etcd1"},
+		{cert: cert, key: key, config: renamed, expected: "SANs [10.0.0.1 127.0.0.1 etcd0 localhost] not [10.0.0.2 127.0.0.1 etcd0 localhost]"},
+		{cert: cert, key: key, config: serverOnly, expected: "usages [client auth server auth] not [server auth]"},
+		{cert: otherCert, key: key, config: config, expected: "issued by CN=kubernetes not the CA CN=kubernetes, key doesn't match"},
+		{cert: cert, key: otherKey, config: config, expected: "key doesn't match"},
+		{cert: cert, key: key, config: config, keyType: pkiutil.KeyTypeECDSAP256, expected: "key type rsa-2048 not ecdsa-p256"},
+	}
+	for _, test := range tests {
+		drift := Drift(test.cert, test.key, caCert, test.config, test.keyType)
+		if strings.Join(drift, ", ") != test.expected {
+			t.Errorf("expected %q but got %q", test.expected, strings.Join(drift, ", "))
+		}
+	}
+}
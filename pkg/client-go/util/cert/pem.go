@@ -17,6 +17,8 @@ limitations under the License.
 package cert
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -40,7 +42,7 @@ const (
 )
 
 // EncodePublicKeyPEM returns PEM-endcode public data
-func EncodePublicKeyPEM(key *rsa.PublicKey) ([]byte, error) {
+func EncodePublicKeyPEM(key crypto.PublicKey) ([]byte, error) {
 	der, err := x509.MarshalPKIXPublicKey(key)
 	if err != nil {
 		return []byte{}, err
@@ -61,6 +63,25 @@ func EncodePrivateKeyPEM(key *rsa.PrivateKey) []byte {
 	return pem.EncodeToMemory(&block)
 }
 
+// MarshalPrivateKeyToPEM returns PEM-encoded RSA or ECDSA private key data
+func MarshalPrivateKeyToPEM(key crypto.PrivateKey) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return EncodePrivateKeyPEM(k), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block := pem.Block{
+			Type:  ECPrivateKeyBlockType,
+			Bytes: der,
+		}
+		return pem.EncodeToMemory(&block), nil
+	}
+	return nil, fmt.Errorf("private key is not a recognized type: %T", key)
+}
+
 // EncodeCertPEM returns PEM-endcoded certificate data
 func EncodeCertPEM(cert *x509.Certificate) []byte {
 	block := pem.Block{
@@ -0,0 +1,171 @@
+package dryrun
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+)
+
+// Etcd records the keys which would be changed
+// Current values are read from the client (an empty cluster is assumed when nil).
+type Etcd struct {
+	recorder *Recorder
+	client   etcd.Clienter
+	// values written (or deleted when nil)
+	values map[string]*string
+}
+
+// verify the dry run client satisfies the abstract interface
+var _ etcd.Clienter = (*Etcd)(nil)
+
+// never is a channel which is never closed (or signalled)
+var never = make(chan struct{})
+
+// NewEtcd creates an etcd client recording changes to r
+func NewEtcd(r *Recorder, client etcd.Clienter) *Etcd {
+	return &Etcd{
+		recorder: r,
+		client:   client,
+		values:   make(map[string]*string),
+	}
+}
+
+// Get returns a value as it would be after the changes recorded
+func (e *Etcd) Get(key string) (value string, err error) {
+	if v, ok := e.values[key]; ok {
+		if v == nil {
+			return "", etcd.ErrKeyMissing
+		}
+		return *v, nil
+	}
+	if e.client == nil {
+		return "", etcd.ErrKeyMissing
+	}
+	return e.client.Get(key)
+}
+
+// GetPrefix returns values as they would be after the changes recorded
+func (e *Etcd) GetPrefix(prefix string) (values map[string]string, err error) {
+	values = make(map[string]string)
+	if e.client != nil {
+		if values, err = e.client.GetPrefix(prefix); err != nil {
+			return nil, err
+		}
+	}
+	for key, v := range e.values {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if v == nil {
+			delete(values, key)
+			continue
+		}
+		values[key] = *v
+	}
+	return values, nil
+}
+
+// GetOrCreateLock always obtains the lock (noting if it's currently held)
+func (e *Etcd) GetOrCreateLock(key string, lockKeyTTL time.Duration) (mylock bool, err error) {
+	detail := ""
+	if holder, err := e.GetLockHolder(key); err == nil {
+		detail = fmt.Sprintf("currently held by %s (would wait for it)", holder.HostName)
+	}
+	e.recorder.Note(KindEtcd, key, ActionCreate, detail)
+	return true, nil
+}
+
+// ReleaseLock changes nothing
+func (e *Etcd) ReleaseLock(key string) (err error) {
+	return nil
+}
+
+// GetLockHolder returns the current lock holder
+func (e *Etcd) GetLockHolder(key string) (holder etcd.LockHolder, err error) {
+	if e.client == nil {
+		return holder, etcd.ErrKeyMissing
+	}
+	return e.client.GetLockHolder(key)
+}
+
+// BreakLock records the lock deleted
+func (e *Etcd) BreakLock(key string, holder etcd.LockHolder) (err error) {
+	e.recorder.Note(KindEtcd, key, ActionDelete, "")
+	return nil
+}
+
+// LockLost never signals
+func (e *Etcd) LockLost(key string) <-chan struct{} {
+	return never
+}
+
+// Put records a value written
+func (e *Etcd) Put(key string, value string) (err error) {
+	return e.record(key, &value)
+}
+
+// PutTx records a value written
+func (e *Etcd) PutTx(key string, value string) (err error) {
+	return e.record(key, &value)
+}
+
+// PutTxLocked records a value written (a dry run always holds its locks)
+func (e *Etcd) PutTxLocked(key string, value string, lockKey string) (err error) {
+	return e.record(key, &value)
+}
+
+// PutLocked records a value written (a dry run always holds its locks)
+func (e *Etcd) PutLocked(key string, value string, lockKey string) (err error) {
+	return e.record(key, &value)
+}
+
+// WaitForKey returns at once (with etcd.ErrWaitTimeout if the key is missing)
+func (e *Etcd) WaitForKey(key string, timeout time.Duration) (value string, err error) {
+	if value, err = e.Get(key); err == etcd.ErrKeyMissing {
+		return "", etcd.ErrWaitTimeout
+	}
+	return value, err
+}
+
+// WatchKey never signals a change
+func (e *Etcd) WatchKey(key string, stop <-chan struct{}) <-chan struct{} {
+	return never
+}
+
+// Delete records a key deleted
+func (e *Etcd) Delete(key string) (err error) {
+	return e.record(key, nil)
+}
+
+// Close closes the client used to read current values
+func (e *Etcd) Close() (err error) {
+	if e.client == nil {
+		return nil
+	}
+	return e.client.Close()
+}
+
+// record records a value written (or deleted when nil) compared with the current value
+func (e *Etcd) record(key string, value *string) error {
+	var current string
+	existed := false
+	if e.client != nil {
+		var err error
+		current, err = e.client.Get(key)
+		if err != nil && err != etcd.ErrKeyMissing {
+			return err
+		}
+		existed = err == nil
+	}
+	e.values[key] = value
+	e.recorder.mu.Lock()
+	defer e.recorder.mu.Unlock()
+	if value == nil {
+		e.recorder.recordContent(KindEtcd, key, []byte(current), existed, nil, true)
+		return nil
+	}
+	e.recorder.recordContent(KindEtcd, key, []byte(current), existed, []byte(*value), false)
+	return nil
+}
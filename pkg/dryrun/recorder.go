@@ -0,0 +1,486 @@
+// Package dryrun records the changes bootstrapping would make to a host (and the cluster) without
+// making them
+package dryrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/ghodss/yaml"
+)
+
+// The kinds of change recorded
+const (
+	KindFile     = "file"
+	KindUnit     = "unit"
+	KindManifest = "manifest"
+	KindResource = "resource"
+	KindEtcd     = "etcd"
+)
+
+// The actions recorded for each change
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionDelete    = "delete"
+	ActionUnchanged = "unchanged"
+	ActionApply     = "apply"
+	ActionRestart   = "restart"
+)
+
+// maxDiffLine is the longest line shown in a diff (longer lines are summarised)
+const maxDiffLine = 200
+
+// Change is a single change bootstrapping would make
+type Change struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Action string `json:"action"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+// RolePlan is the bootstrap phases planned for a role
+type RolePlan struct {
+	Role   string   `json:"role"`
+	Phases []string `json:"phases"`
+}
+
+// Report is everything recorded by a dry run
+type Report struct {
+	Plans   []RolePlan `json:"plans"`
+	Changes []Change   `json:"changes"`
+}
+
+// Recorder records changes instead of making them
+// Files and etcd keys written are kept (in memory) so later reads see the changes recorded.
+// Implements fileutil.Filesystem and k8client.Applier.
+type Recorder struct {
+	mu      sync.Mutex
+	plans   []RolePlan
+	changes []Change
+	// files written (or removed when nil)
+	files map[string]*file
+	// kinds classifies files by path prefix
+	kinds map[string]string
+}
+
+type file struct {
+	content []byte
+	mode    os.FileMode
+}
+
+// documentSeparator splits multi-document yaml
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// New creates an empty recorder
+func New() *Recorder {
+	return &Recorder{
+		files: make(map[string]*file),
+		kinds: make(map[string]string),
+	}
+}
+
+// verify the recorder can replace the host filesystem
+var _ fileutil.Filesystem = (*Recorder)(nil)
+
+// Classify records changes to files at (or below) path as kind
+func (r *Recorder) Classify(path, kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.kinds[filepath.Clean(path)] = kind
+}
+
+// Plan records the phases planned for a role
+func (r *Recorder) Plan(role string, phases []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.plans = append(r.plans, RolePlan{Role: role, Phases: phases})
+}
+
+// Note records a change which can't be compared with the current state (e.g. api server changes)
+func (r *Recorder) Note(kind, target, action, detail string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record(Change{Kind: kind, Target: target, Action: action, Diff: detail})
+}
+
+// Changes returns the changes recorded (in the order first recorded)
+func (r *Recorder) Changes() []Change {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Change{}, r.changes...)
+}
+
+// Report returns the plans and changes recorded
+func (r *Recorder) Report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Report{
+		Plans:   append([]RolePlan{}, r.plans...),
+		Changes: append([]Change{}, r.changes...),
+	}
+}
+
+// WriteJSON writes the report as json
+func (r *Recorder) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(r.Report(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// WriteText writes the report for people (unchanged files are only counted)
+func (r *Recorder) WriteText(w io.Writer) error {
+	var b bytes.Buffer
+	report := r.Report()
+	for _, plan := range report.Plans {
+		fmt.Fprintf(&b, "Planned phases for a %s node: %s\n", plan.Role, strings.Join(plan.Phases, ", "))
+	}
+	unchanged := 0
+	fmt.Fprintf(&b, "Changes:\n")
+	for _, c := range report.Changes {
+		if c.Action == ActionUnchanged {
+			unchanged++
+			continue
+		}
+		fmt.Fprintf(&b, "  %-9s %-8s %s\n", c.Action, c.Kind, c.Target)
+		if len(c.Diff) > 0 {
+			for _, line := range strings.Split(c.Diff, "\n") {
+				fmt.Fprintf(&b, "      %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(&b, "%d changes, %d unchanged\n", len(report.Changes)-unchanged, unchanged)
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// ReadFile reads a file as it would be after the changes recorded
+func (r *Recorder) ReadFile(path string) ([]byte, error) {
+	r.mu.Lock()
+	f, ok := r.files[filepath.Clean(path)]
+	r.mu.Unlock()
+	if !ok {
+		return ioutil.ReadFile(path)
+	}
+	if f == nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return append([]byte{}, f.content...), nil
+}
+
+// WriteFile records a file change
+func (r *Recorder) WriteFile(path string, content []byte, mode os.FileMode) error {
+	return r.recordFile(path, &file{content: append([]byte{}, content...), mode: mode})
+}
+
+// WriteFilesAtomically records all the file changes
+func (r *Recorder) WriteFilesAtomically(files []fileutil.AtomicFile, backupDir string) error {
+	for _, f := range files {
+		if err := r.WriteFile(f.Path, f.Content, f.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyFile records a file change with the content of src
+func (r *Recorder) CopyFile(src, dst string) error {
+	content, err := r.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(src); err == nil {
+		mode = info.Mode()
+	}
+	return r.WriteFile(dst, content, mode)
+}
+
+// SymlinkFile records a link change (unchanged when already linked to tgt)
+func (r *Recorder) SymlinkFile(tgt, ln string) error {
+	content, err := r.ReadFile(tgt)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ln = filepath.Clean(ln)
+	r.files[ln] = &file{content: content, mode: 0600}
+	action := ActionCreate
+	if existing, err := os.Readlink(ln); err == nil {
+		action = ActionUpdate
+		if existing == tgt {
+			action = ActionUnchanged
+		}
+	} else if _, err := os.Lstat(ln); err == nil {
+		action = ActionUpdate
+	}
+	r.record(Change{Kind: r.kindOf(ln), Target: ln, Action: action, Diff: "+ -> " + tgt})
+	return nil
+}
+
+// MkdirAll changes nothing (directories are implied by the files recorded)
+func (r *Recorder) MkdirAll(dir string, mode os.FileMode) error {
+	return nil
+}
+
+// Remove records a file deletion
+func (r *Recorder) Remove(path string) error {
+	if _, err := r.ReadFile(path); err != nil {
+		return err
+	}
+	return r.recordFile(path, nil)
+}
+
+// Stage copies the files in dir (including changes recorded) to a new temporary directory
+// so tools which write files directly can be run against the copy (see Collect)
+func (r *Recorder) Stage(dir string) (staging string, err error) {
+	if staging, err = ioutil.TempDir("", "kmm-dry-run-"); err != nil {
+		return "", err
+	}
+	names := map[string]bool{}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return staging, err
+	}
+	for _, info := range infos {
+		names[info.Name()] = true
+	}
+	r.mu.Lock()
+	for path := range r.files {
+		if filepath.Dir(path) == filepath.Clean(dir) {
+			names[filepath.Base(path)] = true
+		}
+	}
+	r.mu.Unlock()
+	for name := range names {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			continue
+		}
+		content, err := r.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return staging, err
+		}
+		if err = ioutil.WriteFile(filepath.Join(staging, name), content, 0600); err != nil {
+			return staging, err
+		}
+	}
+	return staging, nil
+}
+
+// Collect records the files in staging which differ from those in dir
+func (r *Recorder) Collect(staging, dir string) error {
+	infos, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(staging, info.Name()))
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, info.Name())
+		if current, err := r.ReadFile(path); err == nil && bytes.Equal(current, content) {
+			continue
+		}
+		if err = r.WriteFile(path, content, info.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply records each kubernetes resource in a (multi-document) yaml string
+func (r *Recorder) Apply(resource string) error {
+	return r.noteResources(resource, ActionApply)
+}
+
+// Prune records that objects previously applied for a component (but not in resource) would be deleted
+// The objects can't be known without listing them from the cluster.
+func (r *Recorder) Prune(component, resource string) error {
+	r.Note(KindResource, "objects of "+component+" no longer in its manifest", ActionDelete, "")
+	return nil
+}
+
+// Delete records each kubernetes resource in a (multi-document) yaml string as deleted
+func (r *Recorder) Delete(resource string) error {
+	return r.noteResources(resource, ActionDelete)
+}
+
+// noteResources records an action for each kubernetes resource in a (multi-document) yaml string
+func (r *Recorder) noteResources(resource, action string) error {
+	for _, doc := range documentSeparator.Split(resource, -1) {
+		if len(strings.TrimSpace(doc)) == 0 {
+			continue
+		}
+		var obj struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return fmt.Errorf("error parsing resource [%v]:\n%s", err, doc)
+		}
+		target := obj.Kind + "/" + obj.Metadata.Name
+		if len(obj.Metadata.Namespace) > 0 {
+			target = obj.Kind + "/" + obj.Metadata.Namespace + "/" + obj.Metadata.Name
+		}
+		r.Note(KindResource, target, action, "")
+	}
+	return nil
+}
+
+// recordFile records a file written (or removed when f is nil) compared with the host
+func (r *Recorder) recordFile(path string, f *file) error {
+	path = filepath.Clean(path)
+	host, err := ioutil.ReadFile(path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[path] = f
+	if f == nil {
+		r.recordContent(r.kindOf(path), path, host, existed, nil, true)
+		return nil
+	}
+	r.recordContent(r.kindOf(path), path, host, existed, f.content, false)
+	return nil
+}
+
+// recordContent records the change from the current content (when existed) to the new content
+// Must be called with the lock held
+func (r *Recorder) recordContent(kind, target string, current []byte, existed bool, content []byte, removed bool) {
+	c := Change{Kind: kind, Target: target}
+	switch {
+	case removed && !existed:
+		// Only ever created by this run
+		r.forget(kind, target)
+		return
+	case removed:
+		c.Action = ActionDelete
+	case !existed:
+		c.Action = ActionCreate
+		c.Diff = Diff(nil, content)
+	case bytes.Equal(current, content):
+		c.Action = ActionUnchanged
+	default:
+		c.Action = ActionUpdate
+		c.Diff = Diff(current, content)
+	}
+	r.record(c)
+}
+
+// record replaces any earlier change to the same target (must be called with the lock held)
+func (r *Recorder) record(c Change) {
+	for i := range r.changes {
+		if r.changes[i].Kind == c.Kind && r.changes[i].Target == c.Target {
+			r.changes[i] = c
+			return
+		}
+	}
+	r.changes = append(r.changes, c)
+}
+
+func (r *Recorder) forget(kind, target string) {
+	for i := range r.changes {
+		if r.changes[i].Kind == kind && r.changes[i].Target == target {
+			r.changes = append(r.changes[:i], r.changes[i+1:]...)
+			return
+		}
+	}
+}
+
+// kindOf returns the kind classified for the longest matching path (must be called with the lock held)
+func (r *Recorder) kindOf(path string) string {
+	kind, matched := KindFile, ""
+	for prefix, k := range r.kinds {
+		if (path == prefix || strings.HasPrefix(path, prefix+"/")) && len(prefix) > len(matched) {
+			kind, matched = k, prefix
+		}
+	}
+	return kind
+}
+
+// Diff returns the lines removed (-) and added (+) between two versions of some content
+// Private keys are never shown and long lines are summarised.
+func Diff(current, content []byte) string {
+	if sensitive(current) || sensitive(content) {
+		return fmt.Sprintf("~ %d bytes (sensitive content not shown)", len(content))
+	}
+	a, b := lines(current), lines(content)
+	// Longest common subsequence of lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+summarise(a[i]))
+			i++
+		default:
+			out = append(out, "+ "+summarise(b[j]))
+			j++
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func lines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func summarise(line string) string {
+	if len(line) <= maxDiffLine {
+		return line
+	}
+	return fmt.Sprintf("%s... (%d bytes)", line[:maxDiffLine], len(line))
+}
+
+// sensitive content is never shown
+func sensitive(content []byte) bool {
+	return bytes.Contains(content, []byte("PRIVATE KEY")) || bytes.Contains(content, []byte("client-key-data"))
+}
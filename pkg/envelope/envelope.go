@@ -0,0 +1,128 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// dataKeySize is the size of the AES-256 keys used for data and key encryption
+const dataKeySize = 32
+
+// ErrNotSealed - testable error for a value which isn't an envelope
+var ErrNotSealed = errors.New("Value is not an encrypted envelope")
+
+// KeyProvider wraps and unwraps the data keys used to encrypt an envelope
+// e.g. a KMS or a key all masters already have
+type KeyProvider interface {
+	Name() string
+	WrapKey(dataKey []byte) (wrapped []byte, err error)
+	UnwrapKey(wrapped []byte) (dataKey []byte, err error)
+}
+
+// sealed is the serialised format of an envelope
+type sealed struct {
+	Provider   string `json:"provider"`
+	WrappedKey []byte `json:"key"`
+	Data       []byte `json:"data"`
+}
+
+// Seal will encrypt data with a new data key, itself wrapped by the key provider
+func Seal(kp KeyProvider, data []byte) (string, error) {
+	if kp == nil {
+		return "", fmt.Errorf("no key provider specified to encrypt with")
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("unable to create data key [%v]", err)
+	}
+	ciphertext, err := encrypt(dataKey, data)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := kp.WrapKey(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("unable to wrap data key with %q key provider [%v]", kp.Name(), err)
+	}
+	envelopeBytes, err := json.Marshal(sealed{
+		Provider:   kp.Name(),
+		WrappedKey: wrapped,
+		Data:       ciphertext,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(envelopeBytes), nil
+}
+
+// Open will decrypt an envelope created by Seal using the same key provider
+// Returns ErrNotSealed if the value isn't an envelope
+func Open(kp KeyProvider, envelope string) ([]byte, error) {
+	if kp == nil {
+		return nil, fmt.Errorf("no key provider specified to decrypt with")
+	}
+	var s sealed
+	if err := json.Unmarshal([]byte(envelope), &s); err != nil || s.Provider == "" {
+		return nil, ErrNotSealed
+	}
+	if s.Provider != kp.Name() {
+		return nil, fmt.Errorf("envelope encrypted with %q key provider but %q key provider specified", s.Provider, kp.Name())
+	}
+	dataKey, err := kp.UnwrapKey(s.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key with %q key provider [%v]", kp.Name(), err)
+	}
+	return decrypt(dataKey, s.Data)
+}
+
+// Verify checks a key provider is usable, i.e. any keys it requires are present and valid
+func Verify(kp KeyProvider) error {
+	envelope, err := Seal(kp, []byte{})
+	if err != nil {
+		return err
+	}
+	_, err = Open(kp, envelope)
+	return err
+}
+
+// encrypt uses AES-GCM with the random nonce prepended to the ciphertext
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("unable to create nonce [%v]", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decrypt reverses encrypt
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt data, wrong key or corrupt data [%v]", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,119 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+)
+
+const testData = `{"SaKey":"secret"}`
+
+func TestSealAndOpen(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	caKeyFile := path.Join(tmpdir, "ca.key")
+	caKey, err := certutil.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("Couldn't create rsa Private Key")
+	}
+	if err = certutil.WriteKey(caKeyFile, certutil.EncodePrivateKeyPEM(caKey)); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeTestKeyFile(t, tmpdir, "kek")
+
+	var tests = []KeyProvider{
+		&KubeCaKeyProvider{CaKeyFileName: caKeyFile},
+		&FileKeyProvider{KeyFileName: keyFile},
+	}
+	for _, kp := range tests {
+		envelope, err := Seal(kp, []byte(testData))
+		if err != nil {
+			t.Fatalf("failed Seal with %q key provider: %v", kp.Name(), err)
+		}
+		if bytes.Contains([]byte(envelope), []byte("secret")) {
+			t.Errorf("failed Seal with %q key provider, plain text found in envelope", kp.Name())
+		}
+		data, err := Open(kp, envelope)
+		if err != nil {
+			t.Fatalf("failed Open with %q key provider: %v", kp.Name(), err)
+		}
+		if string(data) != testData {
+			t.Errorf("failed Open with %q key provider, expected %q but got %q", kp.Name(), testData, data)
+		}
+	}
+}
+
+func TestOpenWithWrongKey(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	kp := &FileKeyProvider{KeyFileName: writeTestKeyFile(t, tmpdir, "kek")}
+	other := &FileKeyProvider{KeyFileName: writeTestKeyFile(t, tmpdir, "other")}
+	missing := &FileKeyProvider{KeyFileName: path.Join(tmpdir, "missing")}
+
+	envelope, err := Seal(kp, []byte(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(other, envelope); err == nil {
+		t.Errorf("expected an error opening an envelope with the wrong key")
+	}
+	if _, err := Open(missing, envelope); err == nil {
+		t.Errorf("expected an error opening an envelope without a key")
+	}
+	if _, err := Open(&KubeCaKeyProvider{}, envelope); err == nil {
+		t.Errorf("expected an error opening an envelope with a different key provider")
+	}
+	if err := Verify(kp); err != nil {
+		t.Error(err)
+	}
+	if err := Verify(missing); err == nil {
+		t.Errorf("expected an error verifying a key provider without a key")
+	}
+	if _, err := Open(kp, testData); err != ErrNotSealed {
+		t.Errorf("expected error %q but got %q", ErrNotSealed, err)
+	}
+}
+
+func TestNewKeyProvider(t *testing.T) {
+	if _, err := NewKeyProvider(KubeCaKeyProviderName, "ca.key", ""); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewKeyProvider(KubeCaKeyProviderName, "", ""); err == nil {
+		t.Errorf("expected an error with no Kube CA key file")
+	}
+	if _, err := NewKeyProvider(FileKeyProviderName, "", "kek"); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewKeyProvider(FileKeyProviderName, "ca.key", ""); err == nil {
+		t.Errorf("expected an error with no key file")
+	}
+	if _, err := NewKeyProvider("unknown", "ca.key", "kek"); err == nil {
+		t.Errorf("expected an error for an unknown key provider")
+	}
+}
+
+func writeTestKeyFile(t *testing.T, dir, name string) string {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := path.Join(dir, name)
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
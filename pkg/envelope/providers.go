@@ -0,0 +1,136 @@
+package envelope
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+)
+
+const (
+	// KubeCaKeyProviderName derives the key encryption key from the Kube CA key
+	KubeCaKeyProviderName = "kube-ca"
+
+	// FileKeyProviderName uses a key encryption key from a local file (a stand-in for a KMS)
+	FileKeyProviderName = "file"
+
+	// kubeCaKeyContext separates the derived key from any other use of the CA key
+	kubeCaKeyContext = "kmm-shared-assets"
+)
+
+// KubeCaKeyProvider wraps data keys with a key derived from the Kube CA private key
+type KubeCaKeyProvider struct {
+	CaKeyFileName string
+}
+
+// FileKeyProvider wraps data keys with a base64 encoded 32 byte key stored in a file
+type FileKeyProvider struct {
+	KeyFileName string
+}
+
+// NewKeyProvider - will return a KeyProvider implementation from a name
+func NewKeyProvider(name, kubeCaKeyFile, keyFile string) (KeyProvider, error) {
+	switch name {
+	case KubeCaKeyProviderName:
+		if len(kubeCaKeyFile) == 0 {
+			return nil, fmt.Errorf("a Kube CA key file must be specified for the %q key provider", name)
+		}
+		return &KubeCaKeyProvider{CaKeyFileName: kubeCaKeyFile}, nil
+	case FileKeyProviderName:
+		if len(keyFile) == 0 {
+			return nil, fmt.Errorf("a key file must be specified for the %q key provider", name)
+		}
+		return &FileKeyProvider{KeyFileName: keyFile}, nil
+	}
+	return nil, fmt.Errorf("Invalid key provider name %q. Must be one of: %s, %s",
+		name, KubeCaKeyProviderName, FileKeyProviderName)
+}
+
+// Name - will return the Kube CA KeyProvider name
+func (p *KubeCaKeyProvider) Name() string {
+	return KubeCaKeyProviderName
+}
+
+// WrapKey - will encrypt a data key with the key derived from the Kube CA key
+func (p *KubeCaKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	kek, err := p.keyEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(kek, dataKey)
+}
+
+// UnwrapKey - will decrypt a data key with the key derived from the Kube CA key
+func (p *KubeCaKeyProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	kek, err := p.keyEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(kek, wrapped)
+}
+
+func (p *KubeCaKeyProvider) keyEncryptionKey() ([]byte, error) {
+	key, err := certutil.PrivateKeyFromFile(p.CaKeyFileName)
+	if err != nil {
+		return nil, fmt.Errorf("Kube CA key %q is required to encrypt / decrypt shared assets [%v]", p.CaKeyFileName, err)
+	}
+	var der []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		der = x509.MarshalPKCS1PrivateKey(k)
+	case *ecdsa.PrivateKey:
+		if der, err = x509.MarshalECPrivateKey(k); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Kube CA key %q is of an unsupported type", p.CaKeyFileName)
+	}
+	mac := hmac.New(sha256.New, der)
+	mac.Write([]byte(kubeCaKeyContext))
+	return mac.Sum(nil), nil
+}
+
+// Name - will return the file KeyProvider name
+func (p *FileKeyProvider) Name() string {
+	return FileKeyProviderName
+}
+
+// WrapKey - will encrypt a data key with the key from the key file
+func (p *FileKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	kek, err := p.keyEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(kek, dataKey)
+}
+
+// UnwrapKey - will decrypt a data key with the key from the key file
+func (p *FileKeyProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	kek, err := p.keyEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(kek, wrapped)
+}
+
+func (p *FileKeyProvider) keyEncryptionKey() ([]byte, error) {
+	b, err := ioutil.ReadFile(p.KeyFileName)
+	if err != nil {
+		return nil, fmt.Errorf("key file %q is required to encrypt / decrypt shared assets [%v]", p.KeyFileName, err)
+	}
+	kek, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %q must contain a base64 encoded key [%v]", p.KeyFileName, err)
+	}
+	if len(kek) != dataKeySize {
+		return nil, fmt.Errorf("key file %q must contain a %d byte key", p.KeyFileName, dataKeySize)
+	}
+	return kek, nil
+}
@@ -10,4 +10,10 @@ var (
 
 	// ErrKeyMissing - testable error for no expected key defined
 	ErrKeyMissing = errors.New("Key not defined")
+
+	// ErrLockChanged - testable error for when a lock has changed since it was inspected
+	ErrLockChanged = errors.New("Lock changed")
+
+	// ErrWaitTimeout - testable error for when a key wasn't created whilst waiting
+	ErrWaitTimeout = errors.New("Timed out waiting for key")
 )
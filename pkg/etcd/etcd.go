@@ -1,7 +1,9 @@
 package etcd
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -14,32 +16,72 @@ import (
 // TODO: Add mockable interface for testing this package without reference to specific clientV3 lib
 
 // Client represents an etcd client configuration.
+// A single connection to etcd is shared by all operations (and reconnects as
+// required) until Close is called.
 type Client struct {
 	Endpoints          string
 	CaFileName         string
 	ClientCertFileName string
 	ClientKeyFileName  string
-	LockTTL            time.Duration
+
+	// DialTimeout is the time allowed to connect to etcd (defaults to DefaultDialTimeout)
+	DialTimeout time.Duration
+	// RequestTimeout is the time allowed for each request (defaults to DefaultRequestTimeout)
+	RequestTimeout time.Duration
+
+	// conn is the connection shared by all operations on this client
+	conn *connection
+	// locks holds the state of any locks obtained by this client
+	locks *lockSet
+}
+
+// connection is the long lived etcd connection, created when first required
+type connection struct {
+	sync.Mutex
+	cli *clientv3.Client
 }
 
 // Clienter allows for mocking out this lib for testing
 type Clienter interface {
 	Get(key string) (value string, err error)
+	GetPrefix(prefix string) (values map[string]string, err error)
 	GetOrCreateLock(key string, lockKeyTTL time.Duration) (mylock bool, err error)
+	ReleaseLock(key string) (err error)
+	GetLockHolder(key string) (holder LockHolder, err error)
+	BreakLock(key string, holder LockHolder) (err error)
+	LockLost(key string) <-chan struct{}
+	Put(key string, value string) (err error)
 	PutTx(key string, value string) (err error)
+	PutTxLocked(key string, value string, lockKey string) (err error)
+	PutLocked(key string, value string, lockKey string) (err error)
+	WaitForKey(key string, timeout time.Duration) (value string, err error)
+	WatchKey(key string, stop <-chan struct{}) <-chan struct{}
 	Delete(key string) (err error)
+	Close() (err error)
 }
 
 // Verify the implementation here satisfies the abstract interface
 var _ Clienter = (*Client)(nil)
 
-var (
-	// Timeout - For now a constant
-	Timeout = 5 * time.Second
+const (
+	// DefaultDialTimeout is used when no DialTimeout is configured
+	DefaultDialTimeout = 5 * time.Second
+
+	// DefaultRequestTimeout is used when no RequestTimeout is configured
+	DefaultRequestTimeout = 5 * time.Second
 )
 
 // New creates a new etcd client from configuration
+// No connection is made until the first operation
 func New(cfg Client) *Client {
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = DefaultRequestTimeout
+	}
+	cfg.conn = &connection{}
+	cfg.locks = newLockSet()
 	return &cfg
 }
 
@@ -47,14 +89,18 @@ func New(cfg Client) *Client {
 // - The the string value for a given key if present
 // - Will return an err for all other occasions
 func (c *Client) Get(key string) (value string, err error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	return c.GetContext(ctx, key)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
-	cli, err := getEtcdClient(*c, Timeout)
+// GetContext is Get using the deadline and cancellation of ctx
+func (c *Client) GetContext(ctx context.Context, key string) (value string, err error) {
+	cli, err := c.client()
 	if err != nil {
 		log.Printf("Error getting client:%q", err)
 		return "", err
 	}
-	defer cli.Close()
 
 	getresp, err := cli.Get(ctx, key)
 	if err != nil {
@@ -64,110 +110,69 @@ func (c *Client) Get(key string) (value string, err error) {
 		return "", ErrKeyMissing
 	}
 	for _, ev := range getresp.Kvs {
-		log.Debugf("%q values: key: %q = %q, version=%q\n", key, ev.Key, ev.Value, ev.Version)
+		log.Debugf("%q values: key: %q = %q, version=%d\n", key, ev.Key, ev.Value, ev.Version)
 		value = string(ev.Value[:])
 		break
 	}
-	//log.Printf("%q key has specific value: %q\n", key, value)
-	cancel() // context
-	return value, err
+	return value, nil
 }
 
-// GetOrCreateLock obtains a lock (true) if the first client to create lock
-// If TTL expired, will obtain lock (reset TTL)
-// If TTL not expired will return false
-func (c *Client) GetOrCreateLock(key string, lockKeyTTL time.Duration) (mylock bool, err error) {
-	mylock = false
-
-	// TODO: make this a hash for each key (not needed for current use cases)
-	c.LockTTL = lockKeyTTL
+// GetPrefix - will return all the keys (and values) starting with prefix
+// No error is returned when no keys are present
+func (c *Client) GetPrefix(prefix string) (values map[string]string, err error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	return c.GetPrefixContext(ctx, prefix)
+}
 
-	err = c.SetLock(key)
+// GetPrefixContext is GetPrefix using the deadline and cancellation of ctx
+func (c *Client) GetPrefixContext(ctx context.Context, prefix string) (values map[string]string, err error) {
+	cli, err := c.client()
 	if err != nil {
-		if err == ErrKeyAlreadyExists {
-			log.Printf("Lock allready created...")
-			// Need to check TTL and if required, transactionally re-create Lock..
-			mylock, err = c.TryRecreateLock(key)
-		}
-	} else {
-		log.Printf("Lock obtained...")
-		mylock = true
+		return nil, err
 	}
-	return mylock, err
-}
-
-// SetLock create an ETCD lock key with a TTL from now
-func (c *Client) SetLock(key string) (err error) {
-	now := time.Now()
-	ttl := now.Add(c.LockTTL)
-
-	// Try and create lock item with value of TTL
-	err = c.PutTx(key, ttl.Format(time.RFC3339))
-	return err
-}
-
-// TryRecreateLock will recreate a Lock IF TTL of existing lock has expired.
-// Returns true if lock obtained (re-created as TTL expired)
-// Returns false if existing lock still valid
-func (c *Client) TryRecreateLock(key string) (recreated bool, err error) {
-	othersTTLString, err := c.Get(key)
+	getresp, err := cli.Get(ctx, prefix, clientv3.WithPrefix())
 	if err != nil {
-		// Shouldn't get this unless terminal...
-		log.Printf("Lock (key - %q) not obtained, Can't get key:%q", key, err)
-		return false, err
-	}
-
-	// We have old TTL - parse it
-	otherTTLTime, e := time.Parse(
-		time.RFC3339,
-		othersTTLString)
-	if e != nil {
-		// Error parsing lock, corrupt, overwrite and get lock
-		log.Printf("Error parsing lock:%q, error:%q", othersTTLString, e)
-		if err := c.OverWriteLock(key); err != nil {
-			return false, err
-		}
-		return true, nil
-	}
-	// See if TTL has passed and we should assume lock...
-	now := time.Now()
-	if now.After(otherTTLTime) {
-		log.Printf("TTL exists but time passed so overwriting")
-		if err := c.OverWriteLock(key); err != nil {
-			return false, err
-		}
-		return true, nil
+		return nil, err
+	}
+	values = make(map[string]string)
+	for _, ev := range getresp.Kvs {
+		values[string(ev.Key)] = string(ev.Value)
 	}
-	log.Printf("Lock (key - %q) not obtained, TTL exists:%q", key, othersTTLString)
-	return false, nil
+	return values, nil
 }
 
-// OverWriteLock will delete and re-create a lock
-// TODO: this needs to be done as a transaction!
-func (c *Client) OverWriteLock(key string) (err error) {
-	err = c.Delete(key)
-	if err != nil {
-		log.Printf("Failed deleteing lock:%q", key)
-	}
-	err = c.SetLock(key)
+// Put - will create or overwrite a key
+func (c *Client) Put(key string, value string) (err error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	return c.PutContext(ctx, key, value)
+}
+
+// PutContext is Put using the deadline and cancellation of ctx
+func (c *Client) PutContext(ctx context.Context, key string, value string) (err error) {
+	cli, err := c.client()
 	if err != nil {
-		log.Printf("Failed creating lock:%q", key)
+		return err
 	}
+	_, err = cli.Put(ctx, key, value)
 	return err
 }
 
 // Delete - will remove a key from etcd
 func (c *Client) Delete(key string) (err error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	return c.DeleteContext(ctx, key)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
-	cli, err := getEtcdClient(*c, Timeout)
+// DeleteContext is Delete using the deadline and cancellation of ctx
+func (c *Client) DeleteContext(ctx context.Context, key string) (err error) {
+	cli, err := c.client()
 	if err != nil {
 		return err
 	}
-	defer cli.Close()
-
 	_, err = cli.Delete(ctx, key)
-	cancel()
 	return err
 }
 
@@ -175,40 +180,94 @@ func (c *Client) Delete(key string) (err error) {
 // Will ensure only a single version is ever stored.
 // Returns error if key already existed
 func (c *Client) PutTx(key string, value string) (err error) {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	return c.PutTxContext(ctx, key, value)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
-	cli, err := getEtcdClient(*c, Timeout)
+// PutTxContext is PutTx using the deadline and cancellation of ctx
+func (c *Client) PutTxContext(ctx context.Context, key string, value string) (err error) {
+	cli, err := c.client()
 	if err != nil {
 		return err
 	}
-	defer cli.Close()
-
-	kvc := clientv3.NewKV(cli)
 
 	// perform a put only if key is missing
 	// It is useful to do the check (transactionally) to avoid overwriting
 	// the existing key which would generate potentially unwanted events,
 	// unless of course you wanted to do an overwrite no matter what.
-	txRet, err := kvc.Txn(ctx).
+	txRet, err := cli.Txn(ctx).
 		If(clientv3util.KeyMissing(key)).
 		Then(clientv3.OpPut(key, value)).
 		Commit()
-
-	cancel() // context
+	if err != nil {
+		return err
+	}
 
 	if !txRet.Succeeded {
 		// We didn't create the lock - indicate with dedicated error:
 		log.Printf("Transaction didn't succeed - we didn't create lock!")
-		err = ErrKeyAlreadyExists
-	} else {
-		log.Debugf("Created item:%q...", value)
+		return ErrKeyAlreadyExists
+	}
+	log.Debugf("Created item:%q...", value)
+	return nil
+}
+
+// Close will release any locks held and close the connection to etcd
+func (c *Client) Close() (err error) {
+	if c.locks != nil {
+		for _, key := range c.locks.keys() {
+			if rerr := c.ReleaseLock(key); rerr != nil {
+				log.Printf("Failed releasing lock %q on close:%q", key, rerr)
+			}
+		}
 	}
+	if c.conn == nil {
+		return nil
+	}
+	c.conn.Lock()
+	defer c.conn.Unlock()
+	if c.conn.cli == nil {
+		return nil
+	}
+	err = c.conn.cli.Close()
+	c.conn.cli = nil
 	return err
 }
 
-func getEtcdClient(config Client, timeout time.Duration) (cli *clientv3.Client, err error) {
+// requestContext provides a context limited by the configured request timeout
+func (c *Client) requestContext() (context.Context, context.CancelFunc) {
+	timeout := c.RequestTimeout
+	if timeout == 0 {
+		timeout = DefaultRequestTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// client returns the shared etcd connection, connecting first if required
+func (c *Client) client() (*clientv3.Client, error) {
+	if c.conn == nil {
+		return nil, fmt.Errorf("etcd client not initialised, use etcd.New()")
+	}
+	c.conn.Lock()
+	defer c.conn.Unlock()
+	if c.conn.cli == nil {
+		cli, err := getEtcdClient(*c)
+		if err != nil {
+			return nil, err
+		}
+		c.conn.cli = cli
+	}
+	return c.conn.cli, nil
+}
+
+func getEtcdClient(config Client) (cli *clientv3.Client, err error) {
 
 	endPoints := strings.Split(config.Endpoints, ",")
+	timeout := config.DialTimeout
+	if timeout == 0 {
+		timeout = DefaultDialTimeout
+	}
 	cfg := clientv3.Config{
 		Endpoints:   endPoints,
 		DialTimeout: timeout,
@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
 )
 
 const containerName string = "ectd_int_test"
@@ -29,6 +31,50 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewDefaults(t *testing.T) {
+	client := New(Client{})
+	if client.DialTimeout != DefaultDialTimeout {
+		t.Error(fmt.Errorf("expected dial timeout %v but got %v", DefaultDialTimeout, client.DialTimeout))
+	}
+	if client.RequestTimeout != DefaultRequestTimeout {
+		t.Error(fmt.Errorf("expected request timeout %v but got %v", DefaultRequestTimeout, client.RequestTimeout))
+	}
+	// Nothing to close when never connected
+	if err := client.Close(); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+}
+
+func TestClose(t *testing.T) {
+	const testCloseKey string = "testclose"
+	var testCloseLockTTL = 120 * time.Second
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+	other := getETCDClient()
+	defer other.Close()
+
+	if lock, err := e.GetOrCreateLock(testCloseKey, testCloseLockTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	// Closing must release any locks held
+	if err := e.Close(); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+	if _, err := other.GetLockHolder(testCloseKey); err != ErrKeyMissing {
+		t.Error(fmt.Errorf("expected lock released on close but got %q", err))
+	}
+	// A closed client will reconnect when used again
+	if _, err := e.Get(testCloseKey); err != ErrKeyMissing {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrKeyMissing, err))
+	}
+	if err := e.Close(); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+}
+
 func TestGet(t *testing.T) {
 	const testGetKey string = "testget"
 	const testGetValue string = "value"
@@ -122,10 +168,103 @@ func TestPutTx(t *testing.T) {
 	// TODO: some how test the transaction fail (not key exists before but during!)
 }
 
+func TestPutAndGetPrefix(t *testing.T) {
+	const testPrefix string = "testprefix/"
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+
+	// Cleanup
+	_ = e.Delete(testPrefix + "a")
+	_ = e.Delete(testPrefix + "b")
+
+	if values, err := e.GetPrefix(testPrefix); err != nil || len(values) != 0 {
+		t.Error(fmt.Errorf("expected no values and no error but got %v, error:%q", values, err))
+	}
+	for _, value := range []string{"first", "overwritten"} {
+		if err := e.Put(testPrefix+"a", value); err != nil {
+			t.Error(fmt.Errorf("expected no error but got %q", err))
+		}
+	}
+	if err := e.Put(testPrefix+"b", "other"); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+	values, err := e.GetPrefix(testPrefix)
+	if err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+	if len(values) != 2 || values[testPrefix+"a"] != "overwritten" || values[testPrefix+"b"] != "other" {
+		t.Error(fmt.Errorf("unexpected values for prefix %q: %v", testPrefix, values))
+	}
+}
+
+func TestWaitForKey(t *testing.T) {
+	const testWaitKey string = "testwait"
+	const testWaitValue string = "valuewaitedfor"
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+
+	// Cleanup
+	_ = e.Delete(testWaitKey)
+
+	if _, err := e.WaitForKey(testWaitKey, 100*time.Millisecond); err != ErrWaitTimeout {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrWaitTimeout, err))
+	}
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		getETCDClient().PutTx(testWaitKey, testWaitValue)
+	}()
+	if value, err := e.WaitForKey(testWaitKey, 10*time.Second); err != nil || value != testWaitValue {
+		t.Error(fmt.Errorf("expected %q but got %q, error:%q", testWaitValue, value, err))
+	}
+	// Existing key returns straight away
+	if value, err := e.WaitForKey(testWaitKey, 100*time.Millisecond); err != nil || value != testWaitValue {
+		t.Error(fmt.Errorf("expected %q but got %q, error:%q", testWaitValue, value, err))
+	}
+}
+
+func TestWatchKey(t *testing.T) {
+	const testWatchKey string = "testwatch"
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+	stop := make(chan struct{})
+	defer close(stop)
+
+	changed := e.WatchKey(testWatchKey, stop)
+	// Allow the watch to start
+	time.Sleep(500 * time.Millisecond)
+	if err := e.Put(testWatchKey, "changed"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(10 * time.Second):
+		t.Error(fmt.Errorf("expected a change to %q to be signalled", testWatchKey))
+	}
+	if err := e.Delete(testWatchKey); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(10 * time.Second):
+		t.Error(fmt.Errorf("expected deleting %q to be signalled", testWatchKey))
+	}
+}
+
 func TestGetOrCreateLock(t *testing.T) {
 	const testGetOrCreateLockKey string = "testgetorcreatelock"
 	var testLongGetOrCreateLockTTL = 120 * time.Second
 	var testShortGetOrCreateLockTTL = 1 * time.Second
+	// etcd enforces a minimum lease TTL so wait well past the short TTL
+	var testExpireGetOrCreateLockWait = 5 * time.Second
 
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -150,10 +289,15 @@ func TestGetOrCreateLock(t *testing.T) {
 		}
 	}
 
-	// test when lock has been created but has expired (outside TTL)
-	_ = e.Delete(testGetOrCreateLockKey)
-	_, _ = e.GetOrCreateLock(testGetOrCreateLockKey, testShortGetOrCreateLockTTL)
-	time.Sleep(testShortGetOrCreateLockTTL)
+	// test when lock has been created but the holder has gone away (outside TTL)
+	_ = e.ReleaseLock(testGetOrCreateLockKey)
+	if err := putWithExpiringLease(testGetOrCreateLockKey, testShortGetOrCreateLockTTL); err != nil {
+		t.Fatal(err)
+	}
+	if lock, _ := e.GetOrCreateLock(testGetOrCreateLockKey, testShortGetOrCreateLockTTL); lock {
+		t.Error(fmt.Errorf("expected lock == false whilst lease still valid"))
+	}
+	time.Sleep(testExpireGetOrCreateLockWait)
 	if lock, err := e.GetOrCreateLock(testGetOrCreateLockKey, testShortGetOrCreateLockTTL); err != nil {
 		t.Error(fmt.Errorf("did not get lock result when expected got error:%q", err))
 	} else {
@@ -161,7 +305,7 @@ func TestGetOrCreateLock(t *testing.T) {
 			t.Error(fmt.Errorf("expected lock == true"))
 		}
 	}
-	_ = e.Delete(testGetOrCreateLockKey)
+	_ = e.ReleaseLock(testGetOrCreateLockKey)
 
 	// test when lock is corrupted i.e. invalid (created with wrong version???)
 	e.PutTx(testGetOrCreateLockKey, "not a good ttl!")
@@ -172,6 +316,271 @@ func TestGetOrCreateLock(t *testing.T) {
 			t.Error(fmt.Errorf("expected lock == true"))
 		}
 	}
+	_ = e.ReleaseLock(testGetOrCreateLockKey)
+}
+
+func TestReleaseLock(t *testing.T) {
+	const testReleaseLockKey string = "testreleaselock"
+	var testReleaseLockTTL = 120 * time.Second
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+	other := getETCDClient()
+
+	// Releasing a lock not held is not an error
+	if err := e.ReleaseLock(testReleaseLockKey); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+	if lock, err := e.GetOrCreateLock(testReleaseLockKey, testReleaseLockTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	if err := e.ReleaseLock(testReleaseLockKey); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+	// Another client should now get the lock straight away
+	if lock, err := other.GetOrCreateLock(testReleaseLockKey, testReleaseLockTTL); err != nil || !lock {
+		t.Error(fmt.Errorf("expected lock == true after release but got %v, error:%q", lock, err))
+	}
+	_ = other.ReleaseLock(testReleaseLockKey)
+}
+
+func TestReleaseLostLock(t *testing.T) {
+	const testReleaseLostLockKey string = "testreleaselostlock"
+	var testReleaseLostLockTTL = 2 * time.Second
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+	if lock, err := e.GetOrCreateLock(testReleaseLostLockKey, testReleaseLostLockTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	holder, err := e.GetLockHolder(testReleaseLostLockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Someone else removes the lock...
+	_ = e.Delete(testReleaseLostLockKey)
+	select {
+	case <-e.LockLost(testReleaseLostLockKey):
+	case <-time.After(5 * time.Second):
+		t.Fatal(fmt.Errorf("expected to be told lock was lost"))
+	}
+	if err = e.ReleaseLock(testReleaseLostLockKey); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+
+	// The lease of a lost lock is no longer kept alive once released
+	time.Sleep(2 * testReleaseLostLockTTL)
+	cli, err := e.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := cli.TimeToLive(context.Background(), clientv3.LeaseID(holder.LeaseID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl.TTL > 0 {
+		t.Error(fmt.Errorf("expected the lease of a released lost lock to expire but has ttl %d", ttl.TTL))
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	const testLockLostKey string = "testlocklost"
+	var testLockLostTTL = 120 * time.Second
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+
+	// A lock not held is always lost
+	select {
+	case <-e.LockLost(testLockLostKey):
+	default:
+		t.Error(fmt.Errorf("expected lost lock for lock not held"))
+	}
+
+	if lock, err := e.GetOrCreateLock(testLockLostKey, testLockLostTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	lost := e.LockLost(testLockLostKey)
+	select {
+	case <-lost:
+		t.Error(fmt.Errorf("expected lock to be held"))
+	default:
+	}
+
+	// Someone else removes the lock...
+	_ = e.Delete(testLockLostKey)
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Error(fmt.Errorf("expected to be told lock was lost"))
+	}
+	_ = e.ReleaseLock(testLockLostKey)
+}
+
+func TestBreakLock(t *testing.T) {
+	const testBreakLockKey string = "testbreaklock"
+	var testBreakLockTTL = 120 * time.Second
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+	other := getETCDClient()
+
+	if _, err := other.GetLockHolder(testBreakLockKey); err != ErrKeyMissing {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrKeyMissing, err))
+	}
+	if lock, err := e.GetOrCreateLock(testBreakLockKey, testBreakLockTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	holder, err := other.GetLockHolder(testBreakLockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostName, _ := os.Hostname(); holder.HostName != hostName {
+		t.Error(fmt.Errorf("expected holder host %q but got %q", hostName, holder.HostName))
+	}
+	if holder.PID != os.Getpid() {
+		t.Error(fmt.Errorf("expected holder pid %d but got %d", os.Getpid(), holder.PID))
+	}
+
+	// A stale record must not be used to break the lock
+	stale := holder
+	stale.Revision = holder.Revision - 1
+	if err := other.BreakLock(testBreakLockKey, stale); err != ErrLockChanged {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrLockChanged, err))
+	}
+	if err := other.BreakLock(testBreakLockKey, holder); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+	select {
+	case <-e.LockLost(testBreakLockKey):
+	case <-time.After(5 * time.Second):
+		t.Error(fmt.Errorf("expected holder to be told lock was lost"))
+	}
+	_ = e.ReleaseLock(testBreakLockKey)
+}
+
+func TestPutTxLocked(t *testing.T) {
+	const testPutTxLockedKey string = "testputtxlocked"
+	const testPutTxLockedLockKey string = "testputtxlockedlock"
+	var testPutTxLockedTTL = 120 * time.Second
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+	defer e.Close()
+	other := getETCDClient()
+	defer other.Close()
+	_ = e.Delete(testPutTxLockedKey)
+
+	// Not holding the lock
+	if err := e.PutTxLocked(testPutTxLockedKey, "value", testPutTxLockedLockKey); err != ErrLockChanged {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrLockChanged, err))
+	}
+	if lock, err := e.GetOrCreateLock(testPutTxLockedLockKey, testPutTxLockedTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	if err := e.PutTxLocked(testPutTxLockedKey, "value", testPutTxLockedLockKey); err != nil {
+		t.Error(fmt.Errorf("expected no error but got %q", err))
+	}
+	if err := e.PutTxLocked(testPutTxLockedKey, "other", testPutTxLockedLockKey); err != ErrKeyAlreadyExists {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrKeyAlreadyExists, err))
+	}
+	_ = e.Delete(testPutTxLockedKey)
+
+	// The lock is lost (e.g. broken or expired) after the holder last checked it
+	holder, err := other.GetLockHolder(testPutTxLockedLockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = other.BreakLock(testPutTxLockedLockKey, holder); err != nil {
+		t.Fatal(err)
+	}
+	if lock, err := other.GetOrCreateLock(testPutTxLockedLockKey, testPutTxLockedTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	if err = e.PutTxLocked(testPutTxLockedKey, "stale", testPutTxLockedLockKey); err != ErrLockChanged {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrLockChanged, err))
+	}
+	if _, err = e.Get(testPutTxLockedKey); err != ErrKeyMissing {
+		t.Error(fmt.Errorf("expected a stale holder not to put the key but got %q", err))
+	}
+	_ = other.ReleaseLock(testPutTxLockedLockKey)
+}
+
+func TestPutLocked(t *testing.T) {
+	const testPutLockedKey string = "testputlocked"
+	const testPutLockedLockKey string = "testputlockedlock"
+	var testPutLockedTTL = 120 * time.Second
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	e := getETCDClient()
+	defer e.Close()
+	other := getETCDClient()
+	defer other.Close()
+	_ = e.Delete(testPutLockedKey)
+
+	// Not holding the lock
+	if err := e.PutLocked(testPutLockedKey, "value", testPutLockedLockKey); err != ErrLockChanged {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrLockChanged, err))
+	}
+	if lock, err := e.GetOrCreateLock(testPutLockedLockKey, testPutLockedTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	// Existing values are overwritten while holding the lock
+	for _, value := range []string{"value", "newer"} {
+		if err := e.PutLocked(testPutLockedKey, value, testPutLockedLockKey); err != nil {
+			t.Error(fmt.Errorf("expected no error but got %q", err))
+		}
+	}
+
+	// The lock is lost (e.g. broken or expired) after the holder last checked it
+	holder, err := other.GetLockHolder(testPutLockedLockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = other.BreakLock(testPutLockedLockKey, holder); err != nil {
+		t.Fatal(err)
+	}
+	if lock, err := other.GetOrCreateLock(testPutLockedLockKey, testPutLockedTTL); err != nil || !lock {
+		t.Fatal(fmt.Errorf("expected lock == true but got %v, error:%q", lock, err))
+	}
+	if err = e.PutLocked(testPutLockedKey, "stale", testPutLockedLockKey); err != ErrLockChanged {
+		t.Error(fmt.Errorf("expected error %q but got %q", ErrLockChanged, err))
+	}
+	if value, err := e.Get(testPutLockedKey); err != nil || value != "newer" {
+		t.Error(fmt.Errorf("expected a stale holder not to overwrite the key but got %q [%v]", value, err))
+	}
+	_ = other.ReleaseLock(testPutLockedLockKey)
+	_ = e.Delete(testPutLockedKey)
+}
+
+// putWithExpiringLease emulates a lock holder which has gone away without releasing the lock
+func putWithExpiringLease(key string, ttl time.Duration) error {
+	c := getETCDClient()
+	defer c.Close()
+	cli, err := c.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	lease, err := cli.Grant(ctx, leaseTTLSeconds(ttl))
+	if err != nil {
+		return err
+	}
+	_, err = cli.Put(ctx, key, time.Now().Format(time.RFC3339), clientv3.WithLease(lease.ID))
+	return err
 }
 
 func getETCDClient() *Client {
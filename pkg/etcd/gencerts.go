@@ -1,18 +1,32 @@
 package etcd
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/certs"
 	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
 
 	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
 	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
 )
 
+// The consumers issued etcd client certs (each cert has the consumer's name as its common name)
+const (
+	ClientNameKmm       string = "kmm"
+	ClientNameApiserver string = "apiserver"
+	ClientNameFlannel   string = "flannel"
+	ClientNameCalico    string = "calico"
+)
+
+// ClientNames are the known consumers of etcd client certs
+var ClientNames = []string{ClientNameKmm, ClientNameApiserver, ClientNameFlannel, ClientNameCalico}
+
 // ServerConfig - Params for configuring an etcd cluster
 type ServerConfig struct {
 	CaKeyFileName      string
@@ -20,9 +34,25 @@ type ServerConfig struct {
 	ServerKeyFileName  string
 	PeerCertFileName   string
 	PeerKeyFileName    string
-	LocalHostNames     []string
-	ClusterHostNames   []string
-	ClientConfig       Client
+	// MemberName is the name of the local etcd member (the common name of its server and peer certs)
+	MemberName string
+	// LocalHostNames are the hostnames and IPs of the local etcd member (the SANs of its server and peer certs)
+	LocalHostNames []string
+	// ClientName is the consumer the client cert is issued to (e.g. ClientNameKmm)
+	ClientName   string
+	ClientConfig Client
+	// ClientOnly issues just the client cert (e.g. for a consumer other than kmm) leaving the server and peer certs
+	ClientOnly bool
+	// RenewBefore is how long before expiry existing certs are re-issued
+	RenewBefore time.Duration
+	// Strict fails when existing certs don't match their configuration (instead of re-issuing them)
+	Strict bool
+	// BackupDir is where existing certs are backed up before being re-issued
+	BackupDir string
+	// The types of key generated for each class of cert (see pkiutil.KeyTypes, blank is the default)
+	ServerKeyType string
+	PeerKeyType   string
+	ClientKeyType string
 }
 
 // ExtKeyUsage - contains a mapping of string names to extended key
@@ -47,7 +77,7 @@ var ExtKeyUsage = map[string]x509.ExtKeyUsage{
 func GenCerts(cfg ServerConfig) (err error) {
 
 	var caCert *x509.Certificate
-	var caKey *rsa.PrivateKey
+	var caKey crypto.Signer
 
 	// Load the CA files...
 	if fileutil.ExistFile(cfg.CaKeyFileName) && fileutil.ExistFile(cfg.ClientConfig.CaFileName) {
@@ -68,13 +98,17 @@ func GenCerts(cfg ServerConfig) (err error) {
 		}
 		log.Printf("Found and verified CA certificate %q and key %q", cfg.ClientConfig.CaFileName, cfg.CaKeyFileName)
 	} else {
-		return fmt.Errorf("etcd CA key %q and cert %q must both exist before certs can be created", cfg.CaKeyFileName, cfg.ClientConfig.CaFileName)
+		return fmt.Errorf("etcd CA key %q and cert %q must both exist before certs can be created (see kmm ca init)", cfg.CaKeyFileName, cfg.ClientConfig.CaFileName)
+	}
+
+	if cfg.ClientOnly {
+		return genClientCert(cfg, caCert, caKey)
 	}
 
 	// Generate the ETCD server cert and key file (if required)
 	serverCertCfg := certutil.Config{
-		CommonName: cfg.ClusterHostNames[0],
-		AltNames:   getAltNames(cfg.ClusterHostNames),
+		CommonName: cfg.MemberName,
+		AltNames:   getAltNames(cfg.LocalHostNames),
 		Usages: []x509.ExtKeyUsage{
 			ExtKeyUsage["server auth"],
 		},
@@ -84,14 +118,16 @@ func GenCerts(cfg ServerConfig) (err error) {
 		cfg.ServerKeyFileName,
 		caCert,
 		caKey,
-		serverCertCfg); err != nil {
+		serverCertCfg,
+		cfg.ServerKeyType,
+		cfg); err != nil {
 
 		return err
 	}
 
 	// Generate ETCD peer cert and key (if required)
 	peerCertCfg := certutil.Config{
-		CommonName: cfg.ClusterHostNames[0],
+		CommonName: cfg.MemberName,
 		AltNames:   getAltNames(cfg.LocalHostNames),
 		Usages: []x509.ExtKeyUsage{
 			ExtKeyUsage["server auth"],
@@ -103,34 +139,40 @@ func GenCerts(cfg ServerConfig) (err error) {
 		cfg.PeerKeyFileName,
 		caCert,
 		caKey,
-		peerCertCfg); err != nil {
+		peerCertCfg,
+		cfg.PeerKeyType,
+		cfg); err != nil {
 
 		return err
 	}
 
-	// Generate ETCD client certs
+	return genClientCert(cfg, caCert, caKey)
+}
+
+// genClientCert generates the ETCD client cert for its consumer (if required)
+func genClientCert(cfg ServerConfig, caCert *x509.Certificate, caKey crypto.Signer) error {
 	clientCertCfg := certutil.Config{
-		CommonName: cfg.ClusterHostNames[0],
+		CommonName: cfg.ClientName,
 		Usages: []x509.ExtKeyUsage{
 			ExtKeyUsage["client auth"],
 		},
 	}
-	if err = checkOrCreateCert(
+	return checkOrCreateCert(
 		cfg.ClientConfig.ClientCertFileName,
 		cfg.ClientConfig.ClientKeyFileName,
 		caCert,
 		caKey,
-		clientCertCfg); err != nil {
-		return err
-	}
-
-	return err
+		clientCertCfg,
+		cfg.ClientKeyType,
+		cfg)
 }
 
-func checkOrCreateCert(certFile, keyFile string, caCert *x509.Certificate, caKey *rsa.PrivateKey, config certutil.Config) error {
+// checkOrCreateCert will use an existing cert and key unless the cert expires within RenewBefore or
+// doesn't match its configuration (see certs.Drift), when it is re-issued (or an error returned when Strict)
+func checkOrCreateCert(certFile, keyFile string, caCert *x509.Certificate, caKey crypto.Signer, config certutil.Config, keyType string, serverCfg ServerConfig) error {
 	if fileutil.ExistFile(certFile) && fileutil.ExistFile(keyFile) {
-		// Try to load cert and key...
-		cert, err := pkiutil.TryLoadAnyCertFromDisk(certFile)
+		// Try to load cert and key (an expired cert is re-issued below)...
+		cert, err := certs.Load(certFile)
 		if err != nil || cert == nil {
 			return fmt.Errorf("certificate existed but they could not be loaded properly %q", certFile)
 		}
@@ -138,23 +180,38 @@ func checkOrCreateCert(certFile, keyFile string, caCert *x509.Certificate, caKey
 		if err != nil || key == nil {
 			return fmt.Errorf("key existed but they could not be loaded properly %q", keyFile)
 		}
-
-		log.Printf("Using cert:%q and key %q", certFile, keyFile)
-	} else {
-		// The certificate and / or the key did NOT exist, let's generate them now
-		cert, key, err := pkiutil.NewCertAndKey(caCert, caKey, config)
-		if err != nil {
-			return fmt.Errorf("failure while creating key %q and cert %q [%v]", certFile, keyFile, err)
-		}
-		if err = certutil.WriteCert(certFile, certutil.EncodeCertPEM(cert)); err != nil {
-			return fmt.Errorf("failure while saving certificate %q [%v]", certFile, err)
+		drift := certs.Drift(cert, key, caCert, config, keyType)
+		switch {
+		case len(drift) > 0 && serverCfg.Strict:
+			return fmt.Errorf("cert %q doesn't match its configuration: %s", certFile, strings.Join(drift, ", "))
+		case len(drift) > 0:
+			log.Printf("Cert %q doesn't match its configuration (%s), re-issuing", certFile, strings.Join(drift, ", "))
+		case certs.Expiring(cert, serverCfg.RenewBefore):
+			log.Printf("Cert %q expires %v, re-issuing", certFile, cert.NotAfter)
+		default:
+			log.Printf("Using cert:%q and key %q", certFile, keyFile)
+			return nil
 		}
-		if err = certutil.WriteKey(keyFile, certutil.EncodePrivateKeyPEM(key)); err != nil {
-			return fmt.Errorf("failure while saving key %q [%v]", keyFile, err)
-		}
-		log.Printf("Generated cert %q.", certFile)
-		log.Printf("Generated key %q.", keyFile)
 	}
+	// The certificate and / or the key did NOT exist (or must be re-issued), let's generate them now
+	cert, key, err := pkiutil.NewCertAndKey(caCert, caKey, config, keyType)
+	if err != nil {
+		return fmt.Errorf("failure while creating key %q and cert %q [%v]", certFile, keyFile, err)
+	}
+	encodedKey, err := certutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return fmt.Errorf("failure while encoding key %q [%v]", keyFile, err)
+	}
+	// Any previous cert and key are backed up together
+	files := []fileutil.AtomicFile{
+		{Path: certFile, Content: certutil.EncodeCertPEM(cert), Mode: 0644},
+		{Path: keyFile, Content: encodedKey, Mode: 0600},
+	}
+	if err = fileutil.WriteFilesAtomically(files, serverCfg.BackupDir); err != nil {
+		return fmt.Errorf("failure while saving cert %q and key %q [%v]", certFile, keyFile, err)
+	}
+	log.Printf("Generated cert %q.", certFile)
+	log.Printf("Generated key %q.", keyFile)
 	return nil
 }
 
@@ -0,0 +1,57 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+)
+
+func TestGenCertsClientOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gencerts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caCert, caKey, err := pkiutil.NewCertificateAuthority("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pkiutil.WriteCertAndKey(dir, "ca", caCert, caKey); err != nil {
+		t.Fatal(err)
+	}
+	cfg := ServerConfig{
+		CaKeyFileName:      filepath.Join(dir, "ca.key"),
+		ServerCertFileName: filepath.Join(dir, "server.crt"),
+		ServerKeyFileName:  filepath.Join(dir, "server.key"),
+		PeerCertFileName:   filepath.Join(dir, "peer.crt"),
+		PeerKeyFileName:    filepath.Join(dir, "peer.key"),
+		MemberName:         "etcd0",
+		LocalHostNames:     []string{"localhost"},
+		ClientName:         ClientNameCalico,
+		ClientConfig: Client{
+			CaFileName:         filepath.Join(dir, "ca.crt"),
+			ClientCertFileName: filepath.Join(dir, "calico-client.crt"),
+			ClientKeyFileName:  filepath.Join(dir, "calico-client.key"),
+		},
+		ClientOnly: true,
+	}
+	if err = GenCerts(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := pkiutil.TryLoadAnyCertFromDisk(cfg.ClientConfig.ClientCertFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != ClientNameCalico {
+		t.Errorf("expected a client cert issued to %s but got %s", ClientNameCalico, cert.Subject.CommonName)
+	}
+	for _, file := range []string{cfg.ServerCertFileName, cfg.ServerKeyFileName, cfg.PeerCertFileName, cfg.PeerKeyFileName} {
+		if fileutil.ExistFile(file) {
+			t.Errorf("unexpected %s issuing only a client cert", file)
+		}
+	}
+}
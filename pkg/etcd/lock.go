@@ -0,0 +1,368 @@
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/version"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/clientv3util"
+	"golang.org/x/net/context"
+)
+
+// LockHolder is the record stored in a lock key to identify the lock holder
+type LockHolder struct {
+	HostName string    `json:"hostname"`
+	PID      int       `json:"pid"`
+	Version  string    `json:"version"`
+	Started  time.Time `json:"started"`
+
+	// Revision is the etcd revision the lock record was last modified at
+	Revision int64 `json:"-"`
+	// LeaseID is the etcd lease the lock is bound to (0 when not bound to a lease)
+	LeaseID int64 `json:"-"`
+}
+
+// lockSet tracks the locks held by a client so they can be kept alive and released
+type lockSet struct {
+	sync.Mutex
+	locks map[string]*heldLock
+}
+
+// heldLock is a lock key bound to a lease which is kept alive until released or lost
+type heldLock struct {
+	leaseID clientv3.LeaseID
+	// revision is the etcd revision the lock key was created at (it is never modified while held)
+	revision int64
+	cancel   context.CancelFunc
+	lost     chan struct{}
+}
+
+func newLockSet() *lockSet {
+	return &lockSet{locks: make(map[string]*heldLock)}
+}
+
+// keys lists the locks currently held
+func (s *lockSet) keys() []string {
+	s.Lock()
+	defer s.Unlock()
+	keys := make([]string, 0, len(s.locks))
+	for key := range s.locks {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// newLockHolder identifies this process as a lock holder
+func newLockHolder() LockHolder {
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = "unknown"
+	}
+	return LockHolder{
+		HostName: hostName,
+		PID:      os.Getpid(),
+		Version:  version.Get().Version,
+		Started:  time.Now(),
+	}
+}
+
+// String describes the lock holder for logging
+func (h LockHolder) String() string {
+	if h.HostName == "" {
+		return "unknown holder"
+	}
+	return fmt.Sprintf("host:%q pid:%d version:%q held for:%v (since %s)",
+		h.HostName, h.PID, h.Version, time.Since(h.Started), h.Started.Format(time.RFC3339))
+}
+
+// GetOrCreateLock obtains a lock (true) if the first client to create lock
+// The lock key is bound to an etcd lease with a TTL of lockKeyTTL so expiry is
+// decided by the etcd server and not by the clocks of each client.
+// While held, the lease is kept alive until ReleaseLock is called.
+// If the lock is held by another live lease, will return false
+func (c *Client) GetOrCreateLock(key string, lockKeyTTL time.Duration) (mylock bool, err error) {
+	cli, err := c.client()
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	lockBytes, err := json.Marshal(newLockHolder())
+	if err != nil {
+		return false, err
+	}
+	lease, err := cli.Grant(ctx, leaseTTLSeconds(lockKeyTTL))
+	if err != nil {
+		return false, err
+	}
+	lockValue := string(lockBytes)
+
+	// Only create the lock if no other client has it
+	txResp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, lockValue, clientv3.WithLease(lease.ID))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err == nil && !txResp.Succeeded {
+		kvs := txResp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) > 0 && kvs[0].Lease == 0 {
+			// A lock not bound to a lease can never expire (e.g. an old
+			// timestamp lock or a corrupt value) so take it over but only
+			// if it hasn't changed since we looked at it.
+			log.Printf("Lock (key - %q) has no lease, taking over lock...", key)
+			txResp, err = cli.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision(key), "=", kvs[0].ModRevision)).
+				Then(clientv3.OpPut(key, lockValue, clientv3.WithLease(lease.ID))).
+				Commit()
+		}
+	}
+	if err != nil || !txResp.Succeeded {
+		// Don't leave our lease around when we don't hold the lock
+		if _, rerr := cli.Revoke(ctx, lease.ID); rerr != nil {
+			log.Printf("Failed revoking unused lease for lock %q:%q", key, rerr)
+		}
+		if err != nil {
+			return false, err
+		}
+		log.Printf("Lock (key - %q) not obtained, held by another lease", key)
+		return false, nil
+	}
+
+	if err = c.keepLock(cli, key, lease.ID, txResp.Header.Revision); err != nil {
+		return false, err
+	}
+	log.Printf("Lock obtained...")
+	return true, nil
+}
+
+// ReleaseLock will stop keeping a held lock alive and revoke its lease (deleting the lock key)
+// Releasing a lock not held by this client is not an error
+func (c *Client) ReleaseLock(key string) (err error) {
+	if c.locks == nil {
+		return nil
+	}
+	c.locks.Lock()
+	l, held := c.locks.locks[key]
+	delete(c.locks.locks, key)
+	c.locks.Unlock()
+	if !held {
+		return nil
+	}
+
+	lost := false
+	select {
+	case <-l.lost:
+		lost = true
+	default:
+	}
+	// Always stop keeping the lease alive and watching the lock key (even when already lost)
+	l.cancel()
+	if lost {
+		// Nothing left to revoke
+		return nil
+	}
+	cli, err := c.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	if _, err = cli.Revoke(ctx, l.leaseID); err != nil {
+		return err
+	}
+	log.Printf("Released lock %q", key)
+	return nil
+}
+
+// GetLockHolder returns the record of whoever holds a lock
+// Returns ErrKeyMissing if the lock isn't held.
+// A lock record which can't be parsed (e.g. an old timestamp lock) is returned without holder details.
+func (c *Client) GetLockHolder(key string) (holder LockHolder, err error) {
+	cli, err := c.client()
+	if err != nil {
+		return holder, err
+	}
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	getresp, err := cli.Get(ctx, key)
+	if err != nil {
+		return holder, err
+	}
+	if len(getresp.Kvs) == 0 {
+		return holder, ErrKeyMissing
+	}
+	kv := getresp.Kvs[0]
+	if err := json.Unmarshal(kv.Value, &holder); err != nil {
+		log.Printf("Lock (key - %q) has an unknown format:%q", key, kv.Value)
+		holder = LockHolder{}
+	}
+	holder.Revision = kv.ModRevision
+	holder.LeaseID = kv.Lease
+	return holder, nil
+}
+
+// BreakLock will remove a lock held by anyone, but only if the lock is still the one described by holder.
+// The lease of the holder is revoked so a live holder is told it has lost the lock.
+// Returns ErrLockChanged if the lock has been modified since holder was obtained.
+func (c *Client) BreakLock(key string, holder LockHolder) (err error) {
+	cli, err := c.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	txResp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", holder.Revision)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !txResp.Succeeded {
+		return ErrLockChanged
+	}
+	if holder.LeaseID != 0 {
+		if _, err := cli.Revoke(ctx, clientv3.LeaseID(holder.LeaseID)); err != nil {
+			// The lease may have expired already - the lock is gone anyway
+			log.Printf("Failed revoking lease for lock %q:%q", key, err)
+		}
+	}
+	log.Printf("Broken lock %q held by %s", key, holder)
+	return nil
+}
+
+// PutTxLocked is PutTx but only while this client still holds lockKey.
+// The lock is compared in the same transaction as the put so a lock lost at any time before the put
+// (e.g. the lease expired or the lock was broken) can never be overwritten by a stale holder.
+// Returns ErrLockChanged if the lock isn't held and ErrKeyAlreadyExists if the key already existed
+func (c *Client) PutTxLocked(key string, value string, lockKey string) (err error) {
+	return c.putLocked(key, value, lockKey, clientv3util.KeyMissing(key))
+}
+
+// PutLocked is Put (overwriting any existing value) but only while this client still holds lockKey
+// (see PutTxLocked). Returns ErrLockChanged if the lock isn't held
+func (c *Client) PutLocked(key string, value string, lockKey string) (err error) {
+	return c.putLocked(key, value, lockKey)
+}
+
+// putLocked puts a key if the conditions hold and the lock key is unchanged since it was created
+// by this client (the same guard as BreakLock - a deleted or expired lock has no revision)
+func (c *Client) putLocked(key string, value string, lockKey string, conditions ...clientv3.Cmp) (err error) {
+	var l *heldLock
+	if c.locks != nil {
+		c.locks.Lock()
+		l = c.locks.locks[lockKey]
+		c.locks.Unlock()
+	}
+	if l == nil {
+		return ErrLockChanged
+	}
+	cli, err := c.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	conditions = append(conditions, clientv3.Compare(clientv3.ModRevision(lockKey), "=", l.revision))
+	txResp, err := cli.Txn(ctx).
+		If(conditions...).
+		Then(clientv3.OpPut(key, value)).
+		Else(clientv3.OpGet(lockKey)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !txResp.Succeeded {
+		kvs := txResp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) > 0 && kvs[0].ModRevision == l.revision {
+			// Still holding the lock so another condition failed (the key already existed)
+			return ErrKeyAlreadyExists
+		}
+		log.Printf("Lock (key - %q) lost, not putting %q", lockKey, key)
+		return ErrLockChanged
+	}
+	log.Debugf("Put item:%q...", value)
+	return nil
+}
+
+// LockLost returns a channel which is closed when this client no longer holds the lock
+// e.g. the lease could not be kept alive or the lock key was removed by another client
+func (c *Client) LockLost(key string) <-chan struct{} {
+	if c.locks != nil {
+		c.locks.Lock()
+		defer c.locks.Unlock()
+		if l, held := c.locks.locks[key]; held {
+			return l.lost
+		}
+	}
+	notHeld := make(chan struct{})
+	close(notHeld)
+	return notHeld
+}
+
+// keepLock will keep the lease for a lock alive and watch the lock key for
+// changes made by anyone else
+func (c *Client) keepLock(cli *clientv3.Client, key string, leaseID clientv3.LeaseID, rev int64) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	keepAlive, err := cli.KeepAlive(ctx, leaseID)
+	if err != nil {
+		cancel()
+		return err
+	}
+	watch := cli.Watch(ctx, key, clientv3.WithRev(rev+1))
+	l := &heldLock{
+		leaseID:  leaseID,
+		revision: rev,
+		cancel:   cancel,
+		lost:     make(chan struct{}),
+	}
+	c.locks.Lock()
+	c.locks.locks[key] = l
+	c.locks.Unlock()
+
+	go func() {
+		defer close(l.lost)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-keepAlive:
+				if !ok {
+					log.Printf("Lock (key - %q) lost, lease could not be kept alive", key)
+					return
+				}
+			case wresp, ok := <-watch:
+				if !ok {
+					log.Printf("Lock (key - %q) lost, watch closed", key)
+					return
+				}
+				for _, ev := range wresp.Events {
+					if ev.Type == clientv3.EventTypeDelete || ev.Kv.Lease != int64(leaseID) {
+						log.Printf("Lock (key - %q) lost, changed by another client", key)
+						return
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// leaseTTLSeconds will round a TTL up to the whole seconds used by etcd leases
+func leaseTTLSeconds(ttl time.Duration) int64 {
+	seconds := int64(math.Ceil(ttl.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
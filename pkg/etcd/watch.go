@@ -0,0 +1,118 @@
+package etcd
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+)
+
+// WaitForKey - will block until a key exists and return its value
+// Returns ErrWaitTimeout if the key isn't created within the timeout
+func (c *Client) WaitForKey(key string, timeout time.Duration) (value string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.WaitForKeyContext(ctx, key)
+}
+
+// WaitForKeyContext is WaitForKey using the deadline and cancellation of ctx
+func (c *Client) WaitForKeyContext(ctx context.Context, key string) (value string, err error) {
+	cli, err := c.client()
+	if err != nil {
+		return "", err
+	}
+	getresp, err := cli.Get(ctx, key)
+	if err != nil {
+		return "", waitError(ctx, err)
+	}
+	if len(getresp.Kvs) > 0 {
+		return string(getresp.Kvs[0].Value), nil
+	}
+	// Only watch for changes after the get so no put can be missed
+	watch := cli.Watch(clientv3.WithRequireLeader(ctx), key, clientv3.WithRev(getresp.Header.Revision+1))
+	for wresp := range watch {
+		if err = wresp.Err(); err != nil {
+			return "", waitError(ctx, err)
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == clientv3.EventTypePut {
+				return string(ev.Kv.Value), nil
+			}
+		}
+	}
+	return "", waitError(ctx, fmt.Errorf("watch on %q closed", key))
+}
+
+// waitError returns ErrWaitTimeout when the wait has timed out or the err otherwise
+func waitError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrWaitTimeout
+	}
+	return err
+}
+
+// watchRetryDelay is the delay before re-establishing a failed watch
+const watchRetryDelay = 5 * time.Second
+
+// WatchKey - will signal on the returned channel whenever a key changes (or is deleted)
+// until stop is closed. A failed watch is re-established and then signals as changes may
+// have been missed. The returned channel is never closed.
+func (c *Client) WatchKey(key string, stop <-chan struct{}) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		failed := false
+		for {
+			err := c.watchKey(ctx, key, failed, changed)
+			if ctx.Err() != nil {
+				return
+			}
+			failed = true
+			log.Printf("Watch on %q failed, retrying in %v: %v", key, watchRetryDelay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchRetryDelay):
+			}
+		}
+	}()
+	return changed
+}
+
+// watchKey signals on changed until the watch fails (signalling first when resumed after a failure)
+// Each watch has its own context, cancelled when it fails so it's never left open when retried.
+func (c *Client) watchKey(ctx context.Context, key string, resumed bool, changed chan<- struct{}) error {
+	cli, err := c.client()
+	if err != nil {
+		return err
+	}
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	watch := cli.Watch(watchCtx, key)
+	if resumed {
+		notify(changed)
+	}
+	for wresp := range watch {
+		if err = wresp.Err(); err != nil {
+			return err
+		}
+		if len(wresp.Events) > 0 {
+			notify(changed)
+		}
+	}
+	return fmt.Errorf("watch on %q closed", key)
+}
+
+// notify signals a change without blocking (a pending signal covers any number of changes)
+func notify(changed chan<- struct{}) {
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,295 @@
+package fileutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat names each backup set so they sort by age
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// rolledBackPrefix names the backup sets of files replaced by a rollback
+const rolledBackPrefix = "rolled-back-"
+
+// AtomicFile is a file to be written as part of a set by WriteFilesAtomically
+type AtomicFile struct {
+	Path    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// WriteFilesAtomically writes a set of files so either all or none are replaced.
+// All files are first staged (and synced) next to their destinations, then any
+// existing files are copied to a timestamped backup set in backupDir before the
+// staged files are renamed into place. If any rename fails, the files already
+// replaced are restored from the backup set.
+// Files already present with the same content and mode are left alone and no
+// backup set is created when nothing needs replacing.
+func WriteFilesAtomically(files []AtomicFile, backupDir string) error {
+	changed := []AtomicFile{}
+	for _, f := range files {
+		same, err := unchanged(f)
+		if err != nil {
+			return fmt.Errorf("unable to check %q [%v]", f.Path, err)
+		}
+		if !same {
+			changed = append(changed, f)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return writeFilesAtomically(changed, filepath.Join(backupDir, time.Now().UTC().Format(backupTimeFormat)))
+}
+
+// RestoreLatestBackup will atomically restore the most recent backup set from backupDir.
+// The files replaced are kept in a separate backup set and the restored set is removed
+// so a further restore will go back to the previous set.
+// Returns the name of the backup set restored.
+func RestoreLatestBackup(backupDir string) (restored string, err error) {
+	sets, err := backupSets(backupDir)
+	if err != nil {
+		return "", err
+	}
+	if len(sets) == 0 {
+		return "", fmt.Errorf("no backups found in %q", backupDir)
+	}
+	restored = sets[len(sets)-1]
+	setDir := filepath.Join(backupDir, restored)
+	files := []AtomicFile{}
+	err = filepath.Walk(setDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(setDir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, AtomicFile{
+			Path:    string(filepath.Separator) + rel,
+			Content: content,
+			Mode:    info.Mode().Perm(),
+		})
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to read backup %q [%v]", setDir, err)
+	}
+	rolledBack := filepath.Join(backupDir, rolledBackPrefix+time.Now().UTC().Format(backupTimeFormat))
+	if err = writeFilesAtomically(files, rolledBack); err != nil {
+		return "", err
+	}
+	return restored, os.RemoveAll(setDir)
+}
+
+// PruneBackups will remove all but the newest keep backup sets from backupDir
+func PruneBackups(backupDir string, keep int) error {
+	sets, err := backupSets(backupDir)
+	if err != nil {
+		return err
+	}
+	for len(sets) > keep {
+		if err := os.RemoveAll(filepath.Join(backupDir, sets[0])); err != nil {
+			return err
+		}
+		sets = sets[1:]
+	}
+	return nil
+}
+
+// backupSets lists the backup sets (oldest first), ignoring sets replaced by a rollback
+func backupSets(backupDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(backupDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sets := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), rolledBackPrefix) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, entry.Name()); err != nil {
+			continue
+		}
+		sets = append(sets, entry.Name())
+	}
+	sort.Strings(sets)
+	return sets, nil
+}
+
+func writeFilesAtomically(files []AtomicFile, backupSet string) (err error) {
+	staged := make([]string, len(files))
+	defer func() {
+		// Tidy up anything not renamed into place
+		for _, s := range staged {
+			if len(s) > 0 {
+				os.Remove(s)
+			}
+		}
+	}()
+	for i, f := range files {
+		if staged[i], err = stageFile(f); err != nil {
+			return fmt.Errorf("unable to stage %q [%v]", f.Path, err)
+		}
+	}
+	existed := make([]bool, len(files))
+	for i, f := range files {
+		if existed[i], err = backupFile(f.Path, backupSet); err != nil {
+			return fmt.Errorf("unable to backup %q [%v]", f.Path, err)
+		}
+	}
+	for i, f := range files {
+		if err = os.Rename(staged[i], f.Path); err != nil {
+			if rerr := undo(files[:i], existed[:i], backupSet); rerr != nil {
+				return fmt.Errorf("unable to replace %q [%v] and unable to restore previous files [%v]", f.Path, err, rerr)
+			}
+			return fmt.Errorf("unable to replace %q, previous files restored [%v]", f.Path, err)
+		}
+		staged[i] = ""
+	}
+	for _, f := range files {
+		if err = syncDir(filepath.Dir(f.Path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unchanged returns true if the file already exists with the same content and mode
+func unchanged(f AtomicFile) (bool, error) {
+	info, err := os.Stat(f.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != f.Mode.Perm() {
+		return false, nil
+	}
+	content, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(content, f.Content), nil
+}
+
+// stageFile writes content to a synced temporary file in the same directory as the destination
+func stageFile(f AtomicFile) (string, error) {
+	dir := filepath.Dir(f.Path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(dir, ".kmm-staged-"+filepath.Base(f.Path))
+	if err != nil {
+		return "", err
+	}
+	if err = writeAndSync(tmp, f.Content, f.Mode); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// backupFile copies an existing file into the backup set (keeping its full path)
+// Returns false if there was no existing file to backup
+func backupFile(fileName, backupSet string) (bool, error) {
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return false, err
+	}
+	backup := filepath.Join(backupSet, fileName)
+	if err = os.MkdirAll(filepath.Dir(backup), 0700); err != nil {
+		return false, err
+	}
+	out, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	return true, writeAndSync(out, content, info.Mode().Perm())
+}
+
+// undo restores files from the backup set (or removes them if they didn't exist before)
+func undo(files []AtomicFile, existed []bool, backupSet string) error {
+	for i, f := range files {
+		if !existed[i] {
+			if err := os.Remove(f.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		backup := filepath.Join(backupSet, f.Path)
+		info, err := os.Stat(backup)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(backup)
+		if err != nil {
+			return err
+		}
+		if err := stageAndRename(AtomicFile{Path: f.Path, Content: content, Mode: info.Mode().Perm()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stageAndRename replaces a single file atomically
+func stageAndRename(f AtomicFile) error {
+	staged, err := stageFile(f)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(staged, f.Path); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	return nil
+}
+
+// writeAndSync writes, sets the mode, syncs and closes a file
+func writeAndSync(f *os.File, content []byte, mode os.FileMode) (err error) {
+	defer func() {
+		cerr := f.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+	if _, err = f.Write(content); err != nil {
+		return err
+	}
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// syncDir ensures renames within a directory are persisted
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,189 @@
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFilesAtomically(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+	backupDir := filepath.Join(tmpdir, "backup")
+	existing := filepath.Join(tmpdir, "pki", "sa.key")
+	created := filepath.Join(tmpdir, "pki", "front-proxy-ca.key")
+
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(existing, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = WriteFilesAtomically([]AtomicFile{
+		{Path: existing, Content: []byte("new"), Mode: 0600},
+		{Path: created, Content: []byte("created"), Mode: 0644},
+	}, backupDir)
+	if err != nil {
+		t.Fatalf("failed WriteFilesAtomically with an error: %v", err)
+	}
+	checkFile(t, existing, "new", 0600)
+	checkFile(t, created, "created", 0644)
+	checkFile(t, filepath.Join(backupDir, mustBackupSet(t, backupDir), existing), "old", 0600)
+
+	// Nothing staged should be left behind
+	entries, err := ioutil.ReadDir(filepath.Dir(existing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected only 2 files in %q but got %d", filepath.Dir(existing), len(entries))
+	}
+
+	// A file which can't be staged must leave all files untouched
+	blocked := filepath.Join(tmpdir, "notadir")
+	if err := ioutil.WriteFile(blocked, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = WriteFilesAtomically([]AtomicFile{
+		{Path: existing, Content: []byte("newer"), Mode: 0600},
+		{Path: filepath.Join(blocked, "file"), Content: []byte("blocked"), Mode: 0600},
+	}, backupDir)
+	if err == nil {
+		t.Errorf("expected an error writing below a file")
+	}
+	checkFile(t, existing, "new", 0600)
+}
+
+func TestWriteFilesAtomicallyUnchanged(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+	backupDir := filepath.Join(tmpdir, "backup")
+	key := filepath.Join(tmpdir, "pki", "sa.key")
+	pub := filepath.Join(tmpdir, "pki", "sa.pub")
+	files := []AtomicFile{
+		{Path: key, Content: []byte("key"), Mode: 0600},
+		{Path: pub, Content: []byte("pub"), Mode: 0644},
+	}
+
+	// Saving the same files repeatedly must not create any backup sets
+	for i := 0; i < 3; i++ {
+		if err := WriteFilesAtomically(files, backupDir); err != nil {
+			t.Fatalf("failed WriteFilesAtomically with an error: %v", err)
+		}
+	}
+	sets, err := backupSets(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 0 {
+		t.Fatalf("expected no backups for unchanged files but got %d", len(sets))
+	}
+
+	// Only the changed file is backed up (and replaced)
+	files[1].Content = []byte("new pub")
+	if err := WriteFilesAtomically(files, backupDir); err != nil {
+		t.Fatalf("failed WriteFilesAtomically with an error: %v", err)
+	}
+	set := mustBackupSet(t, backupDir)
+	checkFile(t, filepath.Join(backupDir, set, pub), "pub", 0644)
+	if _, err := os.Stat(filepath.Join(backupDir, set, key)); !os.IsNotExist(err) {
+		t.Errorf("expected unchanged file %q not to be backed up", key)
+	}
+	checkFile(t, pub, "new pub", 0644)
+
+	// A changed mode is replaced
+	files[0].Mode = 0640
+	if err := WriteFilesAtomically(files, backupDir); err != nil {
+		t.Fatalf("failed WriteFilesAtomically with an error: %v", err)
+	}
+	checkFile(t, key, "key", 0640)
+}
+
+func TestRestoreLatestBackup(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+	backupDir := filepath.Join(tmpdir, "backup")
+	file := filepath.Join(tmpdir, "pki", "sa.key")
+
+	if _, err := RestoreLatestBackup(backupDir); err == nil {
+		t.Errorf("expected an error with no backups")
+	}
+	for _, content := range []string{"first", "second", "third"} {
+		if err := WriteFilesAtomically([]AtomicFile{{Path: file, Content: []byte(content), Mode: 0600}}, backupDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := RestoreLatestBackup(backupDir); err != nil {
+		t.Fatalf("failed RestoreLatestBackup with an error: %v", err)
+	}
+	checkFile(t, file, "second", 0600)
+	if _, err := RestoreLatestBackup(backupDir); err != nil {
+		t.Fatalf("failed RestoreLatestBackup with an error: %v", err)
+	}
+	checkFile(t, file, "first", 0600)
+}
+
+func TestPruneBackups(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+	backupDir := filepath.Join(tmpdir, "backup")
+	file := filepath.Join(tmpdir, "sa.key")
+
+	for _, content := range []string{"first", "second", "third", "fourth"} {
+		if err := WriteFilesAtomically([]AtomicFile{{Path: file, Content: []byte(content), Mode: 0600}}, backupDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := PruneBackups(backupDir, 2); err != nil {
+		t.Fatalf("failed PruneBackups with an error: %v", err)
+	}
+	sets, err := backupSets(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 {
+		t.Fatalf("expected 2 backups but got %d", len(sets))
+	}
+	checkFile(t, filepath.Join(backupDir, sets[0], file), "second", 0600)
+}
+
+func mustBackupSet(t *testing.T, backupDir string) string {
+	sets, err := backupSets(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("expected 1 backup but got %d", len(sets))
+	}
+	return sets[0]
+}
+
+func checkFile(t *testing.T, fileName, content string, mode os.FileMode) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("unable to read %q [%v]", fileName, err)
+	}
+	if string(b) != content {
+		t.Errorf("expected %q to contain %q but got %q", fileName, content, string(b))
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("expected %q to have mode %v but got %v", fileName, mode, info.Mode().Perm())
+	}
+}
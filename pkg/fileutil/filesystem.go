@@ -0,0 +1,51 @@
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// Filesystem is where host files are read and written when bootstrapping
+// (allowing changes to be recorded instead of made)
+type Filesystem interface {
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, content []byte, mode os.FileMode) error
+	WriteFilesAtomically(files []AtomicFile, backupDir string) error
+	CopyFile(src, dst string) error
+	SymlinkFile(tgt, ln string) error
+	MkdirAll(dir string, mode os.FileMode) error
+	Remove(path string) error
+}
+
+// OS is the host filesystem
+var OS Filesystem = osFilesystem{}
+
+type osFilesystem struct{}
+
+func (osFilesystem) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func (osFilesystem) WriteFile(path string, content []byte, mode os.FileMode) error {
+	return ioutil.WriteFile(path, content, mode)
+}
+
+func (osFilesystem) WriteFilesAtomically(files []AtomicFile, backupDir string) error {
+	return WriteFilesAtomically(files, backupDir)
+}
+
+func (osFilesystem) CopyFile(src, dst string) error {
+	return CopyFile(src, dst)
+}
+
+func (osFilesystem) SymlinkFile(tgt, ln string) error {
+	return SymlinkFile(tgt, ln)
+}
+
+func (osFilesystem) MkdirAll(dir string, mode os.FileMode) error {
+	return os.MkdirAll(dir, mode)
+}
+
+func (osFilesystem) Remove(path string) error {
+	return os.Remove(path)
+}
@@ -1,40 +1,226 @@
 package k8client
 
 import (
-	"os/exec"
-	"strings"
 	"fmt"
-	log "github.com/Sirupsen/logrus"
+	"io"
+	"path"
+	"strings"
 
+	log "github.com/Sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 )
 
-const cmdKubectl string = "kubectl"
+// decodeBufferSize is the buffer used to find the start of each yaml or json document
+const decodeBufferSize = 4096
+
+// Applier creates or updates the kubernetes resources in a yaml string (allowing them to be recorded instead)
+type Applier interface {
+	Apply(resource string) error
+	// Prune deletes the objects labelled for a component which aren't in a yaml string
+	Prune(component, resource string) error
+	// Delete removes the objects in a yaml string
+	Delete(resource string) error
+}
+
+// Client applies resources (and waits for them) using the kubernetes API
+type Client struct {
+	clientset kubernetes.Interface
+	discovery discovery.DiscoveryInterface
+	pool      dynamic.ClientPool
+	// resources caches the API resources served for each group version
+	resources map[string]*metav1.APIResourceList
+}
+
+// verify the concrete implementation satisfies the abstract interface
+var _ Applier = (*Client)(nil)
+
+// ObjectError is the error applying a single object
+type ObjectError struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Err       error
+}
+
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("error applying %s [%v]", describe(e.Kind, e.Namespace, e.Name), e.Err)
+}
+
+// ApplyError is returned by Apply with the error for each object which failed
+type ApplyError []*ObjectError
+
+func (e ApplyError) Error() string {
+	errs := make([]string, len(e))
+	for i, err := range e {
+		errs[i] = err.Error()
+	}
+	return strings.Join(errs, "; ")
+}
+
+// New creates a client using a kubeconfig file
+func New(kubeConfigPath string) (*Client, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading kubeconfig %q [%v]", kubeConfigPath, err)
+	}
+	cs, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		clientset: cs,
+		discovery: cs.Discovery(),
+		pool:      dynamic.NewDynamicClientPool(config),
+		resources: make(map[string]*metav1.APIResourceList),
+	}, nil
+}
+
+// NewAdmin creates a client using the admin kubeconfig
+func NewAdmin() (*Client, error) {
+	return New(path.Join(kubeadmapi.GlobalEnvParams.KubernetesDir, kubeadmconstants.AdminKubeConfigFileName))
+}
+
+// NewKubelet creates a client using the kubelet kubeconfig (with the identity of the node)
+func NewKubelet() (*Client, error) {
+	return New(KubeletKubeConfigPath())
+}
+
+// KubeletKubeConfigPath is the kubelet kubeconfig (only written on compute nodes once the kubelet has joined)
+func KubeletKubeConfigPath() string {
+	return path.Join(kubeadmapi.GlobalEnvParams.KubernetesDir, kubeadmconstants.KubeletKubeConfigFileName)
+}
 
-// Apply - Will take a yaml string and deploy it to the API...
-// TODO: Use API, remove kubectl (add parse yaml and use appropriate type - maybe?)
-func Apply(resource string) (error) {
-	var args = []string {
-		"apply",
-		"-f",
-	    "-",
+// Apply - Will create (or update) each object in a yaml string
+// Existing objects are updated with a merge patch (server side apply isn't available from the API
+// servers supported). All objects are applied and an ApplyError returned for any which failed.
+func (c *Client) Apply(resource string) error {
+	objs, err := Decode(resource)
+	if err != nil {
+		return err
 	}
+	var errs ApplyError
+	for _, obj := range objs {
+		if err := c.applyObject(obj); err != nil {
+			errs = append(errs, &ObjectError{
+				Kind:      obj.GetKind(),
+				Namespace: obj.GetNamespace(),
+				Name:      obj.GetName(),
+				Err:       err,
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Decode returns the objects in a (multi-document) yaml or json string
+func Decode(resource string) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(resource), decodeBufferSize)
+	var objs []*unstructured.Unstructured
+	for {
+		obj := map[string]interface{}{}
+		if err := decoder.Decode(&obj); err == io.EOF {
+			return objs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Error decoding resource %d [%v]", len(objs)+1, err)
+		}
+		if len(obj) == 0 {
+			// Empty document
+			continue
+		}
+		u := &unstructured.Unstructured{Object: obj}
+		if len(u.GetAPIVersion()) == 0 || len(u.GetKind()) == 0 || len(u.GetName()) == 0 {
+			return nil, fmt.Errorf("Resource %d must have an apiVersion, kind and name", len(objs)+1)
+		}
+		objs = append(objs, u)
+	}
+}
 
-	output, err :=	runKubectl(args, resource)
+// applyObject creates an object or patches it if it already exists
+func (c *Client) applyObject(obj *unstructured.Unstructured) error {
+	rc, err := c.resourceClient(obj)
+	if err != nil {
+		return err
+	}
+	target := describe(obj.GetKind(), obj.GetNamespace(), obj.GetName())
+	_, err = rc.Create(obj)
+	if err == nil {
+		log.Printf("Created %s", target)
+		return nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	patch, err := obj.MarshalJSON()
 	if err != nil {
-		return fmt.Errorf("Error running kubectl:%s", output)
+		return err
 	}
+	if _, err = rc.Patch(obj.GetName(), types.MergePatchType, patch); err != nil {
+		return err
+	}
+	log.Printf("Updated %s", target)
 	return nil
 }
 
-func runKubectl(cmdArgs []string, stdIn string) (out string, err error) {
-	var cmdOut []byte
+// resourceClient returns a client for the objects kind (in the objects namespace)
+func (c *Client) resourceClient(obj *unstructured.Unstructured) (*dynamic.ResourceClient, error) {
+	gvk := obj.GroupVersionKind()
+	resource, err := c.resourceFor(gvk)
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.pool.ClientForGroupVersionKind(gvk)
+	if err != nil {
+		return nil, err
+	}
+	namespace := ""
+	if resource.Namespaced {
+		namespace = obj.GetNamespace()
+		if len(namespace) == 0 {
+			namespace = metav1.NamespaceDefault
+		}
+	}
+	return client.Resource(resource, namespace), nil
+}
+
+// resourceFor finds the API resource serving a kind
+func (c *Client) resourceFor(gvk schema.GroupVersionKind) (*metav1.APIResource, error) {
+	groupVersion := gvk.GroupVersion().String()
+	resources, ok := c.resources[groupVersion]
+	if !ok {
+		var err error
+		if resources, err = c.discovery.ServerResourcesForGroupVersion(groupVersion); err != nil {
+			return nil, fmt.Errorf("Error discovering resources for %q [%v]", groupVersion, err)
+		}
+		c.resources[groupVersion] = resources
+	}
+	for i := range resources.APIResources {
+		resource := resources.APIResources[i]
+		// Ignore sub resources (e.g. deployments/scale)
+		if resource.Kind == gvk.Kind && !strings.Contains(resource.Name, "/") {
+			return &resource, nil
+		}
+	}
+	return nil, fmt.Errorf("Kind %q is not served by the API server", gvk.Kind+"."+groupVersion)
+}
 
-	cmdName := cmdKubectl
-	log.Printf("Running:%v %v", cmdName, strings.Join(cmdArgs, " "))
-	cmd := exec.Command(cmdName, cmdArgs...)
-	cmd.Stdin = strings.NewReader(stdIn)
-	if cmdOut, err = cmd.CombinedOutput(); err != nil {
-		return string(cmdOut[:]), err
+// describe names an object as Kind/[namespace/]name
+func describe(kind, namespace, name string) string {
+	if len(namespace) > 0 {
+		return kind + "/" + namespace + "/" + name
 	}
-	return string(cmdOut[:]), nil
+	return kind + "/" + name
 }
@@ -0,0 +1,64 @@
+package k8client
+
+//go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto-k8/pkg/k8client -name=Applier
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	resources := `
+# A comment before the first document
+---
+kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1beta1
+metadata:
+  name: keto-tokens
+---
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: keto-tokens
+  namespace: kube-system
+`
+	objs, err := Decode(resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("expected 2 objects but got %d", len(objs))
+	}
+	if objs[0].GetKind() != "ClusterRole" || objs[0].GroupVersionKind().Group != "rbac.authorization.k8s.io" {
+		t.Errorf("unexpected first object %v", objs[0].GroupVersionKind())
+	}
+	if objs[1].GetNamespace() != "kube-system" || objs[1].GetName() != "keto-tokens" {
+		t.Errorf("unexpected second object %s/%s", objs[1].GetNamespace(), objs[1].GetName())
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, resources := range []string{
+		"kind: ServiceAccount\nmetadata:\n  name: no-version\n",
+		"apiVersion: v1\nkind: ServiceAccount\n",
+		"apiVersion: v1\nkind: [\n",
+	} {
+		if _, err := Decode(resources); err == nil {
+			t.Errorf("expected an error decoding %q", resources)
+		}
+	}
+}
+
+func TestApplyError(t *testing.T) {
+	err := ApplyError{
+		{Kind: "ClusterRole", Name: "keto-tokens", Err: errors.New("forbidden")},
+		{Kind: "DaemonSet", Namespace: "kube-system", Name: "keto-tokens", Err: errors.New("invalid")},
+	}
+	for _, expected := range []string{"ClusterRole/keto-tokens [forbidden]", "DaemonSet/kube-system/keto-tokens [invalid]"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err.Error())
+		}
+	}
+}
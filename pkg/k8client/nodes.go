@@ -0,0 +1,171 @@
+package k8client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+	policy "k8s.io/client-go/pkg/apis/policy/v1beta1"
+)
+
+// mirrorPodAnnotation marks the API server copy of a static pod (which can't be evicted)
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// NodeManager drains nodes and annotates them (e.g. to signal the kmm supervising a node)
+type NodeManager interface {
+	Nodes() ([]v1.Node, error)
+	Node(name string) (*v1.Node, error)
+	Cordon(name string, unschedulable bool) error
+	Drain(name string, timeout time.Duration) error
+	AnnotateNode(name string, annotations map[string]string) error
+	WaitForNodeAnnotation(name, key, value string, timeout time.Duration) error
+}
+
+// verify the concrete implementation satisfies the abstract interface
+var _ NodeManager = (*Client)(nil)
+
+// Nodes returns every registered node
+func (c *Client) Nodes() ([]v1.Node, error) {
+	nodes, err := c.clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing nodes [%v]", err)
+	}
+	return nodes.Items, nil
+}
+
+// Node returns a registered node
+func (c *Client) Node(name string) (*v1.Node, error) {
+	node, err := c.clientset.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Error getting node %s [%v]", name, err)
+	}
+	return node, nil
+}
+
+// Cordon marks a node as unschedulable (or schedulable again)
+func (c *Client) Cordon(name string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	if _, err := c.clientset.CoreV1().Nodes().Patch(name, types.MergePatchType, []byte(patch)); err != nil {
+		return fmt.Errorf("Error updating node %s [%v]", name, err)
+	}
+	if unschedulable {
+		log.Printf("Cordoned node %s", name)
+	} else {
+		log.Printf("Uncordoned node %s", name)
+	}
+	return nil
+}
+
+// Drain evicts the pods from a node and waits until they're deleted
+// Pods of DaemonSets, static pods and finished pods are left. Evictions refused by a pod disruption
+// budget are retried until the timeout.
+func (c *Client) Drain(name string, timeout time.Duration) error {
+	pods, err := c.clientset.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", name).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("Error listing pods on node %s [%v]", name, err)
+	}
+	var evict []v1.Pod
+	for _, pod := range pods.Items {
+		if drainable(pod) {
+			evict = append(evict, pod)
+		}
+	}
+	log.Printf("Draining node %s, evicting %d pods...", name, len(evict))
+	var last string
+	err = wait.PollImmediate(pollInterval, timeout, func() (bool, error) {
+		var remaining []string
+		for _, pod := range evict {
+			current, err := c.clientset.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+				continue
+			}
+			target := describe("Pod", pod.Namespace, pod.Name)
+			remaining = append(remaining, target)
+			if err != nil || current.DeletionTimestamp != nil {
+				continue
+			}
+			err = c.clientset.CoreV1().Pods(pod.Namespace).Evict(&policy.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			})
+			if err == nil || apierrors.IsNotFound(err) {
+				continue
+			}
+			if !apierrors.IsTooManyRequests(err) {
+				return false, fmt.Errorf("Error evicting %s [%v]", target, err)
+			}
+			// Refused by a disruption budget
+			last = fmt.Sprintf("evicting %s [%v]", target, err)
+		}
+		if len(remaining) > 0 {
+			if len(last) == 0 {
+				last = fmt.Sprintf("pods not deleted %v", remaining)
+			}
+			return false, nil
+		}
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("node %s not drained after %v (%s)", name, timeout, last)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Drained node %s", name)
+	return nil
+}
+
+// AnnotateNode sets annotations on a node (leaving any others)
+func (c *Client) AnnotateNode(name string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = c.clientset.CoreV1().Nodes().Patch(name, types.MergePatchType, patch); err != nil {
+		return fmt.Errorf("Error annotating node %s [%v]", name, err)
+	}
+	return nil
+}
+
+// WaitForNodeAnnotation waits until a node is ready with an annotation set to value
+func (c *Client) WaitForNodeAnnotation(name, key, value string, timeout time.Duration) error {
+	return poll(describe("Node", "", name), timeout, func() (string, error) {
+		node, err := c.clientset.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		if node.Annotations[key] != value {
+			return fmt.Sprintf("waiting for %s=%s", key, value), nil
+		}
+		if !nodeReady(*node) {
+			return "node not ready", nil
+		}
+		return "", nil
+	})
+}
+
+// drainable is true for pods which are rescheduled elsewhere when evicted
+func drainable(pod v1.Pod) bool {
+	if _, mirror := pod.Annotations[mirrorPodAnnotation]; mirror {
+		return false
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" && ref.Controller != nil && *ref.Controller {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package k8client
+
+//go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto-k8/pkg/k8client -name=NodeManager
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestDrainable(t *testing.T) {
+	controller := true
+	pods := map[string]v1.Pod{
+		"deployment pod": {
+			ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Controller: &controller},
+			}},
+		},
+		"unowned pod": {},
+	}
+	for name, pod := range pods {
+		if !drainable(pod) {
+			t.Errorf("expected a %s to be drained", name)
+		}
+	}
+
+	pods = map[string]v1.Pod{
+		"daemonset pod": {
+			ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+				{Kind: "DaemonSet", Controller: &controller},
+			}},
+		},
+		"mirror pod": {
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{mirrorPodAnnotation: "hash"}},
+		},
+		"finished pod": {
+			Status: v1.PodStatus{Phase: v1.PodSucceeded},
+		},
+	}
+	for name, pod := range pods {
+		if drainable(pod) {
+			t.Errorf("expected a %s not to be drained", name)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package k8client
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ghodss/yaml"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// The labels identifying the objects kmm applies
+const (
+	ManagedByLabel = "managed-by"
+	ComponentLabel = "kmm-component"
+	RevisionLabel  = "kmm-revision"
+	// ManagedBy is the value of the managed by label
+	ManagedBy = "kmm"
+)
+
+// revisionLength is the number of hex characters of the manifest hash used as a revision
+const revisionLength = 10
+
+// ComponentLabels are the labels selecting every object applied for a component
+func ComponentLabels(component string) map[string]string {
+	return map[string]string{
+		ManagedByLabel: ManagedBy,
+		ComponentLabel: component,
+	}
+}
+
+// Label adds the ownership labels of a component to each object in a yaml string
+// The revision is a hash of the resources (before they're labelled).
+func Label(component, resource string) (string, error) {
+	objs, err := Decode(resource)
+	if err != nil {
+		return "", err
+	}
+	revision := fmt.Sprintf("%x", sha256.Sum256([]byte(resource)))[:revisionLength]
+	docs := make([]string, len(objs))
+	for i, obj := range objs {
+		objLabels := obj.GetLabels()
+		if objLabels == nil {
+			objLabels = make(map[string]string)
+		}
+		for k, v := range ComponentLabels(component) {
+			objLabels[k] = v
+		}
+		objLabels[RevisionLabel] = revision
+		obj.SetLabels(objLabels)
+		// Each document is yaml as a json document would switch the decoder to a single json stream
+		doc, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return "", err
+		}
+		docs[i] = string(doc)
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// ApplyAndPrune labels and applies the resources of a component then deletes any objects
+// applied for it before which are no longer present
+// Nothing is pruned unless every object is applied.
+func ApplyAndPrune(a Applier, component, resource string) error {
+	labelled, err := Label(component, resource)
+	if err != nil {
+		return err
+	}
+	if err = a.Apply(labelled); err != nil {
+		return err
+	}
+	return a.Prune(component, labelled)
+}
+
+// Prune deletes the objects labelled for a component which aren't in a yaml string
+// Every kind the API server can list and delete is checked.
+func (c *Client) Prune(component, resource string) error {
+	keep, err := c.objectNames(resource)
+	if err != nil {
+		return err
+	}
+	resourceLists, err := c.discovery.ServerPreferredResources()
+	if err != nil {
+		if len(resourceLists) == 0 {
+			return fmt.Errorf("Error discovering resources to prune [%v]", err)
+		}
+		log.Warnf("Not all resources could be discovered, some may not be pruned [%v]", err)
+	}
+	selector := labels.SelectorFromSet(ComponentLabels(component)).String()
+	// The same objects can be served by more than one group (e.g. deployments)
+	pruned := map[string]bool{}
+	var errs ApplyError
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return err
+		}
+		for i := range list.APIResources {
+			resource := list.APIResources[i]
+			if strings.Contains(resource.Name, "/") || !hasVerbs(resource, "list", "delete") {
+				continue
+			}
+			client, err := c.pool.ClientForGroupVersionKind(gv.WithKind(resource.Kind))
+			if err != nil {
+				return err
+			}
+			listed, err := client.Resource(&resource, metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return fmt.Errorf("Error listing %s to prune [%v]", resource.Name, err)
+			}
+			items, err := meta.ExtractList(listed)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				obj, err := meta.Accessor(item)
+				if err != nil {
+					return err
+				}
+				target := describe(resource.Kind, obj.GetNamespace(), obj.GetName())
+				if keep[target] || pruned[target] {
+					continue
+				}
+				pruned[target] = true
+				if err = deleteObject(client.Resource(&resource, obj.GetNamespace()), obj.GetName()); err != nil {
+					errs = append(errs, &ObjectError{
+						Kind:      resource.Kind,
+						Namespace: obj.GetNamespace(),
+						Name:      obj.GetName(),
+						Err:       err,
+					})
+					continue
+				}
+				log.Printf("Pruned %s", target)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Delete removes each object in a yaml string (in reverse order, ignoring any not found)
+// All objects are deleted and an ApplyError returned for any which failed.
+func (c *Client) Delete(resource string) error {
+	objs, err := Decode(resource)
+	if err != nil {
+		return err
+	}
+	var errs ApplyError
+	for i := len(objs) - 1; i >= 0; i-- {
+		obj := objs[i]
+		target := describe(obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		rc, err := c.resourceClient(obj)
+		if err == nil {
+			err = deleteObject(rc, obj.GetName())
+		}
+		if err != nil {
+			errs = append(errs, &ObjectError{
+				Kind:      obj.GetKind(),
+				Namespace: obj.GetNamespace(),
+				Name:      obj.GetName(),
+				Err:       err,
+			})
+			continue
+		}
+		log.Printf("Deleted %s", target)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Without returns the objects in a yaml string which aren't in another (e.g. to delete objects
+// no longer required without deleting any objects sharing the same name)
+func Without(resource, other string) (string, error) {
+	objs, err := Decode(resource)
+	if err != nil {
+		return "", err
+	}
+	others, err := Decode(other)
+	if err != nil {
+		return "", err
+	}
+	exclude := map[string]bool{}
+	for _, obj := range others {
+		exclude[describe(obj.GetKind(), obj.GetNamespace(), obj.GetName())] = true
+	}
+	var docs []string
+	for _, obj := range objs {
+		if exclude[describe(obj.GetKind(), obj.GetNamespace(), obj.GetName())] {
+			continue
+		}
+		// yaml documents (see Label)
+		doc, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(doc))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// deleteObject removes an object (and any objects it owns e.g. the pods of a DaemonSet)
+func deleteObject(rc *dynamic.ResourceClient, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := rc.Delete(name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// objectNames returns the names of the objects in a yaml string as Kind/[namespace/]name
+func (c *Client) objectNames(resource string) (map[string]bool, error) {
+	objs, err := Decode(resource)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, obj := range objs {
+		namespace := obj.GetNamespace()
+		if len(namespace) == 0 {
+			r, err := c.resourceFor(obj.GroupVersionKind())
+			if err != nil {
+				return nil, err
+			}
+			if r.Namespaced {
+				namespace = metav1.NamespaceDefault
+			}
+		}
+		names[describe(obj.GetKind(), namespace, obj.GetName())] = true
+	}
+	return names, nil
+}
+
+func hasVerbs(resource metav1.APIResource, verbs ...string) bool {
+	for _, verb := range verbs {
+		found := false
+		for _, v := range resource.Verbs {
+			if v == verb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package k8client
+
+import (
+	"errors"
+	"testing"
+)
+
+// testApplier records the resources applied, pruned and deleted
+type testApplier struct {
+	applied string
+	pruned  string
+	deleted string
+	err     error
+}
+
+func (a *testApplier) Apply(resource string) error {
+	a.applied = resource
+	return a.err
+}
+
+func (a *testApplier) Prune(component, resource string) error {
+	a.pruned = component
+	return nil
+}
+
+func (a *testApplier) Delete(resource string) error {
+	a.deleted = resource
+	return nil
+}
+
+const testResources = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kube-flannel-cfg
+  namespace: kube-system
+  labels:
+    app: flannel
+---
+apiVersion: rbac.authorization.k8s.io/v1beta1
+kind: ClusterRole
+metadata:
+  name: flannel
+`
+
+func TestLabel(t *testing.T) {
+	labelled, err := Label("network", testResources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := Decode(labelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 {
+		t.Fatalf("expected 2 labelled objects but got %d", len(objs))
+	}
+	revision := objs[0].GetLabels()[RevisionLabel]
+	if len(revision) != revisionLength {
+		t.Errorf("expected a revision label but got %q", revision)
+	}
+	for _, obj := range objs {
+		labels := obj.GetLabels()
+		if labels[ManagedByLabel] != ManagedBy || labels[ComponentLabel] != "network" || labels[RevisionLabel] != revision {
+			t.Errorf("expected %s/%s labelled but got %v", obj.GetKind(), obj.GetName(), labels)
+		}
+	}
+	if objs[0].GetLabels()["app"] != "flannel" {
+		t.Errorf("expected existing labels kept but got %v", objs[0].GetLabels())
+	}
+
+	// The revision changes with the resources
+	relabelled, err := Label("network", testResources+"  annotations:\n    changed: \"true\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err = Decode(relabelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objs[0].GetLabels()[RevisionLabel] == revision {
+		t.Errorf("expected a new revision for changed resources")
+	}
+}
+
+func TestApplyAndPrune(t *testing.T) {
+	a := &testApplier{}
+	if err := ApplyAndPrune(a, "network", testResources); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.applied) == 0 || a.pruned != "network" {
+		t.Errorf("expected resources applied and pruned but got applied:%q pruned:%q", a.applied, a.pruned)
+	}
+
+	a = &testApplier{err: errors.New("forbidden")}
+	if err := ApplyAndPrune(a, "network", testResources); err != a.err {
+		t.Errorf("expected %v but got %v", a.err, err)
+	}
+	if len(a.pruned) > 0 {
+		t.Error("expected nothing pruned when the resources can't be applied")
+	}
+}
+
+func TestWithout(t *testing.T) {
+	other := `
+apiVersion: rbac.authorization.k8s.io/v1beta1
+kind: ClusterRole
+metadata:
+  name: flannel
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kube-flannel-cfg
+  namespace: default
+`
+	without, err := Without(testResources, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := Decode(without)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only objects with the same kind, namespace and name are excluded
+	if len(objs) != 1 || objs[0].GetKind() != "ConfigMap" || objs[0].GetNamespace() != "kube-system" {
+		t.Errorf("expected only the kube-system ConfigMap but got %v", objs)
+	}
+
+	if without, err = Without(testResources, testResources); err != nil || len(without) > 0 {
+		t.Errorf("expected no resources but got %q, error:%v", without, err)
+	}
+}
@@ -0,0 +1,168 @@
+package k8client
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// MasterRoleLabel marks the master nodes
+const MasterRoleLabel = "node-role.kubernetes.io/master"
+
+// pollInterval is how often the status of a workload is checked
+var pollInterval = 5 * time.Second
+
+// Waiter waits for kubernetes workloads to become ready
+type Waiter interface {
+	WaitForDaemonSet(namespace, name string, timeout time.Duration) error
+	WaitForDeployment(namespace, name string, timeout time.Duration) error
+	WaitForNodes(timeout time.Duration) error
+}
+
+// verify the concrete implementation satisfies the abstract interface
+var _ Waiter = (*Client)(nil)
+
+// WaitForDaemonSet waits until every pod scheduled by a DaemonSet is ready
+func (c *Client) WaitForDaemonSet(namespace, name string, timeout time.Duration) error {
+	target := describe("DaemonSet", namespace, name)
+	return poll(target, timeout, func() (string, error) {
+		ds, err := c.clientset.ExtensionsV1beta1().DaemonSets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		if ds.Status.ObservedGeneration < ds.Generation {
+			return "waiting for the controller to observe the latest generation", nil
+		}
+		if ds.Status.NumberReady < ds.Status.DesiredNumberScheduled {
+			return fmt.Sprintf("%d of %d pods ready", ds.Status.NumberReady, ds.Status.DesiredNumberScheduled), nil
+		}
+		return "", nil
+	})
+}
+
+// WaitForDeployment waits until all the replicas of a Deployment are available
+func (c *Client) WaitForDeployment(namespace, name string, timeout time.Duration) error {
+	target := describe("Deployment", namespace, name)
+	return poll(target, timeout, func() (string, error) {
+		d, err := c.clientset.ExtensionsV1beta1().Deployments(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		if d.Status.ObservedGeneration < d.Generation {
+			return "waiting for the controller to observe the latest generation", nil
+		}
+		var replicas int32 = 1
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		if d.Status.AvailableReplicas < replicas {
+			return fmt.Sprintf("%d of %d replicas available", d.Status.AvailableReplicas, replicas), nil
+		}
+		return "", nil
+	})
+}
+
+// WaitForNodes waits until every registered master node is ready
+// Compute nodes aren't waited for as they can be added or replaced at any time (e.g. by an autoscaling
+// group) and one which isn't ready yet mustn't stop the masters from starting.
+func (c *Client) WaitForNodes(timeout time.Duration) error {
+	return poll("master nodes", timeout, func() (string, error) {
+		nodes, err := c.clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+		if err != nil {
+			return "", err
+		}
+		return mastersStatus(nodes.Items), nil
+	})
+}
+
+// mastersStatus returns why the master nodes aren't ready (empty when they are)
+func mastersStatus(nodes []v1.Node) string {
+	masters := 0
+	var notReady []string
+	for _, node := range nodes {
+		if _, master := node.Labels[MasterRoleLabel]; !master {
+			continue
+		}
+		masters++
+		if !nodeReady(node) {
+			notReady = append(notReady, node.Name)
+		}
+	}
+	if masters == 0 {
+		return "no master nodes registered"
+	}
+	if len(notReady) > 0 {
+		return fmt.Sprintf("master nodes not ready %v", notReady)
+	}
+	return ""
+}
+
+// WaitForResources waits for each DaemonSet and Deployment in a yaml string to be ready
+// The timeout is for all the workloads.
+func WaitForResources(w Waiter, resource string, timeout time.Duration) error {
+	objs, err := Decode(resource)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for _, obj := range objs {
+		namespace := obj.GetNamespace()
+		if len(namespace) == 0 {
+			namespace = metav1.NamespaceDefault
+		}
+		switch obj.GetKind() {
+		case "DaemonSet":
+			err = w.WaitForDaemonSet(namespace, obj.GetName(), deadline.Sub(time.Now()))
+		case "Deployment":
+			err = w.WaitForDeployment(namespace, obj.GetName(), deadline.Sub(time.Now()))
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// poll checks a workload until ready (an empty status) or the timeout
+// Errors getting the status are retried (e.g. the API server is restarting) except when not found.
+func poll(target string, timeout time.Duration, status func() (string, error)) error {
+	log.Printf("Waiting for %s to be ready...", target)
+	var last string
+	err := wait.PollImmediate(pollInterval, timeout, func() (bool, error) {
+		s, err := status()
+		if apierrors.IsNotFound(err) {
+			return false, err
+		}
+		if err != nil {
+			last = err.Error()
+			return false, nil
+		}
+		last = s
+		return len(s) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("%s not ready after %v (%s)", target, timeout, last)
+	}
+	if err != nil {
+		return fmt.Errorf("error waiting for %s [%v]", target, err)
+	}
+	log.Printf("%s is ready", target)
+	return nil
+}
+
+// nodeReady is true when a node reports the ready condition
+func nodeReady(node v1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
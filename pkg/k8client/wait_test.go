@@ -0,0 +1,100 @@
+package k8client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// testWaiter records the workloads waited for
+type testWaiter struct {
+	waited []string
+	err    error
+}
+
+func (w *testWaiter) WaitForDaemonSet(namespace, name string, timeout time.Duration) error {
+	w.waited = append(w.waited, describe("DaemonSet", namespace, name))
+	return w.err
+}
+
+func (w *testWaiter) WaitForDeployment(namespace, name string, timeout time.Duration) error {
+	w.waited = append(w.waited, describe("Deployment", namespace, name))
+	return w.err
+}
+
+func (w *testWaiter) WaitForNodes(timeout time.Duration) error {
+	return w.err
+}
+
+func TestWaitForResources(t *testing.T) {
+	resources := `
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: flannel
+  namespace: kube-system
+---
+apiVersion: extensions/v1beta1
+kind: DaemonSet
+metadata:
+  name: kube-flannel-ds
+  namespace: kube-system
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+`
+	w := &testWaiter{}
+	if err := WaitForResources(w, resources, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"DaemonSet/kube-system/kube-flannel-ds", "Deployment/default/web"}
+	if len(w.waited) != len(expected) || w.waited[0] != expected[0] || w.waited[1] != expected[1] {
+		t.Errorf("expected to wait for %v but waited for %v", expected, w.waited)
+	}
+
+	w = &testWaiter{err: errors.New("not ready")}
+	if err := WaitForResources(w, resources, time.Minute); err != w.err {
+		t.Errorf("expected %v but got %v", w.err, err)
+	}
+	if len(w.waited) != 1 {
+		t.Errorf("expected to stop waiting after the first error but waited for %v", w.waited)
+	}
+}
+
+func TestNodeReady(t *testing.T) {
+	node := v1.Node{}
+	if nodeReady(node) {
+		t.Error("expected a node without conditions not to be ready")
+	}
+	node.Status.Conditions = []v1.NodeCondition{
+		{Type: v1.NodeOutOfDisk, Status: v1.ConditionFalse},
+		{Type: v1.NodeReady, Status: v1.ConditionTrue},
+	}
+	if !nodeReady(node) {
+		t.Error("expected a node with the ready condition to be ready")
+	}
+}
+
+func TestMastersStatus(t *testing.T) {
+	ready := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	master := v1.Node{}
+	master.Name = "master"
+	master.Labels = map[string]string{MasterRoleLabel: ""}
+	compute := v1.Node{}
+	compute.Name = "compute"
+
+	if status := mastersStatus([]v1.Node{compute}); len(status) == 0 {
+		t.Error("expected to wait for a master to register")
+	}
+	if status := mastersStatus([]v1.Node{master, compute}); len(status) == 0 {
+		t.Error("expected to wait for the master to be ready")
+	}
+	master.Status.Conditions = ready
+	if status := mastersStatus([]v1.Node{master, compute}); len(status) != 0 {
+		t.Errorf("expected a compute node not ready to be ignored but got %q", status)
+	}
+}
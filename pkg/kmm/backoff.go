@@ -0,0 +1,39 @@
+package kmm
+
+import (
+	"math/rand"
+	"time"
+)
+
+// initialBackOff is the first delay after a failure (unless max is shorter)
+const initialBackOff time.Duration = time.Second
+
+// backOff provides exponentially increasing delays (with jitter) up to a maximum
+type backOff struct {
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackOff(max time.Duration) *backOff {
+	return &backOff{max: max}
+}
+
+// next returns the next delay, a random duration between half and all of the current back off
+func (b *backOff) next() time.Duration {
+	switch {
+	case b.current == 0:
+		b.current = initialBackOff
+	default:
+		b.current = b.current * 2
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+	half := int64(b.current / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// reset starts the back off from the beginning again
+func (b *backOff) reset() {
+	b.current = 0
+}
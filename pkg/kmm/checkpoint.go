@@ -0,0 +1,116 @@
+package kmm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/envelope"
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+)
+
+// checkpointPrefix is the prefix of the keys recording each completed bootstrap phase
+const checkpointPrefix string = "kmm-bootstrap/"
+
+// pkiPhase is the name of the first bootstrap phase, creating the shared assets
+const pkiPhase string = "pki"
+
+// checkpoint is the record of a completed bootstrap phase
+type checkpoint struct {
+	Completed   time.Time `json:"completed"`
+	CompletedBy string    `json:"completedBy"`
+	// Assets are the encrypted shared assets created by the pki phase
+	Assets string `json:"assets,omitempty"`
+}
+
+// bootstrapPKI creates the PKI or restores the shared assets created by a previous lock holder
+func (k *Config) bootstrapPKI() (assets string, err error) {
+	key, err := checkpointKey(Phase{Name: pkiPhase})
+	if err != nil {
+		return "", err
+	}
+	cp, err := k.getCheckpoint(key)
+	if err != nil && err != etcd.ErrKeyMissing {
+		return "", err
+	}
+	if err == nil {
+		log.Printf("Resuming bootstrap with PKI created by %q at %v", cp.CompletedBy, cp.Completed)
+		if assets, err = k.openSharedAssets(cp.Assets); err == envelope.ErrNotSealed {
+			return "", fmt.Errorf("shared assets in checkpoint %q are not encrypted, refusing to use them", key)
+		}
+		if err != nil {
+			return "", err
+		}
+		if err = k.Kubeadm.SaveAssets(assets); err != nil {
+			return "", err
+		}
+		// Creates any certs for this host (signed by the shared assets)
+		if err = k.Kubeadm.CreatePKI(); err != nil {
+			return "", err
+		}
+		return assets, nil
+	}
+
+	if err = k.Kubeadm.CreatePKI(); err != nil {
+		return "", err
+	}
+	// Load assets off disk and serialise
+	if assets, err = k.Kubeadm.LoadAndSerializeAssets(); err != nil {
+		return "", err
+	}
+	sealed, err := envelope.Seal(k.AssetKeyProvider, []byte(assets))
+	if err != nil {
+		return "", err
+	}
+	if err = k.recordCheckpoint(key, sealed); err != nil {
+		return "", err
+	}
+	return assets, nil
+}
+
+// getCheckpoint returns etcd.ErrKeyMissing when a phase hasn't completed
+func (k *Config) getCheckpoint(key string) (cp checkpoint, err error) {
+	value, err := k.Etcd.Get(key)
+	if err != nil {
+		return cp, err
+	}
+	if err = json.Unmarshal([]byte(value), &cp); err != nil {
+		return cp, fmt.Errorf("bootstrap checkpoint %q could not be parsed [%v]", key, err)
+	}
+	return cp, nil
+}
+
+// recordCheckpoint records a phase as complete (only while still holding the lock)
+func (k *Config) recordCheckpoint(key, sealedAssets string) (err error) {
+	hostName, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	cpBytes, err := json.Marshal(checkpoint{
+		Completed:   time.Now().UTC(),
+		CompletedBy: hostName,
+		Assets:      sealedAssets,
+	})
+	if err != nil {
+		return err
+	}
+	// The lock is checked in the same transaction so a stale primary can never overwrite a checkpoint
+	if err = k.Etcd.PutLocked(key, string(cpBytes), assetLockKey); err == etcd.ErrLockChanged {
+		return fmt.Errorf("lost lock %q whilst bootstrapping, not recording checkpoint %q", assetLockKey, key)
+	}
+	return err
+}
+
+// checkpointKey is per cluster except for local phases (per host)
+func checkpointKey(phase Phase) (string, error) {
+	if !phase.Local {
+		return checkpointPrefix + phase.Name, nil
+	}
+	hostName, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return checkpointPrefix + phase.Name + "/" + hostName, nil
+}
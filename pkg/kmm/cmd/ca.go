@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/certs"
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+	"github.com/spf13/cobra"
+)
+
+// caForceFlagName is the flag required to replace existing CAs
+const caForceFlagName string = "force"
+
+// caValidityFlagName is how long new CAs are valid for
+const caValidityFlagName string = "validity"
+
+// caKeyTypeFlagName is the type of key generated for new CAs
+const caKeyTypeFlagName string = "key-type"
+
+// caCmd represents the ca command
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the kube and etcd CAs",
+	Long:  "Manage the kube and etcd CAs kept on the persistent volume of every master",
+}
+
+// caInitCmd represents the ca init command
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create the kube and etcd CAs",
+	Long: "Create the kube CA (--kube-ca-cert / --kube-ca-key) and the etcd CA (--etcd-client-ca / --etcd-ca-key) " +
+		"for those with files specified. Existing CAs are only replaced with --" + caForceFlagName + " (the " +
+		"previous CAs are backed up to the --" + certsBackupDirFlagName + ")",
+	Run: func(c *cobra.Command, args []string) {
+		caInit(c)
+	},
+}
+
+func caInit(c *cobra.Command) {
+	cas, err := getCAs(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	force, _ := c.Flags().GetBool(caForceFlagName)
+	if err = certs.InitCAs(cas, force, c.Flag(certsBackupDirFlagName).Value.String()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// getCAs returns the kube and etcd CAs with files specified
+func getCAs(c *cobra.Command) ([]certs.CA, error) {
+	validity, err := c.Flags().GetDuration(caValidityFlagName)
+	if err != nil {
+		return nil, err
+	}
+	keyType := c.Flag(caKeyTypeFlagName).Value.String()
+	all := []certs.CA{
+		{
+			Name:     "kube",
+			CertFile: c.Flag("kube-ca-cert").Value.String(),
+			KeyFile:  c.Flag("kube-ca-key").Value.String(),
+			Config:   getCaSubject(c, "kube"),
+		},
+		{
+			Name:     "etcd",
+			CertFile: c.Flag("etcd-client-ca").Value.String(),
+			KeyFile:  c.Flag("etcd-ca-key").Value.String(),
+			Config:   getCaSubject(c, "etcd"),
+		},
+	}
+	var cas []certs.CA
+	for _, ca := range all {
+		if len(ca.CertFile) == 0 && len(ca.KeyFile) == 0 {
+			continue
+		}
+		ca.Validity = validity
+		ca.KeyType = keyType
+		cas = append(cas, ca)
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("Missing the kube CA or etcd CA files to create")
+	}
+	return cas, nil
+}
+
+// getCaSubject returns the subject of a CA from its --<name>-ca-common-name and --<name>-ca-organization flags
+func getCaSubject(c *cobra.Command, name string) certutil.Config {
+	config := certutil.Config{CommonName: c.Flag(name + "-ca-common-name").Value.String()}
+	if organization := c.Flag(name + "-ca-organization").Value.String(); len(organization) > 0 {
+		config.Organization = strings.Split(organization, ",")
+	}
+	return config
+}
+
+func init() {
+	caInitCmd.Flags().String("kube-ca-common-name", "kubernetes", "Common name of the kube CA")
+	caInitCmd.Flags().String("kube-ca-organization", "", "Organizations of the kube CA (comma separated)")
+	caInitCmd.Flags().String("etcd-ca-common-name", "etcd-ca", "Common name of the etcd CA")
+	caInitCmd.Flags().String("etcd-ca-organization", "", "Organizations of the etcd CA (comma separated)")
+	caInitCmd.Flags().Duration(caValidityFlagName, pkiutil.DefaultCaValidity, "How long the CAs are valid for")
+	caInitCmd.Flags().String(
+		caKeyTypeFlagName,
+		pkiutil.DefaultKeyType,
+		"Type of key generated for the CAs ("+strings.Join(pkiutil.KeyTypes, " / ")+")")
+	caInitCmd.Flags().Bool(caForceFlagName, false, "Replace any existing CAs (every cert they signed must be re-issued)")
+	caInitCmd.Flags().String(
+		certsBackupDirFlagName,
+		certsBackupDir,
+		"Directory any existing CAs are backed up to before being replaced")
+	caCmd.AddCommand(caInitCmd)
+	RootCmd.AddCommand(caCmd)
+}
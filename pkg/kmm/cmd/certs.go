@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/certs"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm"
+	"github.com/spf13/cobra"
+)
+
+// certsThresholdFlagName is how long before expiry a cert must be renewed
+const certsThresholdFlagName string = "threshold"
+
+// certsOutputFlagName is the format certs are described in (table / json)
+const certsOutputFlagName string = "output"
+
+// kubePkiDirFlagName is the directory of the kube pki
+const kubePkiDirFlagName string = "kube-pki-dir"
+
+// certsBackupDir is where certs are backed up before being renewed or re-issued
+var certsBackupDir = filepath.Join(kubeadm.PkiBackupDir, "certs")
+
+// certsCmd represents the certs command
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Inspect or renew the etcd and kube certs",
+	Long:  "Inspect or renew the etcd server, peer and client certs and the kube certs in " + kubeadm.PkiDir,
+}
+
+// certsCheckCmd represents the certs check command
+var certsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Print the subject, SANs, issuer and expiry of each cert",
+	Long: "Print the subject, SANs, issuer and expiry of each cert. Exits with a non-zero code when any cert " +
+		"expires within the --" + certsThresholdFlagName,
+	Run: func(c *cobra.Command, args []string) {
+		certsCheck(c)
+	},
+}
+
+// certsRenewCmd represents the certs renew command
+var certsRenewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "Re-issue the certs which expire within the threshold",
+	Long: "Re-issue the certs which expire within the --" + certsThresholdFlagName + " from the CA which " +
+		"signed them (keeping the subject, SANs, usages and key). The previous certs are backed up to the --" +
+		certsBackupDirFlagName + ". A CA can't be renewed in place so is only reported",
+	Run: func(c *cobra.Command, args []string) {
+		certsRenew(c)
+	},
+}
+
+func certsCheck(c *cobra.Command) {
+	// Keep stdout for the certs
+	log.SetOutput(os.Stderr)
+	threshold, _ := c.Flags().GetDuration(certsThresholdFlagName)
+	all, err := getCerts(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	infos, err := certs.Check(all, threshold)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch output := c.Flag(certsOutputFlagName).Value.String(); output {
+	case "table":
+		err = certs.WriteTable(os.Stdout, infos)
+	case "json":
+		err = certs.WriteJSON(os.Stdout, infos)
+	default:
+		err = fmt.Errorf("unknown certs output format %q (table / json)", output)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if expired := certs.Expired(infos); len(expired) > 0 {
+		var names []string
+		for _, info := range expired {
+			names = append(names, info.Name)
+		}
+		log.Fatalf("Certs expire within %v: %s", threshold, strings.Join(names, ", "))
+	}
+}
+
+func certsRenew(c *cobra.Command) {
+	threshold, _ := c.Flags().GetDuration(certsThresholdFlagName)
+	all, err := getCerts(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	renewed, err := certs.RenewExpiring(all, threshold, c.Flag(certsBackupDirFlagName).Value.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(renewed) == 0 {
+		log.Printf("No certs expire within %v", threshold)
+		return
+	}
+	log.Printf("Renewed certs %s, restart etcd and the kube control plane to use them", strings.Join(renewed, ", "))
+}
+
+// getCerts returns the etcd certs configured (and present) followed by the kube pki certs
+func getCerts(c *cobra.Command) ([]certs.Cert, error) {
+	caCert := c.Flag("etcd-client-ca").Value.String()
+	caKey := c.Flag("etcd-ca-key").Value.String()
+	etcdCerts := []certs.Cert{
+		{Name: "etcd-ca", CertFile: caCert, KeyFile: caKey},
+		{
+			Name:       "etcd-server",
+			CertFile:   c.Flag("etcd-server-cert").Value.String(),
+			KeyFile:    c.Flag("etcd-server-key").Value.String(),
+			CaCertFile: caCert,
+			CaKeyFile:  caKey,
+		},
+		{
+			Name:       "etcd-peer",
+			CertFile:   c.Flag("etcd-peer-cert").Value.String(),
+			KeyFile:    c.Flag("etcd-peer-key").Value.String(),
+			CaCertFile: caCert,
+			CaKeyFile:  caKey,
+		},
+		{
+			Name:       "etcd-client",
+			CertFile:   c.Flag("etcd-client-cert").Value.String(),
+			KeyFile:    c.Flag("etcd-client-key").Value.String(),
+			CaCertFile: caCert,
+			CaKeyFile:  caKey,
+		},
+	}
+	var all []certs.Cert
+	for _, cert := range etcdCerts {
+		if len(cert.CertFile) == 0 || !fileutil.ExistFile(cert.CertFile) {
+			continue
+		}
+		all = append(all, cert)
+	}
+	kubeCerts, err := certs.KubePki(c.Flag(kubePkiDirFlagName).Value.String())
+	if err != nil {
+		return nil, err
+	}
+	return append(all, kubeCerts...), nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{certsCheckCmd, certsRenewCmd} {
+		addEtcdCertFlags(c)
+		c.Flags().Duration(
+			certsThresholdFlagName,
+			certs.DefaultThreshold,
+			"Certs which expire within this time must be renewed")
+		c.Flags().String(kubePkiDirFlagName, kubeadm.PkiDir, "Directory of the kube certs")
+	}
+	certsRenewCmd.Flags().String(
+		certsBackupDirFlagName,
+		certsBackupDir,
+		"Directory the previous certs are backed up to")
+	certsCheckCmd.Flags().StringP(certsOutputFlagName, "o", "table", "Output format (table / json)")
+	certsCmd.AddCommand(certsCheckCmd)
+	certsCmd.AddCommand(certsRenewCmd)
+	RootCmd.AddCommand(certsCmd)
+}
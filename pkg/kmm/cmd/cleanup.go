@@ -21,6 +21,7 @@ func cleanUp(c *cobra.Command) {
 	if err == nil {
 		k := kmm.New(cfg)
 		err = k.Kmm.CleanUp(true, true)
+		k.Etcd.Close()
 	}
 	if err != nil {
 		log.Fatal(err)
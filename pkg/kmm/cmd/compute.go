@@ -3,6 +3,7 @@ package cmd
 import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/UKHomeOffice/keto-k8/pkg/kmm"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm"
 	"github.com/spf13/cobra"
 )
 
@@ -18,15 +19,31 @@ var computeCmd = &cobra.Command{
 
 func setupCompute(c *cobra.Command) {
 	exitOnCompletion, _ := c.Flags().GetBool(ExitOnCompletionFlagName)
-	err := kmm.SetupCompute(
-		c.Flag("cloud-provider").Value.String(),
-		exitOnCompletion,
-	)
+	reconcilePeriod, _ := c.Flags().GetDuration(reconcilePeriodFlagName)
+	phases, _ := c.Flags().GetStringSlice(phasesFlagName)
+	skipPhases, _ := c.Flags().GetStringSlice(skipPhasesFlagName)
+	dryRun := getDryRun(c)
+	err := kmm.SetupCompute(kmm.Config{
+		ConfigType: kmm.ConfigType{
+			KubeadmCfg: &kubeadm.Config{
+				CloudProvider: c.Flag("cloud-provider").Value.String(),
+			},
+			ExitOnCompletion: exitOnCompletion,
+			ReconcilePeriod:  reconcilePeriod,
+			Phases:           phases,
+			SkipPhases:       skipPhases,
+			DryRun:           dryRun,
+		},
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err = writeDryRun(c, dryRun); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func init() {
+	addDryRunFlags(computeCmd)
 	RootCmd.AddCommand(computeCmd)
 }
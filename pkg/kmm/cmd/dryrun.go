@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/dryrun"
+	"github.com/spf13/cobra"
+)
+
+// dryRunFlagName is the flag to record changes instead of making them
+const dryRunFlagName string = "dry-run"
+
+// dryRunOutputFlagName is the format of the changes recorded (text / json)
+const dryRunOutputFlagName string = "output"
+
+// addDryRunFlags adds the dry run flags to a bootstrap command
+func addDryRunFlags(c *cobra.Command) {
+	c.Flags().Bool(
+		dryRunFlagName,
+		false,
+		"Show the planned phases and the changes they would make (compared with this host and etcd) without making them")
+	c.Flags().StringP(dryRunOutputFlagName, "o", "text", "Dry run output format (text / json)")
+}
+
+// getDryRun returns a recorder when a dry run is requested (nil otherwise)
+func getDryRun(c *cobra.Command) *dryrun.Recorder {
+	if dryRun, _ := c.Flags().GetBool(dryRunFlagName); !dryRun {
+		return nil
+	}
+	// Keep stdout for the changes
+	log.SetOutput(os.Stderr)
+	return dryrun.New()
+}
+
+// writeDryRun writes the changes recorded by a dry run (if any) to stdout
+func writeDryRun(c *cobra.Command, r *dryrun.Recorder) error {
+	if r == nil {
+		return nil
+	}
+	switch output := c.Flag(dryRunOutputFlagName).Value.String(); output {
+	case "text":
+		return r.WriteText(os.Stdout)
+	case "json":
+		return r.WriteJSON(os.Stdout)
+	default:
+		return fmt.Errorf("unknown dry run output format %q (text / json)", output)
+	}
+}
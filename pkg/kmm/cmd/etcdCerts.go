@@ -3,18 +3,40 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/certs"
 	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
 	"github.com/spf13/cobra"
 )
 
+// renewBeforeFlagName is how long before expiry existing etcd certs are re-issued
+const renewBeforeFlagName string = "renew-before"
+
+// strictFlagName fails instead of re-issuing etcd certs which don't match their configuration
+const strictFlagName string = "strict"
+
+// certsBackupDirFlagName is where existing certs are backed up before being re-issued
+const certsBackupDirFlagName string = "backup-dir"
+
+// clientNameFlagName is the consumer the etcd client cert is issued to
+const clientNameFlagName string = "client-name"
+
+// keyTypeFlagUsage describes the etcd key type flags
+var keyTypeFlagUsage = "(" + strings.Join(pkiutil.KeyTypes, " / ") + ", default keeps existing keys and uses " +
+	pkiutil.DefaultKeyType + " for new keys)"
+
 // EtcdCertsCmd represents the command for generating etcd certs
 var EtcdCertsCmd = &cobra.Command{
 	Use:   EtcdCertsCmdName,
 	Short: "Will generate etcd certs",
-	Long:  "Will generate etcd server, peer and client certs from a specified ca",
+	Long: "Will generate etcd server and peer certs for the local member and a client cert for the --" +
+		clientNameFlagName + " from a specified ca (only the client cert is generated for a consumer other than " +
+		etcd.ClientNameKmm + "). Existing certs which don't match (e.g. issued by an older kmm to the cluster " +
+		"hostnames) are backed up and re-issued unless --" + strictFlagName,
 	Run: func(c *cobra.Command, args []string) {
 		cfg, err := getConfig(c)
 		if err == nil {
@@ -28,27 +50,57 @@ var EtcdCertsCmd = &cobra.Command{
 }
 
 func init() {
+	addEtcdCertFlags(EtcdCertsCmd)
+	EtcdCertsCmd.Flags().String(
+		"etcd-local-hostnames",
+		getDefaultFromEnvs([]string{"KMM_ETCD_LOCAL_HOSTNAMES"}, ""),
+		"ETCD hostnames (defaults: KMM_ETCD_LOCAL_HOSTNAMES or parsed from ETCD_ADVERTISE_CLIENT_URLS)")
+	EtcdCertsCmd.Flags().String(
+		"etcd-name",
+		getDefaultFromEnvs([]string{"KMM_ETCD_NAME", "ETCD_NAME"}, ""),
+		"ETCD member name used for the server and peer certs (defaults: KMM_ETCD_NAME, ETCD_NAME, the member in "+
+			"ETCD_INITIAL_CLUSTER on a local hostname or the first local hostname)")
+	EtcdCertsCmd.Flags().String(
+		clientNameFlagName,
+		etcd.ClientNameKmm,
+		"Consumer the ETCD client cert is issued to, used as its common name (e.g. "+
+			strings.Join(etcd.ClientNames, " / ")+")")
+	EtcdCertsCmd.Flags().Duration(
+		renewBeforeFlagName,
+		certs.DefaultThreshold,
+		"Re-issue existing certs which expire within this time")
+	EtcdCertsCmd.Flags().Bool(
+		strictFlagName,
+		false,
+		"Fail when existing certs don't match the hostnames, usages, CA or key (instead of re-issuing them)")
 	EtcdCertsCmd.Flags().String(
+		certsBackupDirFlagName,
+		certsBackupDir,
+		"Directory existing certs are backed up to before being re-issued")
+	EtcdCertsCmd.Flags().String("etcd-server-key-type", "", "ETCD server key type "+keyTypeFlagUsage)
+	EtcdCertsCmd.Flags().String("etcd-peer-key-type", "", "ETCD peer key type "+keyTypeFlagUsage)
+	EtcdCertsCmd.Flags().String("etcd-client-key-type", "", "ETCD client key type "+keyTypeFlagUsage)
+	RootCmd.AddCommand(EtcdCertsCmd)
+}
+
+// addEtcdCertFlags adds the etcd server and peer cert flags to a command
+func addEtcdCertFlags(c *cobra.Command) {
+	c.Flags().String(
 		"etcd-server-cert",
 		getDefaultFromEnvs([]string{"KMM_ETCD_SERVER_CERT", "ETCD_CERT_FILE"}, ""),
 		"ETCD server cert file (defaults: KMM_ETCD_SERVER_CERT / ETCD_CERT_FILE)")
-	EtcdCertsCmd.Flags().String(
+	c.Flags().String(
 		"etcd-server-key",
 		getDefaultFromEnvs([]string{"KMM_ETCD_SERVER_KEY", "ETCD_KEY_FILE"}, ""),
 		"ETCD server key file (defaults: KMM_ETCD_SERVER_KEY, ETCD_KEY_FILE)")
-	EtcdCertsCmd.Flags().String(
+	c.Flags().String(
 		"etcd-peer-cert",
 		getDefaultFromEnvs([]string{"KMM_ETCD_PEER_CERT", "ETCD_PEER_CERT_FILE"}, ""),
 		"ETCD peer cert file (defaults: KMM_ETCD_PEER_CERT, ETCD_PEER_CERT_FILE)")
-	EtcdCertsCmd.Flags().String(
+	c.Flags().String(
 		"etcd-peer-key",
 		getDefaultFromEnvs([]string{"KMM_ETCD_PEER_KEY", "ETCD_PEER_KEY_FILE"}, ""),
 		"ETCD peer key file (defaults: KMM_ETCD_PEER_KEY, ETCD_PEER_KEY_FILE)")
-	EtcdCertsCmd.Flags().String(
-		"etcd-local-hostnames",
-		getDefaultFromEnvs([]string{"KMM_ETCD_LOCAL_HOSTNAMES"}, ""),
-		"ETCD hostnames (defaults: KMM_ETCD_LOCAL_HOSTNAMES or parsed from ETCD_ADVERTISE_CLIENT_URLS)")
-	RootCmd.AddCommand(EtcdCertsCmd)
 }
 
 // GetEtcdHostNames will get the hosts names from command flags and environment variables and a minimal defaults
@@ -79,9 +131,14 @@ func getConfig(cmd *cobra.Command) (etcd.ServerConfig, error) {
 			return cfg, err
 		}
 	}
-	var etcdClusterHostnames []string
-	if etcdClusterHostnames, err = GetEtcdHostNames(cmd, minimalDefaultHosts); err != nil {
-		return cfg, err
+	etcdName := cmd.Flag("etcd-name").Value.String()
+	if len(etcdName) == 0 {
+		if etcdName, err = GetMemberNameFromInitialClusterString(os.Getenv("ETCD_INITIAL_CLUSTER"), etcdLocalHostnames); err != nil {
+			return cfg, err
+		}
+	}
+	if len(etcdName) == 0 {
+		etcdName = etcdLocalHostnames[0]
 	}
 	clientCfg, err := getEtcdClientConfig(cmd)
 	if err != nil {
@@ -93,16 +150,41 @@ func getConfig(cmd *cobra.Command) (etcd.ServerConfig, error) {
 		ServerKeyFileName:	cmd.Flag("etcd-server-key").Value.String(),
 		PeerCertFileName:	cmd.Flag("etcd-peer-cert").Value.String(),
 		PeerKeyFileName:	cmd.Flag("etcd-peer-key").Value.String(),
+		MemberName:		etcdName,
 		LocalHostNames:		etcdLocalHostnames,
-		ClusterHostNames:	etcdClusterHostnames,
+		ClientName:		cmd.Flag(clientNameFlagName).Value.String(),
 		ClientConfig:		clientCfg,
 	}
+	if cfg.RenewBefore, err = cmd.Flags().GetDuration(renewBeforeFlagName); err != nil {
+		return cfg, err
+	}
+	cfg.Strict, _ = cmd.Flags().GetBool(strictFlagName)
+	cfg.BackupDir = cmd.Flag(certsBackupDirFlagName).Value.String()
+	cfg.ServerKeyType = cmd.Flag("etcd-server-key-type").Value.String()
+	cfg.PeerKeyType = cmd.Flag("etcd-peer-key-type").Value.String()
+	cfg.ClientKeyType = cmd.Flag("etcd-client-key-type").Value.String()
+	for _, keyType := range []string{cfg.ServerKeyType, cfg.PeerKeyType, cfg.ClientKeyType} {
+		if err = pkiutil.ValidateKeyType(keyType); err != nil {
+			return cfg, err
+		}
+	}
 	if len(cfg.CaKeyFileName) == 0 {
 		return cfg, fmt.Errorf("Missing ETCD CA key, required for generating certs")
 	}
 	if len(cfg.ClientConfig.CaFileName) == 0 {
 		return cfg, fmt.Errorf("Missing ETCD CA cert, required for generating certs")
 	}
+	if len(cfg.ClientName) == 0 {
+		return cfg, fmt.Errorf("Missing --%s option", clientNameFlagName)
+	}
+	// Another consumer only needs its own client cert (kmm's client cert and the member certs are left alone)
+	if cfg.ClientName != etcd.ClientNameKmm {
+		cfg.ClientOnly = true
+		if cfg.ClientConfig.ClientCertFileName, cfg.ClientConfig.ClientKeyFileName, err = consumerClientCertFiles(cmd, cfg.ClientName); err != nil {
+			return cfg, err
+		}
+		return cfg, nil
+	}
 	if len(cfg.ServerCertFileName) == 0 {
 		return cfg, fmt.Errorf("Missing ETCD Server cert file name")
 	}
@@ -115,8 +197,26 @@ func getConfig(cmd *cobra.Command) (etcd.ServerConfig, error) {
 	if len(cfg.LocalHostNames) == 0 {
 		return cfg, fmt.Errorf("Missing --etcd-local-hostnames option or ETCD_ADVERTISE_CLIENT_URLS")
 	}
-	if len(cfg.ClusterHostNames) == 0 {
-		return cfg, fmt.Errorf("Missing --etcd-cluster-hostnames option or ETCD_INITIAL_CLUSTER")
-	}
 	return cfg, nil
 }
+
+// consumerClientCertFiles returns the client cert and key files for a consumer other than kmm
+// Unless both are set explicitly (to files other than kmm's) they're <name>-client.crt and <name>-client.key
+// alongside the kmm client cert, so issuing a cert for another consumer never overwrites kmm's own.
+func consumerClientCertFiles(cmd *cobra.Command, clientName string) (certFile, keyFile string, err error) {
+	certFlag := cmd.Flag("etcd-client-cert")
+	keyFlag := cmd.Flag("etcd-client-key")
+	if certFlag.Changed || keyFlag.Changed {
+		certFile = certFlag.Value.String()
+		keyFile = keyFlag.Value.String()
+		if certFile == certFlag.DefValue || keyFile == keyFlag.DefValue {
+			return "", "", fmt.Errorf("The --etcd-client-cert and --etcd-client-key for %s must both differ from the kmm client cert", clientName)
+		}
+		return certFile, keyFile, nil
+	}
+	if len(certFlag.DefValue) == 0 {
+		return "", "", fmt.Errorf("Missing --etcd-client-cert and --etcd-client-key for %s", clientName)
+	}
+	dir := filepath.Dir(certFlag.DefValue)
+	return filepath.Join(dir, clientName+"-client.crt"), filepath.Join(dir, clientName+"-client.key"), nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// newClientCertCmd returns a command with the etcd client cert flags defaulting to the kmm client cert
+func newClientCertCmd(args ...string) (*cobra.Command, error) {
+	c := &cobra.Command{Use: EtcdCertsCmdName}
+	c.Flags().String("etcd-client-cert", "/srv/etcd/client.crt", "")
+	c.Flags().String("etcd-client-key", "/srv/etcd/client.key", "")
+	return c, c.ParseFlags(args)
+}
+
+func TestConsumerClientCertFiles(t *testing.T) {
+	tests := []struct {
+		args []string
+		cert string
+		key  string
+	}{
+		{
+			cert: "/srv/etcd/calico-client.crt",
+			key:  "/srv/etcd/calico-client.key",
+		},
+		{
+			args: []string{"--etcd-client-cert=/srv/calico/etcd.crt", "--etcd-client-key=/srv/calico/etcd.key"},
+			cert: "/srv/calico/etcd.crt",
+			key:  "/srv/calico/etcd.key",
+		},
+	}
+	for _, test := range tests {
+		c, err := newClientCertCmd(test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, key, err := consumerClientCertFiles(c, "calico")
+		if err != nil {
+			t.Errorf("unexpected error for %v [%v]", test.args, err)
+			continue
+		}
+		if cert != test.cert || key != test.key {
+			t.Errorf("expected %s and %s for %v but got %s and %s", test.cert, test.key, test.args, cert, key)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--etcd-client-cert=/srv/calico/etcd.crt"},
+		{"--etcd-client-cert=/srv/etcd/client.crt", "--etcd-client-key=/srv/calico/etcd.key"},
+	} {
+		c, err := newClientCertCmd(args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := consumerClientCertFiles(c, "calico"); err == nil {
+			t.Errorf("expected an error overwriting the kmm client cert with %v", args)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/envelope"
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
 	"github.com/UKHomeOffice/keto-k8/pkg/kmm"
 	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm"
 	"github.com/UKHomeOffice/keto-k8/pkg/network"
@@ -15,6 +17,25 @@ import (
 // ExitOnCompletionFlagName is the syntax for the flag
 const ExitOnCompletionFlagName string = "exit-on-completion"
 
+const etcdDialTimeoutFlagName string = "etcd-dial-timeout"
+const etcdRequestTimeoutFlagName string = "etcd-request-timeout"
+const masterWaitTimeoutFlagName string = "master-wait-timeout"
+const reconcilePeriodFlagName string = "reconcile-period"
+const readyTimeoutFlagName string = "ready-timeout"
+const podNetworkCidrFlagName string = "pod-network-cidr"
+const networkMTUFlagName string = "network-mtu"
+const networkBackendFlagName string = "network-backend"
+const networkInterfaceFlagName string = "network-interface"
+const networkImageFlagName string = "network-image"
+const networkIPPoolsFlagName string = "network-ip-pools"
+const networkIPIPModeFlagName string = "network-ipip-mode"
+const networkDatastoreFlagName string = "network-datastore"
+const externalNetworkProvidersFlagName string = "external-network-providers"
+const calicoEtcdClientCertFlagName string = "calico-etcd-client-cert"
+const calicoEtcdClientKeyFlagName string = "calico-etcd-client-key"
+const phasesFlagName string = "phases"
+const skipPhasesFlagName string = "skip-phases"
+
 var (
 	// RootCmd represents the base command when called without any subcommands
 	RootCmd = &cobra.Command{
@@ -70,6 +91,16 @@ func init() {
 		os.Getenv("KMM_ETCD_CLIENT_KEY"),
 		"ETCD client key file (defaults: KMM_ETCD_CLIENT_KEY)")
 
+	RootCmd.PersistentFlags().Duration(
+		etcdDialTimeoutFlagName,
+		etcd.DefaultDialTimeout,
+		"ETCD time allowed to connect")
+
+	RootCmd.PersistentFlags().Duration(
+		etcdRequestTimeoutFlagName,
+		etcd.DefaultRequestTimeout,
+		"ETCD time allowed for each request")
+
 	// kubeadm flags
 	RootCmd.PersistentFlags().String("kube-server", os.Getenv("KMM_KUBE_SERVER"), "Kubernetes API Server")
 
@@ -86,15 +117,152 @@ func init() {
 	RootCmd.PersistentFlags().String(
 		"etcd-cluster-hostnames",
 		getDefaultFromEnvs([]string{"KMM_ETCD_CLUSTER_HOSTNAMES"}, ""),
-		"ETCD hostnames (defaults: KMM_ETCD_CLUSTER_HOSTNAMES or parsed from ETCD_INITIAL_CLUSTER)")
-	RootCmd.PersistentFlags().String("network-provider", "flannel", "Network Provider (flannel / weave / canal)")
+		"ETCD cluster hostnames, used to count the masters (not for etcd certs, see --etcd-local-hostnames) (defaults: KMM_ETCD_CLUSTER_HOSTNAMES or parsed from ETCD_INITIAL_CLUSTER)")
+	RootCmd.PersistentFlags().String(
+		"asset-key-provider",
+		getDefaultFromEnvs([]string{"KMM_ASSET_KEY_PROVIDER"}, envelope.KubeCaKeyProviderName),
+		"Key provider to encrypt shared assets in etcd (kube-ca / file) (defaults: KMM_ASSET_KEY_PROVIDER, kube-ca)")
+	RootCmd.PersistentFlags().String(
+		"asset-key-file",
+		os.Getenv("KMM_ASSET_KEY_FILE"),
+		"Base64 encoded 32 byte key file for the file asset key provider (defaults: KMM_ASSET_KEY_FILE)")
+	RootCmd.PersistentFlags().String(
+		"shared-assets",
+		os.Getenv("KMM_SHARED_ASSETS"),
+		"Extra files to share between masters as a comma separated list of path[:mode[:required|optional[:validator]]] (defaults: KMM_SHARED_ASSETS)")
+	RootCmd.PersistentFlags().String("network-provider", "flannel", "Network Provider (flannel / weave / canal / calico or an external provider)")
+	RootCmd.PersistentFlags().StringSlice(
+		externalNetworkProvidersFlagName,
+		[]string{},
+		"Comma separated network provider metadata files (or directories containing "+network.MetadataFileName+") to load")
+	RootCmd.PersistentFlags().String(
+		podNetworkCidrFlagName,
+		os.Getenv("KMM_POD_NETWORK_CIDR"),
+		"Pod network CIDR (defaults: KMM_POD_NETWORK_CIDR, the controller manager cluster-cidr from the cloud provider or the network providers default)")
+	RootCmd.PersistentFlags().Int(
+		networkMTUFlagName,
+		0,
+		"MTU of the pod network (canal / weave / calico, default is set by the network provider)")
+	RootCmd.PersistentFlags().String(
+		networkBackendFlagName,
+		"",
+		"Network backend between nodes (flannel / canal: vxlan or host-gw, default vxlan, calico: ipip or bgp, default ipip)")
+	RootCmd.PersistentFlags().String(
+		networkInterfaceFlagName,
+		"",
+		"Host interface used for the pod network between nodes (flannel / canal / calico, default is the interface of the default route)")
+	RootCmd.PersistentFlags().StringSlice(
+		networkImageFlagName,
+		[]string{},
+		"Comma separated network provider image overrides as name=image (e.g. flannel=my.registry/flannel:v0.7.1)")
+	RootCmd.PersistentFlags().StringSlice(
+		networkIPPoolsFlagName,
+		[]string{},
+		"Comma separated CIDRs of extra IP pools pod IPs can be allocated from (calico)")
+	RootCmd.PersistentFlags().String(
+		networkIPIPModeFlagName,
+		"",
+		"When traffic is encapsulated by the ipip backend (calico: always or cross-subnet, default always)")
+	RootCmd.PersistentFlags().String(
+		networkDatastoreFlagName,
+		"",
+		"Where the network state is kept (calico: kubernetes or etcd, default kubernetes). The etcd datastore uses the etcd endpoints and CA with the calico etcd client cert")
+	RootCmd.PersistentFlags().String(
+		calicoEtcdClientCertFlagName,
+		os.Getenv("KMM_CALICO_ETCD_CLIENT_CERT"),
+		"ETCD client certificate file issued to "+etcd.ClientNameCalico+" for the calico etcd datastore, the etcd client cert if not set (defaults: KMM_CALICO_ETCD_CLIENT_CERT)")
+	RootCmd.PersistentFlags().String(
+		calicoEtcdClientKeyFlagName,
+		os.Getenv("KMM_CALICO_ETCD_CLIENT_KEY"),
+		"ETCD client key file issued to "+etcd.ClientNameCalico+" for the calico etcd datastore, the etcd client key if not set (defaults: KMM_CALICO_ETCD_CLIENT_KEY)")
+	RootCmd.PersistentFlags().Duration(
+		masterWaitTimeoutFlagName,
+		kmm.DefaultMasterWaitTimeout,
+		"Time allowed for the primary master to share assets before giving up")
+	RootCmd.PersistentFlags().Duration(
+		readyTimeoutFlagName,
+		kmm.DefaultReadyTimeout,
+		"Time allowed for the network, DNS and keto-tokens workloads to become ready before the primary master shares assets")
 	RootCmd.PersistentFlags().Bool(
 		ExitOnCompletionFlagName,
 		false,
 		"Will exit after initializing master / compute (default is false - to remain loaded as service)")
+	RootCmd.PersistentFlags().Duration(
+		reconcilePeriodFlagName,
+		kmm.DefaultReconcilePeriod,
+		"How often to check the kubelet and manifests when remaining loaded as a service")
+	RootCmd.PersistentFlags().StringSlice(
+		phasesFlagName,
+		[]string{},
+		"Comma separated bootstrap phases to run (default is all phases, see master --dry-run)")
+	RootCmd.PersistentFlags().StringSlice(
+		skipPhasesFlagName,
+		[]string{},
+		"Comma separated bootstrap phases to skip")
 
 }
 
+// getNetworkConfig returns the network provider configuration from the flags
+func getNetworkConfig(cmd *cobra.Command) (cfg network.Config, err error) {
+	cfg.PodNetworkCidr = cmd.Flag(podNetworkCidrFlagName).Value.String()
+	cfg.MTU, _ = cmd.Flags().GetInt(networkMTUFlagName)
+	cfg.Backend = cmd.Flag(networkBackendFlagName).Value.String()
+	cfg.Interface = cmd.Flag(networkInterfaceFlagName).Value.String()
+	cfg.IPPools, _ = cmd.Flags().GetStringSlice(networkIPPoolsFlagName)
+	cfg.IPIPMode = cmd.Flag(networkIPIPModeFlagName).Value.String()
+	cfg.Datastore = cmd.Flag(networkDatastoreFlagName).Value.String()
+	images, _ := cmd.Flags().GetStringSlice(networkImageFlagName)
+	if cfg.Images, err = network.ParseImages(images); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// getNetworkProvider loads any external network providers and returns the --network-provider
+// configured from the flags (every command applying the network gets its provider from here)
+func getNetworkProvider(cmd *cobra.Command, etcdConfig etcd.Client) (np network.Provider, cfg network.Config, err error) {
+	externalProviders, _ := cmd.Flags().GetStringSlice(externalNetworkProvidersFlagName)
+	for _, path := range externalProviders {
+		if _, err = network.LoadExternalProvider(path); err != nil {
+			return nil, cfg, err
+		}
+	}
+	if cfg, err = getNetworkConfig(cmd); err != nil {
+		return nil, cfg, err
+	}
+	if cfg.Etcd, err = getNetworkEtcdConfig(cmd, etcdConfig); err != nil {
+		return nil, cfg, err
+	}
+	if np, err = network.CreateProvider(cmd.Flag("network-provider").Value.String(), cfg); err != nil {
+		return nil, cfg, err
+	}
+	return np, cfg, nil
+}
+
+// getNetworkEtcdConfig returns the etcd cluster used by the network datastore
+// The kmm etcd client cert is used unless one issued to etcd.ClientNameCalico is set.
+func getNetworkEtcdConfig(cmd *cobra.Command, etcdConfig etcd.Client) (cfg etcd.Client, err error) {
+	cfg = etcd.Client{
+		Endpoints:          etcdConfig.Endpoints,
+		CaFileName:         etcdConfig.CaFileName,
+		ClientCertFileName: cmd.Flag(calicoEtcdClientCertFlagName).Value.String(),
+		ClientKeyFileName:  cmd.Flag(calicoEtcdClientKeyFlagName).Value.String(),
+	}
+	if len(cfg.ClientCertFileName) == 0 && len(cfg.ClientKeyFileName) == 0 {
+		// Share the kmm etcd client cert unless calico has its own
+		cfg.ClientCertFileName = etcdConfig.ClientCertFileName
+		cfg.ClientKeyFileName = etcdConfig.ClientKeyFileName
+		return cfg, nil
+	}
+	if len(cfg.ClientCertFileName) == 0 || len(cfg.ClientKeyFileName) == 0 {
+		return cfg, fmt.Errorf("Both --%s and --%s are required for a calico etcd client cert", calicoEtcdClientCertFlagName, calicoEtcdClientKeyFlagName)
+	}
+	if cfg.ClientCertFileName == etcdConfig.ClientCertFileName {
+		log.Warnf("The calico etcd client cert is the kmm etcd client cert, issue one to %s to give calico its own identity", etcd.ClientNameCalico)
+	}
+	return cfg, nil
+}
+
 // Will return a valid Kmm.Config object for the relevant flags...
 func getKmmConfig(cmd *cobra.Command) (cfg kmm.Config, err error) {
 
@@ -114,16 +282,26 @@ func getKmmConfig(cmd *cobra.Command) (cfg kmm.Config, err error) {
 	if masterHosts, err = GetEtcdHostNames(cmd, []string{}); err != nil {
 		return cfg, err
 	}
+	var extraSharedAssets []kubeadm.AssetDescriptor
+	if extraSharedAssets, err = kubeadm.ParseAssetDescriptors(cmd.Flag("shared-assets").Value.String()); err != nil {
+		return cfg, err
+	}
 	kubeadmConfig := kubeadm.Config{
-		APIServer:        url,
-		KubeVersion:      cmd.Flag("kube-version").Value.String(),
-		KubeletID:        cmd.Flag("kube-kubeletid").Value.String(),
-		CloudProvider:    cmd.Flag("cloud-provider").Value.String(),
-		EtcdClientConfig: etcdConfig,
-		MasterCount:      uint(len(masterHosts)),
+		APIServer:         url,
+		KubeVersion:       cmd.Flag("kube-version").Value.String(),
+		KubeletID:         cmd.Flag("kube-kubeletid").Value.String(),
+		CloudProvider:     cmd.Flag("cloud-provider").Value.String(),
+		EtcdClientConfig:  etcdConfig,
+		MasterCount:       uint(len(masterHosts)),
+		ExtraSharedAssets: extraSharedAssets,
 	}
 	// False is default if not parsed
 	exitOnCompletion, _ := cmd.Flags().GetBool(ExitOnCompletionFlagName)
+	masterWaitTimeout, _ := cmd.Flags().GetDuration(masterWaitTimeoutFlagName)
+	reconcilePeriod, _ := cmd.Flags().GetDuration(reconcilePeriodFlagName)
+	readyTimeout, _ := cmd.Flags().GetDuration(readyTimeoutFlagName)
+	phases, _ := cmd.Flags().GetStringSlice(phasesFlagName)
+	skipPhases, _ := cmd.Flags().GetStringSlice(skipPhasesFlagName)
 	cfg = kmm.Config{
 		ConfigType: kmm.ConfigType{
 			KubeadmCfg:           &kubeadmConfig,
@@ -131,10 +309,15 @@ func getKmmConfig(cmd *cobra.Command) (cfg kmm.Config, err error) {
 			KubePersistentCaKey:  cmd.Flag("kube-ca-key").Value.String(),
 			NetworkProvider:      cmd.Flag("network-provider").Value.String(),
 			ExitOnCompletion:     exitOnCompletion,
+			MasterWaitTimeout:    masterWaitTimeout,
+			ReadyTimeout:         readyTimeout,
+			ReconcilePeriod:      reconcilePeriod,
+			Phases:               phases,
+			SkipPhases:           skipPhases,
 		},
 	}
 	var np network.Provider
-	if np, err = network.CreateProvider(cfg.NetworkProvider); err != nil {
+	if np, cfg.NetworkCfg, err = getNetworkProvider(cmd, etcdConfig); err != nil {
 		return cfg, err
 	}
 	cfg.KubeadmCfg.PodNetworkCidr = np.PodNetworkCidr()
@@ -145,5 +328,12 @@ func getKmmConfig(cmd *cobra.Command) (cfg kmm.Config, err error) {
 	if len(cfg.KubePersistentCaKey) < 1 {
 		return cfg, fmt.Errorf("A Kube CA key file must be specified")
 	}
+	if cfg.AssetKeyProvider, err = envelope.NewKeyProvider(
+		cmd.Flag("asset-key-provider").Value.String(),
+		cfg.KubePersistentCaKey,
+		cmd.Flag("asset-key-file").Value.String()); err != nil {
+
+		return cfg, err
+	}
 	return cfg, nil
 }
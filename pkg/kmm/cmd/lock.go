@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+	"github.com/UKHomeOffice/keto-k8/pkg/kmm"
+	"github.com/spf13/cobra"
+)
+
+// lockForceFlagName is the flag required to break a lock
+const lockForceFlagName string = "force"
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Inspect or break the shared asset lock",
+	Long:  "Inspect or break the lock held by the master creating the cluster wide shared assets",
+}
+
+// lockStatusCmd represents the lock status command
+var lockStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print the shared asset lock holder",
+	Long:  "Print the host, pid, kmm version and start time of the master holding the shared asset lock",
+	Run: func(c *cobra.Command, args []string) {
+		lockStatus(c)
+	},
+}
+
+// lockBreakCmd represents the lock break command
+var lockBreakCmd = &cobra.Command{
+	Use:   "break",
+	Short: "Clear a stale shared asset lock",
+	Long:  "Clear a stale shared asset lock (leaving any shared assets in place). Requires --force",
+	Run: func(c *cobra.Command, args []string) {
+		lockBreak(c)
+	},
+}
+
+func lockStatus(c *cobra.Command) {
+	etcdConfig, err := getEtcdClientConfig(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	e := etcd.New(etcdConfig)
+	defer e.Close()
+	holder, err := kmm.AssetLockHolder(e)
+	if err == etcd.ErrKeyMissing {
+		fmt.Println("Lock not held")
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Lock held by %s\n", holder)
+}
+
+func lockBreak(c *cobra.Command) {
+	if force, _ := c.Flags().GetBool(lockForceFlagName); !force {
+		log.Fatalf("Refusing to break lock without --%s, check 'kmm lock status' first", lockForceFlagName)
+	}
+	etcdConfig, err := getEtcdClientConfig(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	e := etcd.New(etcdConfig)
+	defer e.Close()
+	if err = kmm.BreakAssetLock(e); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func init() {
+	lockBreakCmd.Flags().Bool(lockForceFlagName, false, "Confirm the lock should be broken")
+	lockCmd.AddCommand(lockStatusCmd)
+	lockCmd.AddCommand(lockBreakCmd)
+	RootCmd.AddCommand(lockCmd)
+}
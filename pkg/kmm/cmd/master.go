@@ -26,13 +26,19 @@ func runKmm(c *cobra.Command) {
 	if cfg, err = getKmmConfig(c); err != nil {
 		log.Fatal(err)
 	}
+	cfg.DryRun = getDryRun(c)
 	k := kmm.New(cfg)
 	if err = k.CreateOrGetSharedAssets(); err != nil {
 		log.Fatal(err)
 	}
-	return
+	// Release anything held in etcd when exiting
+	k.Etcd.Close()
+	if err = writeDryRun(c, cfg.DryRun); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func init() {
+	addDryRunFlags(masterCmd)
 	RootCmd.AddCommand(masterCmd)
 }
@@ -1,31 +1,116 @@
 package cmd
 
 import (
+	"fmt"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/UKHomeOffice/keto-k8/pkg/kmm"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm"
+	"github.com/UKHomeOffice/keto-k8/pkg/network"
 	"github.com/spf13/cobra"
 )
 
+const migrateFromFlagName string = "from"
+const migrateToFlagName string = "to"
+const nodeTimeoutFlagName string = "node-timeout"
+
 // networkCmd represents the networkCmd command
 var networkCmd = &cobra.Command{
 	Use:   "install-network",
 	Short: "install-network",
 	Long:  "install-network",
 	Run: func(c *cobra.Command, args []string) {
-		installNetwork(c)
+		if err := installNetwork(c); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// networkGroupCmd groups the commands managing the pod network
+var networkGroupCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Manage the pod network",
+}
+
+// networkMigrateCmd represents the network migrate command
+var networkMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the cluster to another network provider",
+	Long: "Apply the --" + migrateToFlagName + " network provider (configured with the network flags), then drain " +
+		"and restart each node in turn (compute nodes first) before removing the objects of the --" +
+		migrateFromFlagName + " network provider and its CNI configs from every node. Nodes are restarted by " +
+		"the kmm supervising them so every node must remain loaded as a service",
+	Run: func(c *cobra.Command, args []string) {
+		if err := migrateNetwork(c); err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
-func installNetwork(c *cobra.Command) {
-	kmmCfg := kmm.Config{}
-	kmmCfg.NetworkProvider = c.Flag("network-provider").Value.String()
-	k := kmm.New(kmmCfg)
-	err := k.Kmm.InstallNetwork()
+// installNetwork returns any error only once etcd is closed (log.Fatal skips deferred calls)
+func installNetwork(c *cobra.Command) (err error) {
+	cfg, err := getNetworkCmdConfig(c)
+	if err != nil {
+		return err
+	}
+	k := kmm.New(cfg)
+	// Release anything held in etcd when exiting
+	defer k.Etcd.Close()
+	return k.Kmm.InstallNetwork()
+}
+
+// migrateNetwork returns any error only once etcd is closed (see installNetwork)
+func migrateNetwork(c *cobra.Command) (err error) {
+	from := c.Flag(migrateFromFlagName).Value.String()
+	to := c.Flag(migrateToFlagName).Value.String()
+	if len(from) == 0 || len(to) == 0 {
+		return fmt.Errorf("The --%s and --%s network providers must be specified", migrateFromFlagName, migrateToFlagName)
+	}
+	// The network flags configure the new provider
+	if err = c.Flags().Set("network-provider", to); err != nil {
+		return err
+	}
+	cfg, err := getNetworkCmdConfig(c)
+	if err != nil {
+		return err
+	}
+	nodeTimeout, _ := c.Flags().GetDuration(nodeTimeoutFlagName)
+	k := kmm.New(cfg)
+	defer k.Etcd.Close()
+	return k.MigrateNetwork(from, nodeTimeout)
+}
+
+// getNetworkCmdConfig returns only the config required to manage the pod network
+// (not the kube CA or shared asset key required to bootstrap a master)
+func getNetworkCmdConfig(c *cobra.Command) (cfg kmm.Config, err error) {
+	etcdConfig, err := getEtcdClientConfig(c)
 	if err != nil {
-		log.Fatal(err)
+		return cfg, err
+	}
+	readyTimeout, _ := c.Flags().GetDuration(readyTimeoutFlagName)
+	cfg = kmm.Config{
+		ConfigType: kmm.ConfigType{
+			KubeadmCfg:      &kubeadm.Config{EtcdClientConfig: etcdConfig},
+			NetworkProvider: c.Flag("network-provider").Value.String(),
+			ReadyTimeout:    readyTimeout,
+		},
+	}
+	var np network.Provider
+	if np, cfg.NetworkCfg, err = getNetworkProvider(c, etcdConfig); err != nil {
+		return cfg, err
 	}
+	cfg.KubeadmCfg.PodNetworkCidr = np.PodNetworkCidr()
+	return cfg, nil
 }
 
 func init() {
+	networkMigrateCmd.Flags().String(migrateFromFlagName, "", "Network provider the cluster is using")
+	networkMigrateCmd.Flags().String(migrateToFlagName, "", "Network provider to migrate the cluster to")
+	networkMigrateCmd.Flags().Duration(
+		nodeTimeoutFlagName,
+		kmm.DefaultNodeTimeout,
+		"Time allowed to drain and restart each node (must be longer than the --"+reconcilePeriodFlagName+" of the nodes)")
+	networkGroupCmd.AddCommand(networkMigrateCmd)
 	RootCmd.AddCommand(networkCmd)
+	RootCmd.AddCommand(networkGroupCmd)
 }
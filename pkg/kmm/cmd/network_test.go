@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+	"github.com/UKHomeOffice/keto-k8/pkg/network"
+)
+
+const testExternalMetadata = `
+name: test-cmd-cni
+podNetworkCidr: 172.20.0.0/16
+`
+
+const testExternalManifest = `
+apiVersion: extensions/v1beta1
+kind: DaemonSet
+metadata:
+  name: test-cmd-cni
+  namespace: kube-system
+`
+
+func TestGetNetworkProviderExternal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kmm-network-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		network.MetadataFileName: testExternalMetadata,
+		"daemonset.yaml":         testExternalManifest,
+	} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer delete(network.Factories, "test-cmd-cni")
+	if err = networkCmd.ParseFlags([]string{
+		"--network-provider=test-cmd-cni",
+		"--" + externalNetworkProvidersFlagName + "=" + dir,
+		"--kube-ca-cert=",
+		"--kube-ca-key=",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	np, _, err := getNetworkProvider(networkCmd, etcd.Client{})
+	if err != nil {
+		t.Fatalf("expected the external provider to resolve for install-network but got %v", err)
+	}
+	if np.PodNetworkCidr() != "172.20.0.0/16" {
+		t.Errorf("expected the external provider pod network CIDR but got %q", np.PodNetworkCidr())
+	}
+
+	// The network is managed without the kube CA (or shared asset key) present
+	delete(network.Factories, "test-cmd-cni")
+	cfg, err := getNetworkCmdConfig(networkCmd)
+	if err != nil {
+		t.Fatalf("expected the network config without a kube CA but got %v", err)
+	}
+	if cfg.NetworkProvider != "test-cmd-cni" || cfg.KubeadmCfg.PodNetworkCidr != "172.20.0.0/16" {
+		t.Errorf("expected the external provider network config but got %q (%q)", cfg.NetworkProvider, cfg.KubeadmCfg.PodNetworkCidr)
+	}
+}
+
+func TestGetNetworkEtcdConfig(t *testing.T) {
+	kmmEtcd := etcd.Client{
+		Endpoints:          "https://10.0.0.1:2379",
+		CaFileName:         "/srv/etcd/ca.crt",
+		ClientCertFileName: "/srv/etcd/kmm-client.crt",
+		ClientKeyFileName:  "/srv/etcd/kmm-client.key",
+	}
+	if err := networkCmd.ParseFlags([]string{
+		"--" + calicoEtcdClientCertFlagName + "=/srv/etcd/calico-client.crt",
+		"--" + calicoEtcdClientKeyFlagName + "=/srv/etcd/calico-client.key",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := getNetworkEtcdConfig(networkCmd, kmmEtcd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := etcd.Client{
+		Endpoints:          kmmEtcd.Endpoints,
+		CaFileName:         kmmEtcd.CaFileName,
+		ClientCertFileName: "/srv/etcd/calico-client.crt",
+		ClientKeyFileName:  "/srv/etcd/calico-client.key",
+	}
+	if cfg != expected {
+		t.Errorf("expected the calico client cert %+v but got %+v", expected, cfg)
+	}
+
+	// Calico can share the kmm etcd client cert
+	if err := networkCmd.ParseFlags([]string{
+		"--" + calicoEtcdClientCertFlagName + "=",
+		"--" + calicoEtcdClientKeyFlagName + "=",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = getNetworkEtcdConfig(networkCmd, kmmEtcd); err != nil {
+		t.Fatal(err)
+	}
+	if cfg != kmmEtcd {
+		t.Errorf("expected the kmm etcd client cert %+v but got %+v", kmmEtcd, cfg)
+	}
+
+	if err := networkCmd.ParseFlags([]string{"--" + calicoEtcdClientCertFlagName + "=/srv/etcd/calico-client.crt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getNetworkEtcdConfig(networkCmd, kmmEtcd); err == nil {
+		t.Error("expected an error with a calico etcd client cert but no key")
+	}
+}
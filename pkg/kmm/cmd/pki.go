@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm"
+	"github.com/spf13/cobra"
+)
+
+// pkiCmd represents the pki command
+var pkiCmd = &cobra.Command{
+	Use:   "pki",
+	Short: "Manage the local shared asset files",
+	Long:  "Manage the shared asset files (e.g. service account keys) saved on this master",
+}
+
+// pkiRollbackCmd represents the pki rollback command
+var pkiRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore the shared asset files replaced by the last save",
+	Long:  "Restore the last good set of shared asset files from " + kubeadm.PkiBackupDir,
+	Run: func(c *cobra.Command, args []string) {
+		pkiRollback()
+	},
+}
+
+func pkiRollback() {
+	backup, err := kubeadm.RollbackAssets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Restored shared assets from backup %q", backup)
+}
+
+func init() {
+	pkiCmd.AddCommand(pkiRollbackCmd)
+	RootCmd.AddCommand(pkiCmd)
+}
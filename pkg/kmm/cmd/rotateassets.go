@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/kmm"
+	"github.com/spf13/cobra"
+)
+
+// rotateRetireFlagName is the flag to complete a rotation
+const rotateRetireFlagName string = "retire"
+
+// rotateAssetsCmd represents the rotate-assets command
+var rotateAssetsCmd = &cobra.Command{
+	Use:   "rotate-assets",
+	Short: "Rotate the shared service account and front proxy keys",
+	Long: "Publish new service account and front proxy keys for all masters to pick up. " +
+		"The previous keys are still trusted until run again with --" + rotateRetireFlagName +
+		" (once every master has acknowledged the new keys)",
+	Run: func(c *cobra.Command, args []string) {
+		if err := runRotateAssets(c); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// runRotateAssets returns any error only once etcd is closed (log.Fatal skips deferred calls)
+func runRotateAssets(c *cobra.Command) (err error) {
+	cfg, err := getKmmConfig(c)
+	if err != nil {
+		return err
+	}
+	k := kmm.New(cfg)
+	// Release anything held in etcd when exiting
+	defer k.Etcd.Close()
+	if retire, _ := c.Flags().GetBool(rotateRetireFlagName); retire {
+		return k.RetireSharedAssets()
+	}
+	return k.RotateSharedAssets()
+}
+
+func init() {
+	rotateAssetsCmd.Flags().Bool(rotateRetireFlagName, false, "Stop trusting the keys replaced by the last rotation")
+	RootCmd.AddCommand(rotateAssetsCmd)
+}
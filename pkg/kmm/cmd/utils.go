@@ -23,6 +23,12 @@ func getEtcdClientConfig(cmd *cobra.Command) (cfg etcd.Client, err error) {
 		ClientCertFileName:	cmd.Flag("etcd-client-cert").Value.String(),
 		ClientKeyFileName:	cmd.Flag("etcd-client-key").Value.String(),
 	}
+	if etcdConfig.DialTimeout, err = cmd.Flags().GetDuration(etcdDialTimeoutFlagName); err != nil {
+		return cfg, err
+	}
+	if etcdConfig.RequestTimeout, err = cmd.Flags().GetDuration(etcdRequestTimeoutFlagName); err != nil {
+		return cfg, err
+	}
 
 	if len(etcdConfig.CaFileName) > 0 {
 		if cmd.Use != EtcdCertsCmdName {
@@ -63,6 +69,27 @@ func GetUrlsFromInitialClusterString(initialCluster string) (string, error) {
 	return strings.Join(urls[:],","), nil
 }
 
+// GetMemberNameFromInitialClusterString - Will return the name of the member with a url on one of the local host names
+// (blank when none match)
+func GetMemberNameFromInitialClusterString(initialCluster string, localHostNames []string) (string, error) {
+	for _, s := range deleteEmpty(strings.Split(initialCluster, ",")) {
+		ary := strings.Split(s, "=")
+		if len(ary) != 2 {
+			return "", fmt.Errorf("Error parsing %q, expecting name=url format in string %q",s,initialCluster)
+		}
+		hosts, err := GetHostNamesFromUrls(ary[1], []string{})
+		if err != nil {
+			return "", err
+		}
+		for _, localHostName := range localHostNames {
+			if hosts[0] == localHostName {
+				return ary[0], nil
+			}
+		}
+	}
+	return "", nil
+}
+
 // GetHostNamesFromEnvUrls - Will get host names from an environment variable and some defaults
 func GetHostNamesFromEnvUrls(envName string, minimalDefault []string) ([]string, error) {
 	urls := os.Getenv(envName)
@@ -61,6 +61,29 @@ func TestGetUrlsFromInitialClusterString(t *testing.T) {
 
 }
 
+func TestGetMemberNameFromInitialClusterString(t *testing.T) {
+	initialCluster := "etcd0=https://10.111.2.117:2380,etcd1=https://10.111.2.149:2380"
+	var tests = []struct {
+		localHostNames	[]string
+		expected	string
+	}{
+		{[]string{"10.111.2.149", "localhost", "127.0.0.1"}, "etcd1"},
+		{[]string{"10.111.2.188", "localhost", "127.0.0.1"}, ""},
+	}
+	for _, test := range tests {
+		name, err := GetMemberNameFromInitialClusterString(initialCluster, test.localHostNames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != test.expected {
+			t.Errorf("Expected %q for %v but got %q", test.expected, test.localHostNames, name)
+		}
+	}
+	if _, err := GetMemberNameFromInitialClusterString("https://10.111.2.117:2380", []string{}); err == nil {
+		t.Errorf("Expected an error parsing an initial cluster without names")
+	}
+}
+
 func testAString(initialString string, expectedString string, expectdNumber int) (error) {
 	urls, err := GetUrlsFromInitialClusterString(initialString)
 	if err != nil {
@@ -9,12 +9,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/UKHomeOffice/keto-k8/pkg/constants"
+	"github.com/UKHomeOffice/keto-k8/pkg/dryrun"
+	"github.com/UKHomeOffice/keto-k8/pkg/envelope"
 	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
 	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
 	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm"
 	"github.com/UKHomeOffice/keto-k8/pkg/network"
 	"github.com/UKHomeOffice/keto-k8/pkg/tokens"
 	"github.com/UKHomeOffice/keto/pkg/cloudprovider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const assetKey string = "kmm-asset-key"
@@ -22,14 +27,28 @@ const assetLockKey string = "kmm-asset-lock"
 const defaultBackOff time.Duration = 20 * time.Second
 const defaultLockTTL time.Duration = 120 * time.Second
 
+// clusterCidrArg is the controller manager argument for the pod network CIDR
+const clusterCidrArg string = "cluster-cidr"
+
+// kubeDNSDeployment is the DNS addon deployed by kubeadm
+const kubeDNSDeployment string = "kube-dns"
+
+// DefaultReadyTimeout is the time allowed for the cluster workloads to become ready
+const DefaultReadyTimeout time.Duration = 10 * time.Minute
+
+// DefaultMasterWaitTimeout is the time allowed for the primary master to share assets
+const DefaultMasterWaitTimeout time.Duration = 30 * time.Minute
+
 // Interface defined to enable testing of core functions without dependencies
 type Interface interface {
 	CleanUp(releaseLock, deleteAssets bool) (err error)
 	CopyKubeCa() (err error)
 	InstallNetwork() (err error)
 	TokensDeploy() error
+	WaitForReady() error
 	UpdateCloudCfg() (err error)
 	CreateAndStartKubelet(master bool) error
+	ReconcileNetwork() error
 }
 
 // ConfigType is the complete configuration provided for all kmm use
@@ -37,9 +56,14 @@ type ConfigType struct {
 	KubeadmCfg           *kubeadm.Config
 	KubePersistentCaCert string
 	KubePersistentCaKey  string
+	AssetKeyProvider     envelope.KeyProvider
 	ClusterName          string
 	NetworkProvider      string
+	NetworkCfg           network.Config
 	MasterBackOffTime    time.Duration
+	MasterWaitTimeout    time.Duration
+	ReadyTimeout         time.Duration
+	ReconcilePeriod      time.Duration
 	ExitOnCompletion     bool
 	Etcd                 etcd.Clienter
 	Kubeadm              kubeadm.Kubeadmer
@@ -47,6 +71,11 @@ type ConfigType struct {
 	KubeletExtraArgs     string
 	NodeLabels           map[string]string
 	NodeTaints           map[string]string
+	// Phases limits the bootstrap phases run (all when empty)
+	Phases     []string
+	SkipPhases []string
+	// DryRun records all changes instead of making them (when set)
+	DryRun *dryrun.Recorder
 }
 
 // Both structs here use the same config but are bound to different methods...
@@ -61,29 +90,20 @@ type Kmm struct {
 	ConfigType
 }
 
-// SetupCompute will configure a compute node - currently just saves an env file
-func SetupCompute(cloud string, exitOnCompletion bool) (err error) {
-
-	cfg := Config{}
-	cfg.ConfigType.ExitOnCompletion = exitOnCompletion
-	cfg.ConfigType.KubeadmCfg = &kubeadm.Config{
-		CloudProvider:	cloud,
-	}
+// SetupCompute will configure a compute node - saves an env file and starts the kubelet
+func SetupCompute(cfg Config) (err error) {
 	k := New(cfg)
 	// Get data from cloud provider
 	if err = k.Kmm.UpdateCloudCfg(); err != nil {
 		return err
 	}
-	// TODO: make testable interface here too
-	if err = tokens.WriteKetoTokenEnv(cloud, cfg.KubeadmCfg.APIServer.String()); err != nil {
-		return fmt.Errorf("error saving KetoTokenEnv: %q", err)
+	if err = k.runPipeline(RoleCompute, &bootstrapState{}); err != nil {
+		return err
 	}
 
-	k.Kmm.CreateAndStartKubelet(false)
-
 	log.Printf("Compute bootstrapped")
 	if ! k.ExitOnCompletion {
-		for true {}
+		k.superviseCompute(stopOnSignal())
 	}
 	return nil
 }
@@ -91,8 +111,26 @@ func SetupCompute(cloud string, exitOnCompletion bool) (err error) {
 // New creates a new kmm struct with live interface from configuration
 func New(cfg Config) *Config {
 	cfg.MasterBackOffTime = defaultBackOff
+	if cfg.MasterWaitTimeout == 0 {
+		cfg.MasterWaitTimeout = DefaultMasterWaitTimeout
+	}
+	if cfg.ReconcilePeriod == 0 {
+		cfg.ReconcilePeriod = DefaultReconcilePeriod
+	}
+	if cfg.ReadyTimeout == 0 {
+		cfg.ReadyTimeout = DefaultReadyTimeout
+	}
 
 	cfg.Etcd = etcd.New(cfg.KubeadmCfg.EtcdClientConfig)
+	if cfg.DryRun != nil {
+		// Current values are still read from etcd
+		cfg.Etcd = dryrun.NewEtcd(cfg.DryRun, cfg.Etcd)
+		cfg.KubeadmCfg.DryRun = cfg.DryRun
+		cfg.DryRun.Classify(kubeadm.ManifestsDir, dryrun.KindManifest)
+		cfg.DryRun.Classify(constants.KubeletUnitFileName, dryrun.KindUnit)
+		// Never remain running as a service
+		cfg.ExitOnCompletion = true
+	}
 	cfg.Kubeadm = cfg.KubeadmCfg
 
 	// Wire up the concrete implementation with the same data
@@ -113,11 +151,17 @@ func (k *Config) CreateOrGetSharedAssets() (err error) {
 	if err = k.Kmm.CopyKubeCa(); err != nil {
 		return err
 	}
+	// Fail early if we can't encrypt / decrypt the shared assets
+	if err = envelope.Verify(k.AssetKeyProvider); err != nil {
+		return fmt.Errorf("shared assets key provider not usable: %v", err)
+	}
 	if err = k.Kubeadm.WriteManifests(); err != nil {
 		return err
 	}
 
 	// Keep trying to get Assets
+	deadline := time.Now().Add(k.MasterWaitTimeout)
+	watchBackOff := newBackOff(k.MasterBackOffTime)
 	for true {
 		assets, err := k.Etcd.Get(assetKey)
 		if err == etcd.ErrKeyMissing {
@@ -131,96 +175,120 @@ func (k *Config) CreateOrGetSharedAssets() (err error) {
 			}
 			if mylock {
 				log.Printf("Obtained lock, creating assets...")
-				if assets, err = k.BootstrapOnce(); err != nil {
-					k.Kmm.CleanUp(true, false)
+				plainAssets, err := k.BootstrapOnce()
+				if err != nil {
+					k.Etcd.ReleaseLock(assetLockKey)
 					return err
 				}
+				// Never share assets if another master may have taken over...
+				select {
+				case <-k.Etcd.LockLost(assetLockKey):
+					return fmt.Errorf("lost lock %q whilst bootstrapping, not sharing assets", assetLockKey)
+				default:
+				}
 				// Only share assets when all done OK!
+				log.Printf("Encrypting assets with %q key provider...", k.AssetKeyProvider.Name())
+				if assets, err = envelope.Seal(k.AssetKeyProvider, []byte(plainAssets)); err != nil {
+					k.Etcd.ReleaseLock(assetLockKey)
+					return err
+				}
 				log.Printf("Saving assets to etcd...")
-				if err = k.Etcd.PutTx(assetKey, assets); err != nil {
-					k.Kmm.CleanUp(true, false)
+				// The lock is checked again as the assets are put (it may be lost after the check above)
+				if err = k.Etcd.PutTxLocked(assetKey, assets, assetLockKey); err != nil {
+					k.Etcd.ReleaseLock(assetLockKey)
 					return err
 				}
 				log.Printf("Assets shared to etcd")
+				if err = k.Etcd.ReleaseLock(assetLockKey); err != nil {
+					return err
+				}
+				if err = k.acknowledgeAssets(plainAssets); err != nil {
+					return err
+				}
 				break
 			}
-			// We need to try and get the assets again after a back off
-			time.Sleep(k.MasterBackOffTime)
+			if holder, err := k.Etcd.GetLockHolder(assetLockKey); err == nil {
+				log.Printf("Waiting for assets from lock holder %s", holder)
+			}
+			// Wait for the assets and then try and get them again
+			// (or obtain the lock if the lock holder has failed)
+			if err = k.waitForAssets(deadline, watchBackOff); err != nil {
+				return err
+			}
 		} else if err != nil {
 			return err
 		} else {
 			// Assets present in etcd so save assets and boot secondary master...
-			if err = k.BootstrapSecondaryMaster(assets); err != nil {
+			plainAssets, err := k.openStoredAssets(assets)
+			if err != nil {
+				return err
+			}
+			if err = k.BootstrapSecondaryMaster(plainAssets); err != nil {
+				return err
+			}
+			if err = k.acknowledgeAssets(plainAssets); err != nil {
 				return err
 			}
 			break
 		}
 	}
-	// TODO: For now...
-	//       Will make loop optional so we can run as a cli for e2e tests
-	//       Will need a retry loop if we implement run-time keto-k8 upgrades...
 	log.Printf("Master bootstrapped")
 	if ! k.ExitOnCompletion {
-		for true {}
+		k.superviseMaster(stopOnSignal())
 	}
 	return nil
 }
 
-// BootstrapSecondaryMaster will start a secondary master (cluster unique assets not created here)
-func (k *Config) BootstrapSecondaryMaster(assets string) (error) {
-	// We have the shared assets, now re-create anything missing...
-	log.Printf("Not primary master (in this run)...")
-	log.Printf("Saving assets to disk...")
-	if err := k.Kubeadm.SaveAssets(assets); err != nil {
-		return err
-	}
-	if err := k.Kubeadm.CreatePKI(); err != nil {
-		return err
+// waitForAssets will watch for the shared assets to be created (for up to the back off time)
+// Backs off (with jitter) when watching fails and returns an error once the deadline has passed
+func (k *Config) waitForAssets(deadline time.Time, b *backOff) error {
+	wait := k.MasterBackOffTime
+	if remaining := deadline.Sub(time.Now()); remaining < wait {
+		wait = remaining
 	}
-	if err := k.Kubeadm.CreateKubeConfig(); err != nil {
-		return err
+	if wait <= 0 {
+		return fmt.Errorf("timed out after %v waiting for shared assets from the primary master", k.MasterWaitTimeout)
 	}
-	if err := k.Kmm.CreateAndStartKubelet(true); err != nil {
-		return err
-	}
-	if err := k.Kubeadm.UpdateMasterRoleLabelsAndTaints(); err != nil {
-		return err
+	_, err := k.Etcd.WaitForKey(assetKey, wait)
+	switch err {
+	case nil, etcd.ErrWaitTimeout:
+		b.reset()
+	default:
+		delay := b.next()
+		log.Printf("Error watching for assets, retrying in %v: %v", delay, err)
+		time.Sleep(delay)
 	}
 	return nil
 }
 
+// BootstrapSecondaryMaster will start a secondary master (cluster unique assets not created here)
+func (k *Config) BootstrapSecondaryMaster(assets string) (error) {
+	// We have the shared assets, now re-create anything missing...
+	log.Printf("Not primary master (in this run)...")
+	return k.runPipeline(RoleSecondary, &bootstrapState{assets: assets})
+}
+
 // BootstrapOnce will carry out all the actions on a primary master
-// TODO: ensure these are all repeatable - blocked, see issue:
-//       https://github.com/UKHomeOffice/keto-k8/issues/33
+// Each phase is recorded as a checkpoint in etcd so a new lock holder will resume from the
+// first incomplete phase (re-using the shared assets already created)
 func (k *Config) BootstrapOnce() (assets string, err error) {
 	log.Printf("Bootstrapping master...")
 
-	// We can create the master assets here
-	if err = k.Kubeadm.CreatePKI(); err != nil {
-		return "", err
-	}
-	// Load assets off disk and serialise
-	assets, err = k.Kubeadm.LoadAndSerializeAssets()
-
-	// We have the assets but we must NOT proceed until we've finish bootstrapping / sharing...
-	if err = k.Kubeadm.CreateKubeConfig(); err != nil {
-		return "", err
-	}
-	if err = k.Kmm.CreateAndStartKubelet(true); err != nil {
-		return "", err
-	}
-	// Note: Addons will call the same underlying kubeadmapi UpdateMasterRoleLabelsAndTaints
-	if err = k.Kubeadm.Addons(); err != nil {
+	phases, err := k.Plan(RolePrimary)
+	if err != nil {
 		return "", err
 	}
-	if err = k.Kmm.InstallNetwork(); err != nil {
-		return "", err
+	if !contains(phaseNames(phases), pkiPhase) {
+		return "", fmt.Errorf("the %q phase must run on the primary master to create the shared assets", pkiPhase)
 	}
-	if err = k.Kmm.TokensDeploy(); err != nil {
+	// The pki phase creates (or restores) the master assets but we must NOT share them until
+	// we've finished bootstrapping...
+	state := &bootstrapState{}
+	if err = k.runPipeline(RolePrimary, state); err != nil {
 		return "", err
 	}
 	log.Printf("Master bootstrapped!")
-	return assets, nil
+	return state.assets, nil
 }
 
 // CleanUp - will optionally clean all etcd resources
@@ -238,6 +306,26 @@ func (k *Kmm) CleanUp(releaseLock, deleteAssets bool) (err error) {
 		if err = k.Etcd.Delete(assetKey); err != nil {
 			return err
 		}
+		// Without assets, any acknowledgements and bootstrap checkpoints are stale
+		for _, prefix := range []string{assetAckPrefix, checkpointPrefix} {
+			if err = deletePrefix(k.Etcd, prefix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deletePrefix will remove all keys starting with prefix
+func deletePrefix(e etcd.Clienter, prefix string) error {
+	values, err := e.GetPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	for key := range values {
+		if err = e.Delete(key); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -245,30 +333,34 @@ func (k *Kmm) CleanUp(releaseLock, deleteAssets bool) (err error) {
 // InstallNetwork will create the CNI network resources from a named template
 func (k *Kmm) InstallNetwork() (err error) {
 	var np network.Provider
-	if np, err = network.CreateProvider(k.NetworkProvider); err != nil {
+	if np, err = network.CreateProvider(k.NetworkProvider, k.NetworkCfg); err != nil {
 		return err
 	}
-	return np.Create()
+	a, err := k.applier()
+	if err != nil {
+		return err
+	}
+	return np.Create(a)
 }
 
 // CopyKubeCa will copy Kube CA and link CA key to kubeadm expected locations (if not there already)
 func (k *Kmm) CopyKubeCa() (err error) {
 	// First check for CA file...
 	if _, err := os.Stat(k.KubePersistentCaCert); os.IsNotExist(err) {
-		return errors.New("kube CA cert not found at: " + k.KubePersistentCaCert)
+		return errors.New("kube CA cert not found at: " + k.KubePersistentCaCert + " (see kmm ca init)")
 	}
 	if _, err := os.Stat(k.KubePersistentCaKey); os.IsNotExist(err) {
-		return errors.New("kube CA key not found at: " + k.KubePersistentCaKey)
+		return errors.New("kube CA key not found at: " + k.KubePersistentCaKey + " (see kmm ca init)")
 	}
 	if _, err = os.Stat(kubeadm.PkiDir); os.IsNotExist(err) {
-		os.Mkdir(kubeadm.PkiDir, os.ModePerm)
+		k.fs().MkdirAll(kubeadm.PkiDir, os.ModePerm)
 	}
 
-	err = fileutil.CopyFile(k.KubePersistentCaCert, kubeadm.CaCertFile)
+	err = k.fs().CopyFile(k.KubePersistentCaCert, kubeadm.CaCertFile)
 	if err != nil {
 		return err
 	}
-	err = fileutil.SymlinkFile(k.KubePersistentCaKey, kubeadm.CaKeyFile)
+	err = k.fs().SymlinkFile(k.KubePersistentCaKey, kubeadm.CaKeyFile)
 	if err != nil {
 		return err
 	}
@@ -278,7 +370,62 @@ func (k *Kmm) CopyKubeCa() (err error) {
 // TokensDeploy method calls the dependancy with the correct configuration
 // It allows the dependancy to be mocked.
 func (k *Kmm) TokensDeploy() error {
-	return tokens.Deploy(k.ClusterName)
+	a, err := k.applier()
+	if err != nil {
+		return err
+	}
+	return tokens.Deploy(a, k.ClusterName)
+}
+
+// WaitForReady waits until the master nodes, the DNS addon, the network and the keto-tokens workloads are ready
+func (k *Kmm) WaitForReady() error {
+	if k.DryRun != nil {
+		log.Printf("Dry run, not waiting for the cluster to be ready")
+		return nil
+	}
+	c, err := k8client.NewAdmin()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(k.ReadyTimeout)
+	np, err := network.CreateProvider(k.NetworkProvider, k.NetworkCfg)
+	if err != nil {
+		return err
+	}
+	networkManifest, err := np.Manifest()
+	if err != nil {
+		return err
+	}
+	if err = k8client.WaitForResources(c, networkManifest, deadline.Sub(time.Now())); err != nil {
+		return err
+	}
+	if err = c.WaitForNodes(deadline.Sub(time.Now())); err != nil {
+		return err
+	}
+	if err = c.WaitForDeployment(metav1.NamespaceSystem, kubeDNSDeployment, deadline.Sub(time.Now())); err != nil {
+		return err
+	}
+	tokensManifest, err := tokens.Manifest(k.ClusterName)
+	if err != nil {
+		return err
+	}
+	return k8client.WaitForResources(c, tokensManifest, deadline.Sub(time.Now()))
+}
+
+// fs is where files are changed (or recorded on a dry run)
+func (c *ConfigType) fs() fileutil.Filesystem {
+	if c.DryRun != nil {
+		return c.DryRun
+	}
+	return fileutil.OS
+}
+
+// applier creates kubernetes resources as the cluster admin (or records them on a dry run)
+func (c *ConfigType) applier() (k8client.Applier, error) {
+	if c.DryRun != nil {
+		return c.DryRun, nil
+	}
+	return k8client.NewAdmin()
 }
 
 // UpdateCloudCfg config based on cloud provider, if specified
@@ -317,6 +464,29 @@ func (k *Kmm) UpdateCloudCfg() (err error) {
 	} else {
 		log.Printf("No cloud provider specified - not loading...")
 	}
+	return k.updatePodNetworkCidr()
+}
+
+// updatePodNetworkCidr keeps the pod network CIDR consistent with any controller manager cluster CIDR
+// (as set by the cloud provider) and uses it for kubeadm
+func (c *ConfigType) updatePodNetworkCidr() error {
+	if len(c.NetworkProvider) == 0 {
+		// Compute nodes don't deploy the network
+		return nil
+	}
+	if clusterCidr := c.KubeadmCfg.ControllerManagerExtraArgs[clusterCidrArg]; len(clusterCidr) > 0 {
+		if len(c.NetworkCfg.PodNetworkCidr) == 0 {
+			c.NetworkCfg.PodNetworkCidr = clusterCidr
+		} else if c.NetworkCfg.PodNetworkCidr != clusterCidr {
+			return fmt.Errorf("pod network CIDR %q doesn't match the controller manager %s %q",
+				c.NetworkCfg.PodNetworkCidr, clusterCidrArg, clusterCidr)
+		}
+	}
+	np, err := network.CreateProvider(c.NetworkProvider, c.NetworkCfg)
+	if err != nil {
+		return err
+	}
+	c.KubeadmCfg.PodNetworkCidr = np.PodNetworkCidr()
 	return nil
 }
 
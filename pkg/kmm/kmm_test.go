@@ -4,17 +4,35 @@ package kmm
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/UKHomeOffice/keto-k8/pkg/dryrun"
+	"github.com/UKHomeOffice/keto-k8/pkg/envelope"
 	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
 	etcdMocks "github.com/UKHomeOffice/keto-k8/pkg/etcd/mocks"
 	kmmMocks "github.com/UKHomeOffice/keto-k8/pkg/kmm/mocks"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm"
 	kubeadmMocks "github.com/UKHomeOffice/keto-k8/pkg/kubeadm/mocks"
+	"github.com/stretchr/testify/mock"
 )
 
 const testAssets = "{}"
 
+// notLost is a lock lost channel which is never closed
+var notLost <-chan struct{} = make(chan struct{})
+
+// testKeyProvider is a key provider which doesn't protect the data key
+type testKeyProvider struct{}
+
+func (p testKeyProvider) Name() string                             { return "test" }
+func (p testKeyProvider) WrapKey(dataKey []byte) ([]byte, error)   { return dataKey, nil }
+func (p testKeyProvider) UnwrapKey(wrapped []byte) ([]byte, error) { return wrapped, nil }
+
 // testMock used for mockable interface
 type testMock struct {
 	Etcd    *etcdMocks.Clienter
@@ -22,6 +40,15 @@ type testMock struct {
 	Kmm     *kmmMocks.Interface
 }
 
+// testAckKey is the key acknowledging the shared assets revision for this host
+func testAckKey(t *testing.T) string {
+	hostName, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return assetAckPrefix + hostName
+}
+
 func getTestMock() (*testMock, *Config) {
 	m := &testMock{
 		Etcd:    &etcdMocks.Clienter{},
@@ -35,11 +62,36 @@ func getTestMock() (*testMock, *Config) {
 	kmm.Etcd = m.Etcd
 	kmm.Kubeadm = m.Kubeadm
 	kmm.Kmm = m.Kmm
+	kmm.AssetKeyProvider = testKeyProvider{}
 	kmm.MasterBackOffTime = (time.Microsecond * 100)
+	kmm.MasterWaitTimeout = time.Second
 	return m, kmm
 }
 
+// addCheckpointAssertions expects all checkpoints not already completed to be recorded
+func addCheckpointAssertions(m *testMock, completed map[string]string) {
+	k := &Config{}
+	k.Kubeadm = m.Kubeadm
+	k.Kmm = m.Kmm
+	phases, _ := k.Plan(RolePrimary)
+	for _, phase := range phases {
+		if phase.Name != pkiPhase && !phase.Checkpoint {
+			continue
+		}
+		key, _ := checkpointKey(phase)
+		if cp, ok := completed[phase.Name]; ok {
+			m.Etcd.On("Get", key).Return(cp, nil).Once()
+			continue
+		}
+		m.Etcd.On("Get", key).Return("", etcd.ErrKeyMissing).Once()
+		m.Etcd.On("PutLocked", key, mock.AnythingOfType("string"), assetLockKey).Return(nil).Once()
+	}
+}
+
 func AddBootstapOnceAssertions(m *testMock) {
+	// No checkpoints from a previous lock holder
+	addCheckpointAssertions(m, map[string]string{})
+
 	m.Kubeadm.On("CreatePKI").Return(nil).Once()
 	m.Kubeadm.On("LoadAndSerializeAssets").Return(testAssets, nil)
 	m.Kubeadm.On("CreateKubeConfig").Return(nil).Once()
@@ -49,6 +101,7 @@ func AddBootstapOnceAssertions(m *testMock) {
 	m.Kubeadm.On("Addons").Return(nil).Once()
 	m.Kmm.On("InstallNetwork").Return(nil).Once()
 	m.Kmm.On("TokensDeploy").Return(nil).Once()
+	m.Kmm.On("WaitForReady").Return(nil).Once()
 }
 
 func AddMasterAssertions(m *testMock, primary bool) {
@@ -79,10 +132,106 @@ func TestBootStrappedOnce(t *testing.T) {
 			t.Error(fmt.Errorf("Test assets not equal"))
 		}
 	}
+	m.Etcd.AssertNumberOfCalls(t, "PutLocked", 6)
+	m.Etcd.AssertExpectations(t)
+	m.Kmm.AssertExpectations(t)
+	m.Kubeadm.AssertExpectations(t)
+}
+
+func TestBootstrapOnceResume(t *testing.T) {
+	m, k := getTestMock()
+
+	// Resume bootstrap from a lock holder which failed after the addons phase (on the same host name)
+	sealedAssets, err := envelope.Seal(k.AssetKeyProvider, []byte(testAssets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := fmt.Sprintf(`{"completed":"2017-01-01T00:00:00Z","completedBy":"master1","assets":%q}`, sealedAssets)
+	addCheckpointAssertions(m, map[string]string{
+		pkiPhase:     completed,
+		"kubeconfig": completed,
+		"kubelet":    completed,
+		"addons":     completed,
+	})
+
+	m.Kubeadm.On("SaveAssets", testAssets).Return(nil).Once()
+	m.Kubeadm.On("CreatePKI").Return(nil).Once()
+	// Local phases still check the host (which may have been rebuilt)
+	m.Kubeadm.On("CreateKubeConfig").Return(nil).Once()
+	m.Kmm.On("CreateAndStartKubelet", true).Return(nil).Once()
+	m.Kmm.On("InstallNetwork").Return(nil).Once()
+	m.Kmm.On("TokensDeploy").Return(nil).Once()
+	m.Kmm.On("WaitForReady").Return(nil).Once()
+
+	if assets, err := k.BootstrapOnce(); err != nil {
+		t.Error(err)
+	} else if assets != testAssets {
+		t.Error(fmt.Errorf("expected assets from the pki checkpoint but got %q", assets))
+	}
+	m.Kubeadm.AssertNotCalled(t, "LoadAndSerializeAssets")
+	m.Kubeadm.AssertNotCalled(t, "Addons")
+	m.Etcd.AssertNumberOfCalls(t, "PutLocked", 2)
+	m.Etcd.AssertExpectations(t)
 	m.Kmm.AssertExpectations(t)
 	m.Kubeadm.AssertExpectations(t)
 }
 
+func TestBootstrapOnceDryRun(t *testing.T) {
+	m, k := getTestMock()
+	k.DryRun = dryrun.New()
+	k.Etcd = dryrun.NewEtcd(k.DryRun, nil)
+
+	m.Kubeadm.On("CreatePKI").Return(nil).Once()
+	m.Kubeadm.On("LoadAndSerializeAssets").Return(testAssets, nil)
+	m.Kubeadm.On("CreateKubeConfig").Return(nil).Once()
+	m.Kmm.On("CreateAndStartKubelet", true).Return(nil).Once()
+	m.Kubeadm.On("Addons").Return(nil).Once()
+	m.Kmm.On("InstallNetwork").Return(nil).Once()
+	m.Kmm.On("TokensDeploy").Return(nil).Once()
+	m.Kmm.On("WaitForReady").Return(nil).Once()
+
+	if _, err := k.BootstrapOnce(); err != nil {
+		t.Error(err)
+	}
+	report := k.DryRun.Report()
+	if len(report.Plans) != 1 || report.Plans[0].Role != string(RolePrimary) {
+		t.Errorf("expected the primary plan recorded but got %v", report.Plans)
+	}
+	// Every checkpoint is recorded (and not written)
+	if len(report.Changes) != 6 {
+		t.Errorf("expected 6 checkpoints recorded but got %v", report.Changes)
+	}
+	for _, c := range report.Changes {
+		if c.Kind != dryrun.KindEtcd || c.Action != dryrun.ActionCreate || !strings.HasPrefix(c.Target, checkpointPrefix) {
+			t.Errorf("expected a checkpoint created but got %+v", c)
+		}
+	}
+	m.Kmm.AssertExpectations(t)
+	m.Kubeadm.AssertExpectations(t)
+}
+
+func TestBootstrapOnceNotReady(t *testing.T) {
+	m, k := getTestMock()
+
+	addCheckpointAssertions(m, map[string]string{})
+	m.Kubeadm.On("CreatePKI").Return(nil).Once()
+	m.Kubeadm.On("LoadAndSerializeAssets").Return(testAssets, nil)
+	m.Kubeadm.On("CreateKubeConfig").Return(nil).Once()
+	m.Kmm.On("CreateAndStartKubelet", true).Return(nil).Once()
+	m.Kubeadm.On("Addons").Return(nil).Once()
+	m.Kmm.On("InstallNetwork").Return(nil).Once()
+	m.Kmm.On("TokensDeploy").Return(nil).Once()
+	m.Kmm.On("WaitForReady").Return(fmt.Errorf("DaemonSet/kube-system/kube-flannel-ds not ready")).Once()
+
+	// The assets must not be shared until the cluster is healthy
+	if assets, err := k.BootstrapOnce(); err == nil {
+		t.Error(fmt.Errorf("expected error when the cluster isn't ready"))
+	} else if len(assets) > 0 {
+		t.Error(fmt.Errorf("expected no assets to share but got %q", assets))
+	}
+	m.Kmm.AssertExpectations(t)
+}
+
 func TestCreateOrGetSharedAssets(t *testing.T) {
 
 	m, k := getTestMock()
@@ -91,7 +240,10 @@ func TestCreateOrGetSharedAssets(t *testing.T) {
 	// No assets stored, No pre-existing etcd lock, clean run...
 	m.Etcd.On("Get", assetKey).Return("", etcd.ErrKeyMissing).Once()
 	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(true, nil).Once()
-	m.Etcd.On("PutTx", assetKey, testAssets).Return(nil)
+	m.Etcd.On("LockLost", assetLockKey).Return(notLost).Once()
+	m.Etcd.On("PutTxLocked", assetKey, mock.AnythingOfType("string"), assetLockKey).Return(nil)
+	m.Etcd.On("ReleaseLock", assetLockKey).Return(nil).Once()
+	m.Etcd.On("Put", testAckKey(t), "0").Return(nil).Once()
 
 	AddMasterAssertions(m, true)
 
@@ -110,8 +262,13 @@ func TestCreateOrGetSharedAssetsSecondaryMaster(t *testing.T) {
 
 	// Test secondary master:
 	// Assets pre-stored, clean run...
-	m.Etcd.On("Get", assetKey).Return(testAssets, nil).Once()
+	sealedAssets, err := envelope.Seal(k.AssetKeyProvider, []byte(testAssets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Etcd.On("Get", assetKey).Return(sealedAssets, nil).Once()
 	m.Kubeadm.On("SaveAssets", testAssets).Return(nil).Once()
+	m.Etcd.On("Put", testAckKey(t), "0").Return(nil).Once()
 
 	// Assing expected outcomes from the secondary master
 	AddMasterAssertions(m, false)
@@ -124,3 +281,561 @@ func TestCreateOrGetSharedAssetsSecondaryMaster(t *testing.T) {
 	m.Etcd.AssertExpectations(t)
 	m.Kubeadm.AssertExpectations(t)
 }
+
+func TestCreateOrGetSharedAssetsWaitForPrimary(t *testing.T) {
+
+	m, k := getTestMock()
+
+	// Test secondary master waiting for the primary master to share assets:
+	sealedAssets, err := envelope.Seal(k.AssetKeyProvider, []byte(testAssets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Etcd.On("Get", assetKey).Return("", etcd.ErrKeyMissing).Once()
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(false, nil).Once()
+	m.Etcd.On("GetLockHolder", assetLockKey).Return(etcd.LockHolder{HostName: "master1"}, nil).Once()
+	m.Etcd.On("WaitForKey", assetKey, mock.AnythingOfType("time.Duration")).Return("", fmt.Errorf("watch failed")).Once()
+	m.Etcd.On("Get", assetKey).Return("", etcd.ErrKeyMissing).Once()
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(false, nil).Once()
+	m.Etcd.On("GetLockHolder", assetLockKey).Return(etcd.LockHolder{HostName: "master1"}, nil).Once()
+	m.Etcd.On("WaitForKey", assetKey, mock.AnythingOfType("time.Duration")).Return(sealedAssets, nil).Once()
+	m.Etcd.On("Get", assetKey).Return(sealedAssets, nil).Once()
+	m.Kubeadm.On("SaveAssets", testAssets).Return(nil).Once()
+	m.Etcd.On("Put", testAckKey(t), "0").Return(nil).Once()
+
+	AddMasterAssertions(m, false)
+
+	if err := k.CreateOrGetSharedAssets(); err != nil {
+		t.Error(err)
+	}
+
+	m.Kmm.AssertExpectations(t)
+	m.Etcd.AssertExpectations(t)
+	m.Kubeadm.AssertExpectations(t)
+}
+
+func TestCreateOrGetSharedAssetsWaitTimeout(t *testing.T) {
+
+	m, k := getTestMock()
+
+	// Test secondary master giving up when the primary never shares assets:
+	k.MasterWaitTimeout = time.Millisecond
+	m.Etcd.On("Get", assetKey).Return("", etcd.ErrKeyMissing)
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(false, nil)
+	m.Etcd.On("GetLockHolder", assetLockKey).Return(etcd.LockHolder{HostName: "master1"}, nil)
+	m.Etcd.On("WaitForKey", assetKey, mock.AnythingOfType("time.Duration")).Return("", etcd.ErrWaitTimeout)
+	m.Kmm.On("UpdateCloudCfg").Return(nil)
+	m.Kmm.On("CopyKubeCa").Return(nil)
+	m.Kubeadm.On("WriteManifests").Return(nil)
+
+	if err := k.CreateOrGetSharedAssets(); err == nil {
+		t.Error(fmt.Errorf("expected an error when timed out waiting for assets but got none"))
+	}
+	m.Kubeadm.AssertNotCalled(t, "SaveAssets", testAssets)
+}
+
+func TestCreateOrGetSharedAssetsLockLost(t *testing.T) {
+
+	m, k := getTestMock()
+
+	// Test primary master losing the lock whilst bootstrapping:
+	// The first checkpoint is refused by etcd and assets must not be shared
+	pkiKey, _ := checkpointKey(Phase{Name: pkiPhase})
+	m.Etcd.On("Get", assetKey).Return("", etcd.ErrKeyMissing).Once()
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(true, nil).Once()
+	m.Etcd.On("PutLocked", pkiKey, mock.AnythingOfType("string"), assetLockKey).Return(etcd.ErrLockChanged).Once()
+	m.Etcd.On("ReleaseLock", assetLockKey).Return(nil).Once()
+
+	AddMasterAssertions(m, true)
+
+	if err := k.CreateOrGetSharedAssets(); err == nil {
+		t.Error(fmt.Errorf("expected an error when lock lost but got none"))
+	}
+
+	m.Etcd.AssertCalled(t, "GetOrCreateLock", assetLockKey, defaultLockTTL)
+	m.Etcd.AssertNumberOfCalls(t, "PutLocked", 1)
+	m.Kubeadm.AssertNotCalled(t, "CreateKubeConfig")
+	m.Etcd.AssertNotCalled(t, "PutTxLocked", assetKey, mock.AnythingOfType("string"), assetLockKey)
+}
+
+func TestCreateOrGetSharedAssetsLockLostBeforePut(t *testing.T) {
+
+	m, k := getTestMock()
+
+	// Test primary master losing the lock after the last check but before the assets are put:
+	// The put is refused by etcd and the assets are not acknowledged
+	m.Etcd.On("Get", assetKey).Return("", etcd.ErrKeyMissing).Once()
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(true, nil).Once()
+	m.Etcd.On("LockLost", assetLockKey).Return(notLost).Once()
+	m.Etcd.On("PutTxLocked", assetKey, mock.AnythingOfType("string"), assetLockKey).Return(etcd.ErrLockChanged).Once()
+	m.Etcd.On("ReleaseLock", assetLockKey).Return(nil).Once()
+
+	AddMasterAssertions(m, true)
+
+	if err := k.CreateOrGetSharedAssets(); err != etcd.ErrLockChanged {
+		t.Error(fmt.Errorf("expected error %q but got %q", etcd.ErrLockChanged, err))
+	}
+	m.Etcd.AssertExpectations(t)
+	m.Etcd.AssertNotCalled(t, "Put", testAckKey(t), "0")
+}
+
+func TestBreakAssetLock(t *testing.T) {
+	m, _ := getTestMock()
+
+	holder := etcd.LockHolder{HostName: "master1", PID: 1, Revision: 10}
+	m.Etcd.On("GetLockHolder", assetLockKey).Return(holder, nil).Once()
+	m.Etcd.On("BreakLock", assetLockKey, holder).Return(nil).Once()
+	if err := BreakAssetLock(m.Etcd); err != nil {
+		t.Error(err)
+	}
+
+	// Lock changed since inspected
+	m.Etcd.On("GetLockHolder", assetLockKey).Return(holder, nil).Once()
+	m.Etcd.On("BreakLock", assetLockKey, holder).Return(etcd.ErrLockChanged).Once()
+	if err := BreakAssetLock(m.Etcd); err == nil {
+		t.Error(fmt.Errorf("expected an error when lock changed but got none"))
+	}
+
+	// No lock to break
+	m.Etcd.On("GetLockHolder", assetLockKey).Return(etcd.LockHolder{}, etcd.ErrKeyMissing).Once()
+	if err := BreakAssetLock(m.Etcd); err != nil {
+		t.Error(err)
+	}
+	m.Etcd.AssertExpectations(t)
+}
+
+func TestCreateOrGetSharedAssetsNotEncrypted(t *testing.T) {
+
+	m, k := getTestMock()
+
+	// Test secondary master refuses plain text which isn't shared assets
+	m.Etcd.On("Get", assetKey).Return("not assets", nil).Once()
+	m.Kmm.On("UpdateCloudCfg").Return(nil)
+	m.Kmm.On("CopyKubeCa").Return(nil)
+	m.Kubeadm.On("WriteManifests").Return(nil)
+
+	if err := k.CreateOrGetSharedAssets(); err == nil {
+		t.Error(fmt.Errorf("expected an error for plain text assets but got none"))
+	}
+	m.Kubeadm.AssertNotCalled(t, "SaveAssets", "not assets")
+	m.Etcd.AssertNotCalled(t, "GetOrCreateLock", assetLockKey, defaultLockTTL)
+}
+
+func TestCreateOrGetSharedAssetsLegacyNotEncrypted(t *testing.T) {
+
+	m, k := getTestMock()
+
+	// Test secondary master upgraded from a kmm which shared version 0 assets unencrypted:
+	// The assets are used once and encrypted in etcd under the asset lock
+	legacyAssets := `{"FrontProxyCa":"ca","FrontProxyCaKey":"ca-key","SaPub":"sa-pub","SaKey":"sa-key"}`
+	sealedLegacy := mock.MatchedBy(func(sealed string) bool {
+		assets, err := envelope.Open(k.AssetKeyProvider, sealed)
+		return err == nil && string(assets) == legacyAssets
+	})
+	m.Etcd.On("Get", assetKey).Return(legacyAssets, nil).Twice()
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(true, nil).Once()
+	m.Etcd.On("PutLocked", assetKey, sealedLegacy, assetLockKey).Return(nil).Once()
+	m.Etcd.On("ReleaseLock", assetLockKey).Return(nil).Once()
+	m.Kubeadm.On("SaveAssets", legacyAssets).Return(nil).Once()
+	m.Etcd.On("Put", testAckKey(t), "0").Return(nil).Once()
+	AddMasterAssertions(m, false)
+
+	if err := k.CreateOrGetSharedAssets(); err != nil {
+		t.Error(err)
+	}
+	m.Kmm.AssertExpectations(t)
+	m.Etcd.AssertExpectations(t)
+	m.Kubeadm.AssertExpectations(t)
+
+	// Another master is encrypting them: still used (once)
+	m, k = getTestMock()
+	m.Etcd.On("Get", assetKey).Return(legacyAssets, nil).Once()
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(false, nil).Once()
+	m.Kubeadm.On("SaveAssets", legacyAssets).Return(nil).Once()
+	m.Etcd.On("Put", testAckKey(t), "0").Return(nil).Once()
+	AddMasterAssertions(m, false)
+
+	if err := k.CreateOrGetSharedAssets(); err != nil {
+		t.Error(err)
+	}
+	m.Etcd.AssertNotCalled(t, "PutLocked", assetKey, mock.AnythingOfType("string"), assetLockKey)
+	m.Kubeadm.AssertExpectations(t)
+}
+
+func TestRotateSharedAssets(t *testing.T) {
+	m, k := getTestMock()
+
+	sealedAssets, err := envelope.Seal(k.AssetKeyProvider, []byte(testAssets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedAssets := `{"revision":1,"rotation":{"revision":1}}`
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(true, nil).Once()
+	m.Etcd.On("Get", assetKey).Return(sealedAssets, nil).Once()
+	m.Kubeadm.On("RotateAssets", testAssets).Return(rotatedAssets, nil).Once()
+	m.Etcd.On("PutLocked", assetKey, mock.AnythingOfType("string"), assetLockKey).Return(nil).Once()
+	m.Etcd.On("ReleaseLock", assetLockKey).Return(nil).Once()
+
+	if err := k.RotateSharedAssets(); err != nil {
+		t.Error(err)
+	}
+	m.Etcd.AssertExpectations(t)
+	m.Kubeadm.AssertExpectations(t)
+
+	// The lock is lost before the rotated assets are written (checked as they're put)
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(true, nil).Once()
+	m.Etcd.On("Get", assetKey).Return(sealedAssets, nil).Once()
+	m.Kubeadm.On("RotateAssets", testAssets).Return(rotatedAssets, nil).Once()
+	m.Etcd.On("PutLocked", assetKey, mock.AnythingOfType("string"), assetLockKey).Return(etcd.ErrLockChanged).Once()
+	m.Etcd.On("ReleaseLock", assetLockKey).Return(nil).Once()
+	if err := k.RotateSharedAssets(); err == nil {
+		t.Error(fmt.Errorf("expected an error when the lock is lost but got none"))
+	}
+	m.Etcd.AssertNotCalled(t, "Put", assetKey, mock.AnythingOfType("string"))
+
+	// Another master holds the lock
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(false, nil).Once()
+	m.Etcd.On("GetLockHolder", assetLockKey).Return(etcd.LockHolder{HostName: "master1"}, nil).Once()
+	if err := k.RotateSharedAssets(); err == nil {
+		t.Error(fmt.Errorf("expected an error when lock held but got none"))
+	}
+}
+
+func TestRetireSharedAssets(t *testing.T) {
+	m, k := getTestMock()
+	k.KubeadmCfg = &kubeadm.Config{MasterCount: 2}
+
+	rotatedAssets := `{"revision":1,"rotation":{"revision":1}}`
+	sealedAssets, err := envelope.Seal(k.AssetKeyProvider, []byte(rotatedAssets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not all masters have the rotated keys yet
+	m.Etcd.On("GetOrCreateLock", assetLockKey, defaultLockTTL).Return(true, nil)
+	m.Etcd.On("Get", assetKey).Return(sealedAssets, nil)
+	m.Etcd.On("ReleaseLock", assetLockKey).Return(nil)
+	m.Etcd.On("GetPrefix", assetAckPrefix).Return(map[string]string{
+		assetAckPrefix + "master1": "1",
+		assetAckPrefix + "master2": "0",
+	}, nil).Once()
+	if err := k.RetireSharedAssets(); err == nil {
+		t.Error(fmt.Errorf("expected an error when not acknowledged but got none"))
+	}
+	m.Kubeadm.AssertNotCalled(t, "RetireAssets", rotatedAssets)
+
+	// All masters acknowledged
+	m.Etcd.On("GetPrefix", assetAckPrefix).Return(map[string]string{
+		assetAckPrefix + "master1": "1",
+		assetAckPrefix + "master2": "1",
+	}, nil).Once()
+	m.Kubeadm.On("RetireAssets", rotatedAssets).Return(`{"revision":2}`, nil).Once()
+	m.Etcd.On("PutLocked", assetKey, mock.AnythingOfType("string"), assetLockKey).Return(nil).Once()
+	if err := k.RetireSharedAssets(); err != nil {
+		t.Error(err)
+	}
+	m.Etcd.AssertExpectations(t)
+	m.Kubeadm.AssertExpectations(t)
+}
+
+func TestSyncSharedAssets(t *testing.T) {
+	m, k := getTestMock()
+
+	rotatedAssets := `{"revision":1,"rotation":{"revision":1}}`
+	sealedAssets, err := envelope.Seal(k.AssetKeyProvider, []byte(rotatedAssets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Etcd.On("Get", assetKey).Return(sealedAssets, nil)
+
+	// Another master is reloading
+	m.Etcd.On("Get", testAckKey(t)).Return("0", nil).Once()
+	m.Etcd.On("GetOrCreateLock", assetReloadLockKey, defaultLockTTL).Return(false, nil).Once()
+	m.Etcd.On("GetLockHolder", assetReloadLockKey).Return(etcd.LockHolder{}, nil).Once()
+	if reloaded, err := k.SyncSharedAssets(); err != nil || reloaded {
+		t.Error(fmt.Errorf("expected reloaded == false but got %v, error:%q", reloaded, err))
+	}
+	m.Kubeadm.AssertNotCalled(t, "ReloadAssets", rotatedAssets)
+
+	// New revision
+	m.Etcd.On("Get", testAckKey(t)).Return("0", nil).Once()
+	m.Etcd.On("GetOrCreateLock", assetReloadLockKey, defaultLockTTL).Return(true, nil).Once()
+	m.Kubeadm.On("ReloadAssets", rotatedAssets).Return(nil).Once()
+	m.Etcd.On("Put", testAckKey(t), "1").Return(nil).Once()
+	m.Etcd.On("ReleaseLock", assetReloadLockKey).Return(nil).Once()
+	if reloaded, err := k.SyncSharedAssets(); err != nil || !reloaded {
+		t.Error(fmt.Errorf("expected reloaded == true but got %v, error:%q", reloaded, err))
+	}
+
+	// Already acknowledged
+	m.Etcd.On("Get", testAckKey(t)).Return("1", nil).Once()
+	if reloaded, err := k.SyncSharedAssets(); err != nil || reloaded {
+		t.Error(fmt.Errorf("expected reloaded == false but got %v, error:%q", reloaded, err))
+	}
+	m.Etcd.AssertExpectations(t)
+	m.Kubeadm.AssertExpectations(t)
+}
+
+// sharedLockEtcd is a mock etcd client holding its locks in a lock table shared by other masters
+type sharedLockEtcd struct {
+	*etcdMocks.Clienter
+	mu    *sync.Mutex
+	locks map[string]*sharedLockEtcd
+}
+
+func (e *sharedLockEtcd) GetOrCreateLock(key string, lockKeyTTL time.Duration) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if holder, held := e.locks[key]; held {
+		return holder == e, nil
+	}
+	e.locks[key] = e
+	return true, nil
+}
+
+func (e *sharedLockEtcd) ReleaseLock(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.locks[key] == e {
+		delete(e.locks, key)
+	}
+	return nil
+}
+
+func TestSyncSharedAssetsOneMasterAtATime(t *testing.T) {
+	const masters = 3
+	rotatedAssets := `{"revision":1,"rotation":{"revision":1}}`
+	mu := &sync.Mutex{}
+	locks := map[string]*sharedLockEtcd{}
+	var restarting, maxRestarting int32
+	done := make(chan error, masters)
+	for i := 0; i < masters; i++ {
+		m, k := getTestMock()
+		k.Etcd = &sharedLockEtcd{Clienter: m.Etcd, mu: mu, locks: locks}
+		sealedAssets, err := envelope.Seal(k.AssetKeyProvider, []byte(rotatedAssets))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Etcd.On("Get", assetKey).Return(sealedAssets, nil)
+		m.Etcd.On("Get", testAckKey(t)).Return("0", nil)
+		m.Etcd.On("GetLockHolder", assetReloadLockKey).Return(etcd.LockHolder{}, nil)
+		m.Etcd.On("Put", testAckKey(t), "1").Return(nil).Once()
+		m.Kubeadm.On("ReloadAssets", rotatedAssets).Return(nil).Once().Run(func(args mock.Arguments) {
+			now := atomic.AddInt32(&restarting, 1)
+			mu.Lock()
+			if now > maxRestarting {
+				maxRestarting = now
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&restarting, -1)
+		})
+		go func() {
+			// Each master syncs (as if supervised) until it has reloaded
+			for attempt := 0; attempt < 1000; attempt++ {
+				reloaded, err := k.SyncSharedAssets()
+				if err != nil || reloaded {
+					done <- err
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+			done <- fmt.Errorf("master never reloaded the shared assets")
+		}()
+	}
+	for i := 0; i < masters; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	if maxRestarting != 1 {
+		t.Errorf("expected one master restarting at a time but got %d at once", maxRestarting)
+	}
+	if len(locks) != 0 {
+		t.Errorf("expected the reload lock to be released")
+	}
+}
+
+func TestBackOff(t *testing.T) {
+	max := 5 * time.Second
+	b := newBackOff(max)
+	for i, upper := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, max, max} {
+		if delay := b.next(); delay < upper/2 || delay > upper {
+			t.Errorf("expected back off %d between %v and %v but got %v", i, upper/2, upper, delay)
+		}
+	}
+	b.reset()
+	if delay := b.next(); delay > time.Second {
+		t.Errorf("expected back off reset to at most %v but got %v", time.Second, delay)
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	stop := make(chan struct{})
+	changed := make(chan struct{})
+	reconciled := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		supervise(stop, time.Hour, changed, func() error {
+			reconciled <- struct{}{}
+			return fmt.Errorf("errors must not stop supervising")
+		})
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		changed <- struct{}{}
+		select {
+		case <-reconciled:
+		case <-time.After(time.Second):
+			t.Fatal(fmt.Errorf("expected reconcile when changed"))
+		}
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error(fmt.Errorf("expected supervise to return when stopped"))
+	}
+}
+
+func TestAnyChanged(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	first := make(chan struct{})
+	second := make(chan struct{})
+	changed := anyChanged(stop, first, second)
+	for _, c := range []chan struct{}{first, second} {
+		c <- struct{}{}
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Fatal(fmt.Errorf("expected a change to be signalled"))
+		}
+	}
+}
+
+func TestReconcileMaster(t *testing.T) {
+	m, k := getTestMock()
+
+	sealedAssets, err := envelope.Seal(k.AssetKeyProvider, []byte(testAssets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Etcd.On("Get", assetKey).Return(sealedAssets, nil).Once()
+	m.Etcd.On("Get", testAckKey(t)).Return("0", nil).Once()
+	m.Kmm.On("CreateAndStartKubelet", true).Return(nil).Once()
+	m.Kubeadm.On("ReconcileManifests").Return(true, nil).Once()
+	m.Kmm.On("ReconcileNetwork").Return(nil).Once()
+
+	if err := k.reconcileMaster(); err != nil {
+		t.Error(err)
+	}
+	m.Kubeadm.AssertNotCalled(t, "ReloadAssets", testAssets)
+	m.Etcd.AssertExpectations(t)
+	m.Kmm.AssertExpectations(t)
+	m.Kubeadm.AssertExpectations(t)
+}
+
+func TestPlan(t *testing.T) {
+	_, k := getTestMock()
+
+	expected := map[Role][]string{
+		RolePrimary:   {pkiPhase, "kubeconfig", "kubelet", "addons", "network", "tokens", "ready"},
+		RoleSecondary: {"save-assets", pkiPhase, "kubeconfig", "kubelet", "master-role"},
+		RoleCompute:   {"token-env", "kubelet"},
+	}
+	for role, names := range expected {
+		phases, err := k.Plan(role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if planned := fmt.Sprint(phaseNames(phases)); planned != fmt.Sprint(names) {
+			t.Errorf("expected %s phases %v but got %v", role, names, planned)
+		}
+	}
+
+	k.Phases = []string{"tokens", "network"}
+	k.SkipPhases = []string{"tokens"}
+	if phases, err := k.Plan(RolePrimary); err != nil {
+		t.Error(err)
+	} else if planned := fmt.Sprint(phaseNames(phases)); planned != "[network]" {
+		t.Errorf("expected only the network phase but got %v", planned)
+	}
+
+	k.SkipPhases = []string{"no-such-phase"}
+	if _, err := k.Plan(RolePrimary); err == nil {
+		t.Error(fmt.Errorf("expected error for an unknown phase"))
+	}
+}
+
+func TestUpdatePodNetworkCidr(t *testing.T) {
+	c := &ConfigType{
+		KubeadmCfg: &kubeadm.Config{
+			ControllerManagerExtraArgs: map[string]string{clusterCidrArg: "172.16.0.0/16"},
+		},
+		NetworkProvider: "flannel",
+	}
+	// The cluster CIDR from the cloud provider is used when no pod network CIDR is set
+	if err := c.updatePodNetworkCidr(); err != nil {
+		t.Fatal(err)
+	}
+	if c.NetworkCfg.PodNetworkCidr != "172.16.0.0/16" || c.KubeadmCfg.PodNetworkCidr != "172.16.0.0/16" {
+		t.Errorf("expected the cluster CIDR used but got %q and %q", c.NetworkCfg.PodNetworkCidr, c.KubeadmCfg.PodNetworkCidr)
+	}
+
+	c.NetworkCfg.PodNetworkCidr = "10.244.0.0/16"
+	if err := c.updatePodNetworkCidr(); err == nil {
+		t.Error(fmt.Errorf("expected error for a pod network CIDR not matching the cluster CIDR"))
+	}
+}
+
+func TestOrderPhasesCycle(t *testing.T) {
+	phases := []Phase{
+		{Name: "a", DependsOn: []string{"c"}},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "c", DependsOn: []string{"b"}},
+	}
+	if _, err := orderPhases(phases); err == nil {
+		t.Error(fmt.Errorf("expected error for a dependency cycle"))
+	}
+}
+
+func TestRunPhaseRetry(t *testing.T) {
+	attempts := 0
+	phase := Phase{
+		Name:  "test",
+		Retry: RetryPolicy{Attempts: 3, Delay: time.Millisecond},
+		Run: func(role Role, state *bootstrapState) error {
+			attempts++
+			if attempts < 3 {
+				return fmt.Errorf("failed attempt %d", attempts)
+			}
+			return nil
+		},
+	}
+	if err := runPhase(RolePrimary, phase, &bootstrapState{}); err != nil {
+		t.Error(err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts but got %d", attempts)
+	}
+
+	attempts = 0
+	phase.Retry.Attempts = 2
+	if err := runPhase(RolePrimary, phase, &bootstrapState{}); err == nil {
+		t.Error(fmt.Errorf("expected error when out of attempts"))
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts but got %d", attempts)
+	}
+}
+
+func TestBootstrapOnceSkipPKI(t *testing.T) {
+	_, k := getTestMock()
+	k.SkipPhases = []string{pkiPhase}
+
+	if _, err := k.BootstrapOnce(); err == nil {
+		t.Error(fmt.Errorf("expected error when skipping the pki phase on the primary master"))
+	}
+}
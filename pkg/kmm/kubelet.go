@@ -3,14 +3,13 @@ package kmm
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"strings"
 	"text/template"
 
 	"github.com/UKHomeOffice/keto-k8/pkg/constants"
-	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/dryrun"
 	"github.com/coreos/go-systemd/dbus"
 )
 
@@ -51,57 +50,78 @@ func (k *Kmm) CreateAndStartKubelet(master bool) error {
 		return fmt.Errorf("Error generating kubelet unit [%v] from template:\n%v", err, kubeletTemplate)
 	}
 
-	// Get D-bus connection
+	// Manage unit file
 	target := path.Base(constants.KubeletUnitFileName)
+	oldUnit, err := k.fs().ReadFile(constants.KubeletUnitFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error [%v] reading existing unit [%v]", err, constants.KubeletUnitFileName)
+	}
+	changed := string(oldUnit) != b.String()
+	if changed {
+		// Create (or replace) unit
+		if err := k.fs().WriteFile(constants.KubeletUnitFileName, b.Bytes(), 0644); err != nil {
+			return fmt.Errorf("Can't save unit file [%v]: [%v]",
+				constants.KubeletUnitFileName,
+				err)
+		}
+	}
+	if k.DryRun != nil {
+		if changed {
+			k.DryRun.Note(dryrun.KindUnit, target, dryrun.ActionRestart, "")
+		}
+		return nil
+	}
+
+	// Get D-bus connection
 	conn, err := dbus.New()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-
-	// Manage unit file
-	if fileutil.ExistFile(constants.KubeletUnitFileName) {
-		// Tidy up existing file...
-		oldUnit, err := ioutil.ReadFile(constants.KubeletUnitFileName)
-		if err != nil {
-			return fmt.Errorf("Error [%v] reading existing unit [%v]", err, kubeletTemplate)
+	if changed {
+		// Daemon-reload TODO: make reload unit specific
+		if err := conn.Reload(); err != nil {
+			return fmt.Errorf("Problem reloading systemd units after adding %q; [%v]", target, err)
 		}
-		if string(oldUnit) != b.String() {
-			// delete file
-			if err := os.Remove(constants.KubeletUnitFileName); err != nil {
-				return fmt.Errorf("Error [%v] removing existing kubelet unit [%v]",
-					err,
-					constants.KubeletUnitFileName)
-			}
-			// TODO: stop unit if already running
-		} else {
-			// TODO: return IF kubelet already running, return here (no change)
+	}
 
-		}
+	// Start unit (no change if already running) or restart with a changed unit
+	startUnit := conn.StartUnit
+	if changed {
+		startUnit = conn.RestartUnit
 	}
-	if !fileutil.ExistFile(constants.KubeletUnitFileName) {
-		// Create unit
-		if err := ioutil.WriteFile(constants.KubeletUnitFileName, []byte(b.Bytes()), 0644); err != nil {
-			return fmt.Errorf("Can't save unit file [%v]: [%v]",
-				constants.KubeletUnitFileName,
-				err)
-		}
+	if err := runUnitJob(startUnit, target); err != nil {
+		return err
 	}
-	// Daemon-reload TODO: make reload unit specific
-	if err := conn.Reload(); err != nil {
-		return fmt.Errorf("Problem reloading systemd units after adding %q; [%v]", target, err)
+
+	// TODO: enable unit (link if required)
+	return nil
+}
+
+// restartKubelet restarts the kubelet unit (e.g. so pods are networked with a new CNI config)
+func (k *Kmm) restartKubelet() error {
+	target := path.Base(constants.KubeletUnitFileName)
+	if k.DryRun != nil {
+		k.DryRun.Note(dryrun.KindUnit, target, dryrun.ActionRestart, "")
+		return nil
 	}
+	conn, err := dbus.New()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return runUnitJob(conn.RestartUnit, target)
+}
 
-	// Start / restart unit
+// runUnitJob starts (or restarts) a unit and waits for the job to complete
+func runUnitJob(startUnit func(name string, mode string, ch chan<- string) (int, error), target string) error {
 	reschan := make(chan string)
-	if _, err := conn.StartUnit(target, "replace", reschan); err != nil {
+	if _, err := startUnit(target, "replace", reschan); err != nil {
 		return fmt.Errorf("Can't start unit [%v] - [%v]", target, err)
 	}
 	job := <-reschan
 	if job != "done" {
 		return fmt.Errorf("Unknown error starting [%v]", target)
 	}
-
-	// TODO: enable unit (link if required)
 	return nil
 }
@@ -0,0 +1,31 @@
+package kmm
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+)
+
+// AssetLockHolder returns the details of the master holding the shared asset lock
+func AssetLockHolder(e etcd.Clienter) (holder etcd.LockHolder, err error) {
+	return e.GetLockHolder(assetLockKey)
+}
+
+// BreakAssetLock will clear the shared asset lock without touching any shared assets
+// Will only remove the lock if unchanged since the holder was last inspected
+func BreakAssetLock(e etcd.Clienter) (err error) {
+	holder, err := e.GetLockHolder(assetLockKey)
+	if err == etcd.ErrKeyMissing {
+		log.Printf("Lock %q not held, nothing to break", assetLockKey)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Breaking lock %q held by %s", assetLockKey, holder)
+	if err = e.BreakLock(assetLockKey, holder); err == etcd.ErrLockChanged {
+		return fmt.Errorf("lock %q changed whilst breaking it, check lock status and try again", assetLockKey)
+	}
+	return err
+}
@@ -0,0 +1,280 @@
+package kmm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
+	"github.com/UKHomeOffice/keto-k8/pkg/network"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The node annotations requesting network changes from the kmm supervising each node
+const (
+	// networkRequestAnnotation is a json encoded networkRequest
+	networkRequestAnnotation string = "kmm-network-request"
+	// networkDoneAnnotation is the ID of the last network request completed on the node
+	networkDoneAnnotation string = "kmm-network-done"
+)
+
+// cniConfigDir is where network providers write CNI configs on each node
+const cniConfigDir string = "/etc/cni/net.d"
+
+// nodeNameEnv is the host IP the kubelet registers as its node name (see --hostname-override)
+const nodeNameEnv string = "COREOS_PRIVATE_IPV4"
+
+// DefaultNodeTimeout is the time allowed to drain and restart each node when migrating the network
+// Nodes complete requests when reconciled so it must be longer than the reconcile period of each node.
+const DefaultNodeTimeout time.Duration = 15 * time.Minute
+
+// networkRequest asks the kmm supervising a node to remove stale CNI configs (and restart the kubelet)
+type networkRequest struct {
+	ID              string   `json:"id"`
+	StaleCNIConfigs []string `json:"staleCniConfigs,omitempty"`
+	RestartKubelet  bool     `json:"restartKubelet,omitempty"`
+}
+
+// networkMigration moves the cluster between network providers
+type networkMigration struct {
+	applier      k8client.Applier
+	waiter       k8client.Waiter
+	nodes        k8client.NodeManager
+	from         network.Provider
+	to           network.Provider
+	readyTimeout time.Duration
+	nodeTimeout  time.Duration
+}
+
+// MigrateNetwork moves the cluster from a network provider to the configured network provider
+// The new provider is applied, then each node is drained and restarted in turn (compute nodes first)
+// before the objects of the old provider are removed and every node cleans up the old CNI configs.
+func (k *Config) MigrateNetwork(from string, nodeTimeout time.Duration) error {
+	if from == k.NetworkProvider {
+		return fmt.Errorf("The network provider is already %s", from)
+	}
+	// Only the objects and CNI configs of the old provider are required (the pod network can't change)
+	oldNp, err := network.CreateProvider(from, network.Config{PodNetworkCidr: k.NetworkCfg.PodNetworkCidr})
+	if err != nil {
+		return err
+	}
+	newNp, err := network.CreateProvider(k.NetworkProvider, k.NetworkCfg)
+	if err != nil {
+		return err
+	}
+	c, err := k8client.NewAdmin()
+	if err != nil {
+		return err
+	}
+	m := &networkMigration{
+		applier:      c,
+		waiter:       c,
+		nodes:        c,
+		from:         oldNp,
+		to:           newNp,
+		readyTimeout: k.ReadyTimeout,
+		nodeTimeout:  nodeTimeout,
+	}
+	return m.run()
+}
+
+// run migrates every node (it can be run again if a node fails to migrate)
+func (m *networkMigration) run() error {
+	log.Printf("Migrating the network from %s to %s...", m.from.Name(), m.to.Name())
+	oldManifest, err := m.from.Manifest()
+	if err != nil {
+		return err
+	}
+	newManifest, err := m.to.Manifest()
+	if err != nil {
+		return err
+	}
+	labelled, err := k8client.Label(network.Component, newManifest)
+	if err != nil {
+		return err
+	}
+	// The old provider isn't pruned until every node has been restarted
+	if err = m.applier.Apply(labelled); err != nil {
+		return err
+	}
+	if err = k8client.WaitForResources(m.waiter, newManifest, m.readyTimeout); err != nil {
+		return err
+	}
+
+	nodes, err := m.nodes.Nodes()
+	if err != nil {
+		return err
+	}
+	stale := network.StaleCNIConfigs(m.from, m.to)
+	for _, node := range migrationOrder(nodes) {
+		if err = m.restartNode(node, stale); err != nil {
+			return fmt.Errorf("Error migrating node %s, the node may still be cordoned [%v]", node.Name, err)
+		}
+	}
+
+	// Objects with the same name in both providers have already been updated
+	oldOnly, err := k8client.Without(oldManifest, newManifest)
+	if err != nil {
+		return err
+	}
+	if len(oldOnly) > 0 {
+		if err = m.applier.Delete(oldOnly); err != nil {
+			return err
+		}
+	}
+	if err = m.applier.Prune(network.Component, labelled); err != nil {
+		return err
+	}
+	// The old provider may have rewritten its CNI configs before being removed
+	if len(stale) > 0 {
+		if err = m.request(nodes, networkRequest{ID: requestID(), StaleCNIConfigs: stale}); err != nil {
+			return err
+		}
+	}
+	log.Printf("Migrated the network from %s to %s", m.from.Name(), m.to.Name())
+	return nil
+}
+
+// restartNode drains a node then waits for it to remove the stale CNI configs and restart the kubelet
+// A node already cordoned is left cordoned (e.g. masters).
+func (m *networkMigration) restartNode(node v1.Node, stale []string) error {
+	cordon := !node.Spec.Unschedulable
+	if cordon {
+		if err := m.nodes.Cordon(node.Name, true); err != nil {
+			return err
+		}
+	}
+	if err := m.nodes.Drain(node.Name, m.nodeTimeout); err != nil {
+		return err
+	}
+	req := networkRequest{ID: requestID(), StaleCNIConfigs: stale, RestartKubelet: true}
+	if err := m.request([]v1.Node{node}, req); err != nil {
+		return err
+	}
+	if cordon {
+		return m.nodes.Cordon(node.Name, false)
+	}
+	return nil
+}
+
+// request annotates nodes with a network request then waits for each node to complete it
+func (m *networkMigration) request(nodes []v1.Node, req networkRequest) error {
+	value, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err = m.nodes.AnnotateNode(node.Name, map[string]string{networkRequestAnnotation: string(value)}); err != nil {
+			return err
+		}
+	}
+	for _, node := range nodes {
+		if err = m.nodes.WaitForNodeAnnotation(node.Name, networkDoneAnnotation, req.ID, m.nodeTimeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrationOrder returns the compute nodes then the master nodes (each in name order)
+func migrationOrder(nodes []v1.Node) []v1.Node {
+	byName := map[string]v1.Node{}
+	var compute, masters []string
+	for _, node := range nodes {
+		byName[node.Name] = node
+		if _, master := node.Labels[k8client.MasterRoleLabel]; master {
+			masters = append(masters, node.Name)
+		} else {
+			compute = append(compute, node.Name)
+		}
+	}
+	sort.Strings(compute)
+	sort.Strings(masters)
+	var ordered []v1.Node
+	for _, name := range append(compute, masters...) {
+		ordered = append(ordered, byName[name])
+	}
+	return ordered
+}
+
+// requestID identifies a network request
+func requestID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// ReconcileNetwork completes any network request for this node (see MigrateNetwork)
+// Requests are read with the kubelet kubeconfig, so are only checked once the kubelet has joined.
+func (k *Kmm) ReconcileNetwork() error {
+	kubeConfig := k8client.KubeletKubeConfigPath()
+	if _, err := os.Stat(kubeConfig); os.IsNotExist(err) {
+		log.Printf("No kubelet kubeconfig at %s yet (the kubelet hasn't joined), not checking for network requests", kubeConfig)
+		return nil
+	}
+	c, err := k8client.NewKubelet()
+	if err != nil {
+		return err
+	}
+	return k.reconcileNetwork(c, nodeName())
+}
+
+// reconcileNetwork removes the stale CNI configs (and restarts the kubelet) for a request not yet completed
+func (k *Kmm) reconcileNetwork(n k8client.NodeManager, name string) error {
+	node, err := n.Node(name)
+	if err != nil {
+		return err
+	}
+	value, requested := node.Annotations[networkRequestAnnotation]
+	if !requested {
+		return nil
+	}
+	var req networkRequest
+	if err = json.Unmarshal([]byte(value), &req); err != nil {
+		return fmt.Errorf("Invalid network request for node %s [%v]", name, err)
+	}
+	if req.ID == node.Annotations[networkDoneAnnotation] {
+		return nil
+	}
+	log.Printf("Completing network request %s...", req.ID)
+	for _, file := range req.StaleCNIConfigs {
+		if err = removeCNIConfig(k.fs(), file); err != nil {
+			return err
+		}
+	}
+	if req.RestartKubelet {
+		if err = k.restartKubelet(); err != nil {
+			return err
+		}
+	}
+	return n.AnnotateNode(name, map[string]string{networkDoneAnnotation: req.ID})
+}
+
+// removeCNIConfig removes a CNI config file (if present)
+func removeCNIConfig(fs fileutil.Filesystem, file string) error {
+	if file != filepath.Base(file) || file == "." || file == ".." {
+		return fmt.Errorf("Invalid CNI config %q, must be a file name", file)
+	}
+	err := fs.Remove(filepath.Join(cniConfigDir, file))
+	if err == nil {
+		log.Printf("Removed stale CNI config %s", file)
+		return nil
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// nodeName is the name this node is registered with
+func nodeName() string {
+	if name := os.Getenv(nodeNameEnv); len(name) > 0 {
+		return name
+	}
+	hostName, _ := os.Hostname()
+	return hostName
+}
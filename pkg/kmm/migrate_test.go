@@ -0,0 +1,230 @@
+package kmm
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/dryrun"
+	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
+	k8clientMocks "github.com/UKHomeOffice/keto-k8/pkg/k8client/mocks"
+	"github.com/UKHomeOffice/keto-k8/pkg/network"
+	"github.com/stretchr/testify/mock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+)
+
+// testWaiter is a k8client.Waiter where every workload is ready
+type testWaiter struct{}
+
+func (w testWaiter) WaitForDaemonSet(namespace, name string, timeout time.Duration) error {
+	return nil
+}
+
+func (w testWaiter) WaitForDeployment(namespace, name string, timeout time.Duration) error {
+	return nil
+}
+
+func (w testWaiter) WaitForNodes(timeout time.Duration) error {
+	return nil
+}
+
+func testNode(name string, master bool) v1.Node {
+	node := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+	if master {
+		node.Labels[k8client.MasterRoleLabel] = ""
+		node.Spec.Unschedulable = true
+	}
+	return node
+}
+
+func getTestMigration(t *testing.T) (*networkMigration, *k8clientMocks.Applier, *k8clientMocks.NodeManager) {
+	from, err := network.CreateProvider("flannel", network.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := network.CreateProvider("canal", network.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &k8clientMocks.Applier{}
+	n := &k8clientMocks.NodeManager{}
+	m := &networkMigration{
+		applier:      a,
+		waiter:       testWaiter{},
+		nodes:        n,
+		from:         from,
+		to:           to,
+		readyTimeout: time.Minute,
+		nodeTimeout:  time.Minute,
+	}
+	return m, a, n
+}
+
+// annotatedRequests returns the network requests annotated on a node in order
+func annotatedRequests(t *testing.T, n *k8clientMocks.NodeManager, name string) []networkRequest {
+	var requests []networkRequest
+	for _, call := range n.Calls {
+		if call.Method != "AnnotateNode" || call.Arguments.String(0) != name {
+			continue
+		}
+		var req networkRequest
+		annotations := call.Arguments.Get(1).(map[string]string)
+		if err := json.Unmarshal([]byte(annotations[networkRequestAnnotation]), &req); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+func TestMigrateNetwork(t *testing.T) {
+	m, a, n := getTestMigration(t)
+	nodes := []v1.Node{testNode("10.0.0.1", true), testNode("10.0.1.2", false), testNode("10.0.1.1", false)}
+
+	a.On("Apply", mock.AnythingOfType("string")).Return(nil).Once()
+	n.On("Nodes").Return(nodes, nil).Once()
+	for _, node := range nodes {
+		if !node.Spec.Unschedulable {
+			n.On("Cordon", node.Name, true).Return(nil).Once()
+			n.On("Cordon", node.Name, false).Return(nil).Once()
+		}
+		n.On("Drain", node.Name, m.nodeTimeout).Return(nil).Once()
+		n.On("AnnotateNode", node.Name, mock.AnythingOfType("map[string]string")).Return(nil).Twice()
+		n.On("WaitForNodeAnnotation", node.Name, networkDoneAnnotation, mock.AnythingOfType("string"), m.nodeTimeout).Return(nil).Twice()
+	}
+	a.On("Delete", mock.AnythingOfType("string")).Return(nil).Once()
+	a.On("Prune", network.Component, mock.AnythingOfType("string")).Return(nil).Once()
+
+	if err := m.run(); err != nil {
+		t.Fatal(err)
+	}
+	a.AssertExpectations(t)
+	n.AssertExpectations(t)
+	n.AssertNotCalled(t, "Cordon", "10.0.0.1", mock.Anything)
+
+	// Compute nodes are migrated before the masters
+	var drained []string
+	for _, call := range n.Calls {
+		if call.Method == "Drain" {
+			drained = append(drained, call.Arguments.String(0))
+		}
+	}
+	if strings.Join(drained, ",") != "10.0.1.1,10.0.1.2,10.0.0.1" {
+		t.Errorf("expected compute nodes drained before masters but got %v", drained)
+	}
+
+	// Each node is restarted then cleans up after the old objects are removed
+	for _, node := range nodes {
+		requests := annotatedRequests(t, n, node.Name)
+		if len(requests) != 2 || !requests[0].RestartKubelet || requests[1].RestartKubelet {
+			t.Fatalf("expected a restart then a clean up request for %s but got %v", node.Name, requests)
+		}
+		for _, req := range requests {
+			if len(req.StaleCNIConfigs) != 1 || req.StaleCNIConfigs[0] != "10-flannel.conf" {
+				t.Errorf("expected the flannel CNI config to be removed but got %v", req.StaleCNIConfigs)
+			}
+		}
+	}
+
+	// Only objects not in the new manifest are deleted
+	var deleted string
+	for _, call := range a.Calls {
+		if call.Method == "Delete" {
+			deleted = call.Arguments.String(0)
+		}
+	}
+	if !strings.Contains(deleted, "kube-flannel-ds") || strings.Contains(deleted, "canal") {
+		t.Errorf("expected only the flannel objects to be deleted but got:\n%s", deleted)
+	}
+}
+
+func TestMigrateNetworkNodeError(t *testing.T) {
+	m, a, n := getTestMigration(t)
+	drainErr := errors.New("pods not deleted")
+
+	a.On("Apply", mock.AnythingOfType("string")).Return(nil).Once()
+	n.On("Nodes").Return([]v1.Node{testNode("10.0.1.1", false), testNode("10.0.1.2", false)}, nil).Once()
+	n.On("Cordon", "10.0.1.1", true).Return(nil).Once()
+	n.On("Drain", "10.0.1.1", m.nodeTimeout).Return(drainErr).Once()
+
+	err := m.run()
+	if err == nil || !strings.Contains(err.Error(), drainErr.Error()) {
+		t.Errorf("expected %v but got %v", drainErr, err)
+	}
+	a.AssertExpectations(t)
+	n.AssertExpectations(t)
+	// The old provider is left until every node has migrated
+	a.AssertNotCalled(t, "Delete", mock.AnythingOfType("string"))
+	a.AssertNotCalled(t, "Prune", network.Component, mock.AnythingOfType("string"))
+	n.AssertNotCalled(t, "Drain", "10.0.1.2", m.nodeTimeout)
+}
+
+func TestReconcileNetwork(t *testing.T) {
+	k := &Kmm{}
+	k.DryRun = dryrun.New()
+	n := &k8clientMocks.NodeManager{}
+	node := testNode("10.0.1.1", false)
+	node.Annotations = map[string]string{
+		networkRequestAnnotation: `{"id":"2","staleCniConfigs":["10-flannel.conf"],"restartKubelet":true}`,
+		networkDoneAnnotation:    "1",
+	}
+	n.On("Node", node.Name).Return(&node, nil).Once()
+	n.On("AnnotateNode", node.Name, map[string]string{networkDoneAnnotation: "2"}).Return(nil).Once()
+
+	if err := k.reconcileNetwork(n, node.Name); err != nil {
+		t.Fatal(err)
+	}
+	n.AssertExpectations(t)
+	if c := k.DryRun.Changes(); len(c) != 1 || c[0].Kind != dryrun.KindUnit || c[0].Action != dryrun.ActionRestart {
+		t.Errorf("expected the kubelet restarted but got %v", c)
+	}
+
+	// Completed requests are ignored
+	k.DryRun = dryrun.New()
+	node.Annotations[networkDoneAnnotation] = "2"
+	n.On("Node", node.Name).Return(&node, nil).Once()
+	if err := k.reconcileNetwork(n, node.Name); err != nil {
+		t.Fatal(err)
+	}
+	n.AssertNumberOfCalls(t, "AnnotateNode", 1)
+	if c := k.DryRun.Changes(); len(c) != 0 {
+		t.Errorf("expected no changes but got %v", c)
+	}
+}
+
+func TestReconcileNetworkNotJoined(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kmm-migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(kubernetesDir string) {
+		kubeadmapi.GlobalEnvParams.KubernetesDir = kubernetesDir
+	}(kubeadmapi.GlobalEnvParams.KubernetesDir)
+	kubeadmapi.GlobalEnvParams.KubernetesDir = dir
+
+	// A compute node without a kubelet kubeconfig yet has nothing to reconcile
+	k := &Kmm{}
+	k.DryRun = dryrun.New()
+	if err := k.ReconcileNetwork(); err != nil {
+		t.Errorf("expected no error before the kubelet has joined but got %v", err)
+	}
+}
+
+func TestRemoveCNIConfig(t *testing.T) {
+	for _, file := range []string{"../kubernetes/admin.conf", "net.d/10-flannel.conf", ".."} {
+		if err := removeCNIConfig(dryrun.New(), file); err == nil {
+			t.Errorf("expected an error removing %q", file)
+		}
+	}
+	// Already removed
+	if err := removeCNIConfig(dryrun.New(), "10-missing.conf"); err != nil {
+		t.Error(err)
+	}
+}
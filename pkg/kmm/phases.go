@@ -0,0 +1,330 @@
+package kmm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+	"github.com/UKHomeOffice/keto-k8/pkg/tokens"
+)
+
+// Role is the kind of node a bootstrap phase runs on
+type Role string
+
+const (
+	// RolePrimary is the master holding the lock and creating the shared assets
+	RolePrimary Role = "primary"
+	// RoleSecondary is any other master (using the shared assets from etcd)
+	RoleSecondary Role = "secondary"
+	// RoleCompute is a compute node
+	RoleCompute Role = "compute"
+)
+
+// Roles are all the roles in the order they are bootstrapped
+var Roles = []Role{RolePrimary, RoleSecondary, RoleCompute}
+
+// RetryPolicy is how often to attempt a phase before failing the bootstrap
+type RetryPolicy struct {
+	// Attempts is the total number of attempts (zero or one will not retry)
+	Attempts int
+	// Delay is the maximum back off between attempts
+	Delay time.Duration
+}
+
+// Phase is a step of bootstrapping a node
+// Each phase must be safe to repeat as a node (or lock holder) can fail before completing it.
+type Phase struct {
+	Name string
+	// DependsOn are the phases run first (when also planned for the same role)
+	DependsOn []string
+	Roles     []Role
+	// Checkpoint records the phase in etcd when run by the primary so a new lock holder skips it
+	Checkpoint bool
+	// Local phases only change this host so are checkpointed per host. Their checkpoint is only a hint as
+	// the host may have been rebuilt with the same name, so they always run (checking the work on the host).
+	Local bool
+	Retry RetryPolicy
+	Run   func(role Role, state *bootstrapState) error
+}
+
+// bootstrapState is shared between the phases of a single bootstrap
+type bootstrapState struct {
+	// assets are the (decrypted) shared assets
+	assets string
+}
+
+// apiRetry is for phases using the API server (which may still be starting)
+var apiRetry = RetryPolicy{Attempts: 3, Delay: 20 * time.Second}
+
+// DefaultPipeline is the bootstrap of every role
+func (k *Config) DefaultPipeline() []Phase {
+	return []Phase{
+		{
+			Name:  "token-env",
+			Roles: []Role{RoleCompute},
+			Run: func(role Role, state *bootstrapState) error {
+				if err := tokens.WriteKetoTokenEnv(k.fs(), k.KubeadmCfg.CloudProvider, k.KubeadmCfg.APIServer.String()); err != nil {
+					return fmt.Errorf("error saving KetoTokenEnv: %q", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "save-assets",
+			Roles: []Role{RoleSecondary},
+			Run: func(role Role, state *bootstrapState) error {
+				log.Printf("Saving assets to disk...")
+				return k.Kubeadm.SaveAssets(state.assets)
+			},
+		},
+		{
+			// The primary checkpoints this phase itself (with the shared assets)
+			Name:      pkiPhase,
+			DependsOn: []string{"save-assets"},
+			Roles:     []Role{RolePrimary, RoleSecondary},
+			Run: func(role Role, state *bootstrapState) (err error) {
+				if role == RolePrimary {
+					state.assets, err = k.bootstrapPKI()
+					return err
+				}
+				return k.Kubeadm.CreatePKI()
+			},
+		},
+		{
+			Name:       "kubeconfig",
+			DependsOn:  []string{pkiPhase},
+			Roles:      []Role{RolePrimary, RoleSecondary},
+			Checkpoint: true,
+			Local:      true,
+			Run: func(role Role, state *bootstrapState) error {
+				return k.Kubeadm.CreateKubeConfig()
+			},
+		},
+		{
+			Name:       "kubelet",
+			DependsOn:  []string{"kubeconfig", "token-env"},
+			Roles:      Roles,
+			Checkpoint: true,
+			Local:      true,
+			Run: func(role Role, state *bootstrapState) error {
+				return k.Kmm.CreateAndStartKubelet(role != RoleCompute)
+			},
+		},
+		{
+			// Note: Addons will call the same underlying kubeadmapi UpdateMasterRoleLabelsAndTaints
+			Name:       "addons",
+			DependsOn:  []string{"kubelet"},
+			Roles:      []Role{RolePrimary},
+			Checkpoint: true,
+			Retry:      apiRetry,
+			Run: func(role Role, state *bootstrapState) error {
+				return k.Kubeadm.Addons()
+			},
+		},
+		{
+			Name:      "master-role",
+			DependsOn: []string{"kubelet"},
+			Roles:     []Role{RoleSecondary},
+			Retry:     apiRetry,
+			Run: func(role Role, state *bootstrapState) error {
+				return k.Kubeadm.UpdateMasterRoleLabelsAndTaints()
+			},
+		},
+		{
+			Name:       "network",
+			DependsOn:  []string{"addons"},
+			Roles:      []Role{RolePrimary},
+			Checkpoint: true,
+			Retry:      apiRetry,
+			Run: func(role Role, state *bootstrapState) error {
+				return k.Kmm.InstallNetwork()
+			},
+		},
+		{
+			Name:       "tokens",
+			DependsOn:  []string{"addons"},
+			Roles:      []Role{RolePrimary},
+			Checkpoint: true,
+			Retry:      apiRetry,
+			Run: func(role Role, state *bootstrapState) error {
+				return k.Kmm.TokensDeploy()
+			},
+		},
+		{
+			// Never checkpointed so the assets are only shared once the cluster is healthy
+			Name:      "ready",
+			DependsOn: []string{"addons", "network", "tokens"},
+			Roles:     []Role{RolePrimary},
+			Run: func(role Role, state *bootstrapState) error {
+				return k.Kmm.WaitForReady()
+			},
+		},
+	}
+}
+
+// Plan returns the phases to run for a role in dependency order
+// Only the configured phases are planned (all by default) less any phases to skip.
+func (k *Config) Plan(role Role) ([]Phase, error) {
+	pipeline := k.DefaultPipeline()
+	known := map[string]bool{}
+	for _, phase := range pipeline {
+		known[phase.Name] = true
+	}
+	for _, phase := range pipeline {
+		for _, dep := range phase.DependsOn {
+			if !known[dep] {
+				return nil, fmt.Errorf("bootstrap phase %q depends on unknown phase %q", phase.Name, dep)
+			}
+		}
+	}
+	for _, name := range append(append([]string{}, k.Phases...), k.SkipPhases...) {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown bootstrap phase %q (valid phases are %s)", name, strings.Join(phaseNames(pipeline), ", "))
+		}
+	}
+
+	var planned []Phase
+	for _, phase := range pipeline {
+		if !phase.runsOn(role) || contains(k.SkipPhases, phase.Name) {
+			continue
+		}
+		if len(k.Phases) > 0 && !contains(k.Phases, phase.Name) {
+			continue
+		}
+		planned = append(planned, phase)
+	}
+	return orderPhases(planned)
+}
+
+// runPipeline runs all the phases planned for a role
+func (k *Config) runPipeline(role Role, state *bootstrapState) error {
+	phases, err := k.Plan(role)
+	if err != nil {
+		return err
+	}
+	if k.DryRun != nil {
+		k.DryRun.Plan(string(role), phaseNames(phases))
+	}
+	for _, phase := range phases {
+		if role == RolePrimary && phase.Checkpoint {
+			err = k.runCheckpointedPhase(role, phase, state)
+		} else {
+			err = runPhase(role, phase, state)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCheckpointedPhase runs a phase unless a checkpoint shows it has already completed
+// A local phase is run whatever its checkpoint, checking its work is still done on this host.
+func (k *Config) runCheckpointedPhase(role Role, phase Phase, state *bootstrapState) (err error) {
+	key, err := checkpointKey(phase)
+	if err != nil {
+		return err
+	}
+	cp, err := k.getCheckpoint(key)
+	if err == nil {
+		if phase.Local {
+			log.Printf("Checking bootstrap phase %q completed on this host by %q at %v", phase.Name, cp.CompletedBy, cp.Completed)
+			return runPhase(role, phase, state)
+		}
+		log.Printf("Skipping bootstrap phase %q completed by %q at %v", phase.Name, cp.CompletedBy, cp.Completed)
+		return nil
+	}
+	if err != etcd.ErrKeyMissing {
+		return err
+	}
+	if err = runPhase(role, phase, state); err != nil {
+		return err
+	}
+	return k.recordCheckpoint(key, "")
+}
+
+// runPhase runs a phase, retrying with back off as set by its retry policy
+func runPhase(role Role, phase Phase, state *bootstrapState) (err error) {
+	log.Printf("Running bootstrap phase %q...", phase.Name)
+	b := newBackOff(phase.Retry.Delay)
+	for attempt := 1; ; attempt++ {
+		if err = phase.Run(role, state); err == nil {
+			return nil
+		}
+		if attempt >= phase.Retry.Attempts {
+			return fmt.Errorf("bootstrap phase %q failed: %v", phase.Name, err)
+		}
+		delay := b.next()
+		log.Printf("Bootstrap phase %q failed (attempt %d of %d), retrying in %v: %v",
+			phase.Name, attempt, phase.Retry.Attempts, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// orderPhases sorts phases so each runs after its dependencies (otherwise keeping their order)
+// Dependencies which aren't planned are ignored.
+func orderPhases(phases []Phase) ([]Phase, error) {
+	planned := map[string]bool{}
+	for _, phase := range phases {
+		planned[phase.Name] = true
+	}
+	done := map[string]bool{}
+	var ordered []Phase
+	for len(ordered) < len(phases) {
+		progress := false
+		for _, phase := range phases {
+			if done[phase.Name] || !depsDone(phase, planned, done) {
+				continue
+			}
+			ordered = append(ordered, phase)
+			done[phase.Name] = true
+			progress = true
+		}
+		if !progress {
+			var remaining []string
+			for _, phase := range phases {
+				if !done[phase.Name] {
+					remaining = append(remaining, phase.Name)
+				}
+			}
+			return nil, fmt.Errorf("bootstrap phases have a dependency cycle: %s", strings.Join(remaining, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+func depsDone(phase Phase, planned, done map[string]bool) bool {
+	for _, dep := range phase.DependsOn {
+		if planned[dep] && !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Phase) runsOn(role Role) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func phaseNames(phases []Phase) (names []string) {
+	for _, phase := range phases {
+		names = append(names, phase.Name)
+	}
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package kmm
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/envelope"
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm"
+)
+
+// assetAckPrefix is the prefix of the keys recording the shared assets revision in use by each master
+const assetAckPrefix string = "kmm-asset-ack/"
+
+// assetReloadLockKey is held by the master restarting its control plane to reload the shared assets
+// so the masters restart one at a time
+const assetReloadLockKey string = "kmm-asset-reload-lock"
+
+// RotateSharedAssets will publish new service account and front proxy keys as a new shared assets
+// revision for all masters to pick up. The previous keys are still trusted until RetireSharedAssets.
+func (k *Config) RotateSharedAssets() (err error) {
+	return k.updateSharedAssets(func(assets string) (string, error) {
+		return k.Kubeadm.RotateAssets(assets)
+	})
+}
+
+// RetireSharedAssets will stop trusting the keys replaced by RotateSharedAssets
+// Refuses until every master has acknowledged the rotated keys
+func (k *Config) RetireSharedAssets() (err error) {
+	return k.updateSharedAssets(func(assets string) (string, error) {
+		revision, rotating, err := kubeadm.AssetsRevision(assets)
+		if err != nil {
+			return "", err
+		}
+		if !rotating {
+			return "", fmt.Errorf("no shared assets rotation in progress, nothing to retire")
+		}
+		if err = k.checkAcknowledged(revision); err != nil {
+			return "", err
+		}
+		return k.Kubeadm.RetireAssets(assets)
+	})
+}
+
+// SyncSharedAssets will reload the shared assets on this master if a newer revision is in etcd
+// Only one master reloads at a time (until its API server is healthy again), the others
+// return without reloading and try again when next synced.
+// Returns true when a new revision was reloaded (and acknowledged)
+func (k *Config) SyncSharedAssets() (reloaded bool, err error) {
+	assets, err := k.getSharedAssets()
+	if err != nil {
+		return false, err
+	}
+	revision, _, err := kubeadm.AssetsRevision(assets)
+	if err != nil {
+		return false, err
+	}
+	hostName, err := os.Hostname()
+	if err != nil {
+		return false, err
+	}
+	acked, err := k.Etcd.Get(assetAckPrefix + hostName)
+	if err == nil {
+		if ackedRevision, err := strconv.Atoi(acked); err == nil && ackedRevision >= revision {
+			return false, nil
+		}
+	} else if err != etcd.ErrKeyMissing {
+		return false, err
+	}
+	mylock, err := k.Etcd.GetOrCreateLock(assetReloadLockKey, defaultLockTTL)
+	if err != nil {
+		return false, err
+	}
+	if !mylock {
+		if holder, err := k.Etcd.GetLockHolder(assetReloadLockKey); err == nil {
+			log.Printf("Shared assets revision %d waiting for another master to reload (%s)", revision, holder)
+		}
+		return false, nil
+	}
+	defer k.Etcd.ReleaseLock(assetReloadLockKey)
+
+	log.Printf("Reloading shared assets revision %d...", revision)
+	if err = k.Kubeadm.ReloadAssets(assets); err != nil {
+		return false, err
+	}
+	return true, k.acknowledgeAssets(assets)
+}
+
+// updateSharedAssets will replace the shared assets in etcd (under the asset lock)
+func (k *Config) updateSharedAssets(update func(assets string) (string, error)) (err error) {
+	mylock, err := k.Etcd.GetOrCreateLock(assetLockKey, defaultLockTTL)
+	if err != nil {
+		return err
+	}
+	if !mylock {
+		if holder, err := k.Etcd.GetLockHolder(assetLockKey); err == nil {
+			return fmt.Errorf("lock %q held by %s, try again later", assetLockKey, holder)
+		}
+		return fmt.Errorf("lock %q held by another master, try again later", assetLockKey)
+	}
+	defer k.Etcd.ReleaseLock(assetLockKey)
+
+	// Already holding the asset lock so any unencrypted assets are encrypted when replaced below
+	value, err := k.Etcd.Get(assetKey)
+	if err != nil {
+		return err
+	}
+	assets, err := k.openSharedAssets(value)
+	if err == envelope.ErrNotSealed {
+		assets, err = value, nil
+	}
+	if err != nil {
+		return err
+	}
+	if assets, err = update(assets); err != nil {
+		return err
+	}
+	if assets, err = envelope.Seal(k.AssetKeyProvider, []byte(assets)); err != nil {
+		return err
+	}
+	// Only written while still holding the lock (checked in the same transaction)
+	if err = k.Etcd.PutLocked(assetKey, assets, assetLockKey); err != nil {
+		if err == etcd.ErrLockChanged {
+			return fmt.Errorf("lost lock %q whilst updating assets, not sharing assets", assetLockKey)
+		}
+		return err
+	}
+	log.Printf("Updated assets shared to etcd")
+	return nil
+}
+
+// getSharedAssets returns the decrypted shared assets from etcd
+func (k *Config) getSharedAssets() (assets string, err error) {
+	value, err := k.Etcd.Get(assetKey)
+	if err != nil {
+		return "", err
+	}
+	return k.openStoredAssets(value)
+}
+
+// openStoredAssets decrypts the shared assets stored in etcd
+// Assets shared unencrypted by an older kmm are accepted once and encrypted in etcd (see sealLegacyAssets).
+func (k *Config) openStoredAssets(value string) (assets string, err error) {
+	if assets, err = k.openSharedAssets(value); err != envelope.ErrNotSealed {
+		return assets, err
+	}
+	if err = k.sealLegacyAssets(value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// openSharedAssets decrypts shared assets, returning envelope.ErrNotSealed for any not encrypted
+func (k *Config) openSharedAssets(sealed string) (assets string, err error) {
+	plainAssets, err := envelope.Open(k.AssetKeyProvider, sealed)
+	if err == envelope.ErrNotSealed {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt shared assets from etcd: %v", err)
+	}
+	return string(plainAssets), nil
+}
+
+// sealLegacyAssets will encrypt the shared assets in etcd left unencrypted by an older kmm (under the asset lock)
+// When another master holds the lock the assets are left for it (or the next master) to encrypt.
+func (k *Config) sealLegacyAssets(plainAssets string) (err error) {
+	// Only ever accept something which is shared assets (of any version)
+	if _, _, err = kubeadm.AssetsRevision(plainAssets); err != nil {
+		return fmt.Errorf("shared assets in etcd are not encrypted and can't be parsed, refusing to use them")
+	}
+	log.Printf("Shared assets in etcd are not encrypted (shared by an older kmm), encrypting them...")
+	mylock, err := k.Etcd.GetOrCreateLock(assetLockKey, defaultLockTTL)
+	if err != nil {
+		return err
+	}
+	if !mylock {
+		log.Printf("Lock %q held by another master, leaving the shared assets for it to encrypt", assetLockKey)
+		return nil
+	}
+	defer k.Etcd.ReleaseLock(assetLockKey)
+
+	// Another master may have encrypted (or replaced) them since they were read
+	current, err := k.Etcd.Get(assetKey)
+	if err != nil {
+		return err
+	}
+	if current != plainAssets {
+		return nil
+	}
+	sealed, err := envelope.Seal(k.AssetKeyProvider, []byte(plainAssets))
+	if err != nil {
+		return err
+	}
+	if err = k.Etcd.PutLocked(assetKey, sealed, assetLockKey); err != nil {
+		return err
+	}
+	log.Printf("Encrypted the shared assets in etcd")
+	return nil
+}
+
+// acknowledgeAssets records the shared assets revision now in use on this master
+func (k *Config) acknowledgeAssets(assets string) (err error) {
+	revision, _, err := kubeadm.AssetsRevision(assets)
+	if err != nil {
+		return err
+	}
+	hostName, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	return k.Etcd.Put(assetAckPrefix+hostName, strconv.Itoa(revision))
+}
+
+// checkAcknowledged returns an error unless every master has acknowledged revision
+func (k *Config) checkAcknowledged(revision int) (err error) {
+	acks, err := k.Etcd.GetPrefix(assetAckPrefix)
+	if err != nil {
+		return err
+	}
+	acked := 0
+	pending := []string{}
+	for key, value := range acks {
+		if ackedRevision, err := strconv.Atoi(value); err == nil && ackedRevision >= revision {
+			acked++
+			continue
+		}
+		pending = append(pending, strings.TrimPrefix(key, assetAckPrefix))
+	}
+	sort.Strings(pending)
+	var masterCount uint
+	if k.KubeadmCfg != nil {
+		masterCount = k.KubeadmCfg.MasterCount
+	}
+	if acked == 0 || uint(acked) < masterCount {
+		return fmt.Errorf("only %d of %d masters have acknowledged shared assets revision %d (waiting for: %s)",
+			acked, masterCount, revision, strings.Join(pending, ", "))
+	}
+	return nil
+}
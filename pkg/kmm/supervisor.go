@@ -0,0 +1,104 @@
+package kmm
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DefaultReconcilePeriod is how often a supervised node is checked when nothing has changed
+const DefaultReconcilePeriod time.Duration = 5 * time.Minute
+
+// supervise will reconcile every period (and whenever changed signals) until stop is closed
+// Errors reconciling are logged and retried at the next period.
+func supervise(stop <-chan struct{}, period time.Duration, changed <-chan struct{}, reconcile func() error) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+		if err := reconcile(); err != nil {
+			log.Printf("Error reconciling: %v", err)
+		}
+	}
+}
+
+// stopOnSignal returns a channel closed when SIGTERM or SIGINT is received
+func stopOnSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down...", sig)
+		signal.Stop(signals)
+		close(stop)
+	}()
+	return stop
+}
+
+// anyChanged signals whenever any of the changed channels signal until stop is closed
+func anyChanged(stop <-chan struct{}, changed ...<-chan struct{}) <-chan struct{} {
+	merged := make(chan struct{}, 1)
+	for _, c := range changed {
+		go func(c <-chan struct{}) {
+			for {
+				select {
+				case <-stop:
+					return
+				case <-c:
+				}
+				// A pending signal covers any number of changes
+				select {
+				case merged <- struct{}{}:
+				default:
+				}
+			}
+		}(c)
+	}
+	return merged
+}
+
+// superviseMaster keeps a bootstrapped master running as configured until stopped
+// Reconciles when the shared assets change in etcd (or another master has finished reloading them)
+func (k *Config) superviseMaster(stop <-chan struct{}) {
+	log.Printf("Supervising master, reconciling every %v...", k.ReconcilePeriod)
+	changed := anyChanged(stop, k.Etcd.WatchKey(assetKey, stop), k.Etcd.WatchKey(assetReloadLockKey, stop))
+	supervise(stop, k.ReconcilePeriod, changed, k.reconcileMaster)
+	log.Printf("Stopped supervising master")
+}
+
+// reconcileMaster picks up any new shared assets, restores the kubelet and manifests and completes
+// any network request
+func (k *Config) reconcileMaster() error {
+	if _, err := k.SyncSharedAssets(); err != nil {
+		return err
+	}
+	if err := k.Kmm.CreateAndStartKubelet(true); err != nil {
+		return err
+	}
+	if _, err := k.Kubeadm.ReconcileManifests(); err != nil {
+		return err
+	}
+	return k.Kmm.ReconcileNetwork()
+}
+
+// superviseCompute keeps a bootstrapped compute node running as configured until stopped
+// (and completes any network request)
+func (k *Config) superviseCompute(stop <-chan struct{}) {
+	log.Printf("Supervising compute, reconciling every %v...", k.ReconcilePeriod)
+	supervise(stop, k.ReconcilePeriod, nil, func() error {
+		if err := k.Kmm.CreateAndStartKubelet(false); err != nil {
+			return err
+		}
+		return k.Kmm.ReconcileNetwork()
+	})
+	log.Printf("Stopped supervising compute")
+}
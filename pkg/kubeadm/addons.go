@@ -4,6 +4,11 @@ import (
 	"fmt"
 	"path"
 
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/dryrun"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 	kubemaster "k8s.io/kubernetes/cmd/kubeadm/app/master"
@@ -12,6 +17,12 @@ import (
 	"k8s.io/kubernetes/pkg/util/version"
 )
 
+// The names of the essential addons created by kubeadm
+const (
+	kubeProxyAddon = "kube-proxy"
+	kubeDNSAddon   = "kube-dns"
+)
+
 // Addons - deploys the essential addons
 func (k *Config) Addons() error {
 
@@ -19,6 +30,13 @@ func (k *Config) Addons() error {
 	if err != nil {
 		return fmt.Errorf("couldn't parse kubernetes version %q: %v", k.KubeVersion, err)
 	}
+	if k.DryRun != nil {
+		k.DryRun.Note(dryrun.KindResource, "master role labels and taints", dryrun.ActionApply, "")
+		k.DryRun.Note(dryrun.KindResource, "kubeadm service accounts and RBAC rules", dryrun.ActionApply, "")
+		k.DryRun.Note(dryrun.KindResource, "essential addons ("+kubeProxyAddon+", "+kubeDNSAddon+") unless installed",
+			dryrun.ActionApply, "")
+		return nil
+	}
 
 	adminKubeConfigPath := path.Join(kubeadmapi.GlobalEnvParams.KubernetesDir, kubeadmconstants.AdminKubeConfigFileName)
 	client, err := kubemaster.CreateClientAndWaitForAPI(adminKubeConfigPath)
@@ -48,8 +66,33 @@ func (k *Config) Addons() error {
 		return err
 	}
 
+	installed, err := addonsInstalled(client)
+	if err != nil {
+		return err
+	}
+	if installed {
+		log.Printf("Essential addons already installed")
+		return nil
+	}
 	if err := addonsphase.CreateEssentialAddons(kubeadmapiCfg, client); err != nil {
 		return err
 	}
 	return nil
 }
+
+// addonsInstalled checks if the essential addons have already been created
+func addonsInstalled(client *clientset.Clientset) (bool, error) {
+	if _, err := client.ExtensionsV1beta1().DaemonSets(metav1.NamespaceSystem).Get(kubeProxyAddon, metav1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := client.ExtensionsV1beta1().Deployments(metav1.NamespaceSystem).Get(kubeDNSAddon, metav1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
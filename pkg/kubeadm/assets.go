@@ -0,0 +1,411 @@
+package kubeadm
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+)
+
+// SharedAssetsVersion is the current schema version of the serialized SharedAssets
+// Version 0 is the original unversioned format (see legacySharedAssets)
+const SharedAssetsVersion = 1
+
+// SharedAssets - the data to be shared between all kubernetes masters
+type SharedAssets struct {
+	Version   int                    `json:"version"`
+	Created   time.Time              `json:"created"`
+	CreatedBy string                 `json:"createdBy"`
+	Files     map[string]SharedAsset `json:"files"`
+	// Revision is incremented each time the shared assets are changed (e.g. rotated)
+	Revision int `json:"revision,omitempty"`
+	// Rotation is set whilst previous keys are still trusted (see RotateAssets)
+	Rotation *AssetRotation `json:"rotation,omitempty"`
+}
+
+// SharedAsset - a single file shared between all kubernetes masters
+type SharedAsset struct {
+	Content string `json:"content"`
+	SHA256  string `json:"sha256"`
+}
+
+// AssetValidator checks the content of a shared asset
+// All the shared files are provided to allow checking e.g. key pairs
+type AssetValidator func(content string, files map[string]SharedAsset) error
+
+// AssetDescriptor describes a file to share between all kubernetes masters
+type AssetDescriptor struct {
+	// Name identifies the file within the SharedAssets
+	Name      string
+	Path      string
+	Mode      os.FileMode
+	Required  bool
+	Validator AssetValidator
+}
+
+// AssetValidators - named validators which can be used by shared assets declared in config
+var AssetValidators = map[string]AssetValidator{
+	"none":       func(string, map[string]SharedAsset) error { return nil },
+	"cert":       validateCerts,
+	"ca-cert":    validateCaCert,
+	"key":        validateKey,
+	"public-key": validatePublicKey,
+}
+
+// DefaultSharedAssets - the files always shared between all kubernetes masters
+var DefaultSharedAssets = []AssetDescriptor{
+	{
+		Name:      kubeadmconstants.ServiceAccountPublicKeyName,
+		Path:      path.Join(PkiDir, kubeadmconstants.ServiceAccountPublicKeyName),
+		Mode:      0644,
+		Required:  true,
+		Validator: pairedWith(validatePublicKey, kubeadmconstants.ServiceAccountPrivateKeyName),
+	},
+	{
+		Name:      kubeadmconstants.ServiceAccountPrivateKeyName,
+		Path:      path.Join(PkiDir, kubeadmconstants.ServiceAccountPrivateKeyName),
+		Mode:      0600,
+		Required:  true,
+		Validator: validateKey,
+	},
+	{
+		Name:      kubeadmconstants.FrontProxyCACertName,
+		Path:      path.Join(PkiDir, kubeadmconstants.FrontProxyCACertName),
+		Mode:      0644,
+		Required:  true,
+		Validator: pairedWith(validateCaCert, kubeadmconstants.FrontProxyCAKeyName),
+	},
+	{
+		Name:      kubeadmconstants.FrontProxyCAKeyName,
+		Path:      path.Join(PkiDir, kubeadmconstants.FrontProxyCAKeyName),
+		Mode:      0600,
+		Required:  true,
+		Validator: validateKey,
+	},
+}
+
+// legacySharedAssets - the unversioned (version 0) shared assets format
+type legacySharedAssets struct {
+	FrontProxyCa    string
+	FrontProxyCaKey string
+	SaPub           string
+	SaKey           string
+}
+
+// SharedAssetDescriptors - the default shared assets and any declared in config
+func (k *Config) SharedAssetDescriptors() []AssetDescriptor {
+	return append(append([]AssetDescriptor{}, DefaultSharedAssets...), k.ExtraSharedAssets...)
+}
+
+// ParseAssetDescriptors will parse shared assets declared as a comma separated list of:
+// path[:mode[:required|optional[:validator]]]
+// e.g. /etc/kubernetes/audit-policy.yaml:0600:optional
+// The default is a mode of 0600, required and no validation.
+func ParseAssetDescriptors(declared string) ([]AssetDescriptor, error) {
+	descriptors := []AssetDescriptor{}
+	for _, item := range strings.Split(declared, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) > 4 {
+			return nil, fmt.Errorf("invalid shared asset %q, expecting path[:mode[:required|optional[:validator]]]", item)
+		}
+		if !path.IsAbs(fields[0]) {
+			return nil, fmt.Errorf("invalid shared asset %q, path must be absolute", item)
+		}
+		d := AssetDescriptor{
+			Name:      path.Clean(fields[0]),
+			Path:      path.Clean(fields[0]),
+			Mode:      0600,
+			Required:  true,
+			Validator: AssetValidators["none"],
+		}
+		if len(fields) > 1 && len(fields[1]) > 0 {
+			mode, err := strconv.ParseUint(fields[1], 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mode for shared asset %q [%v]", item, err)
+			}
+			d.Mode = os.FileMode(mode)
+		}
+		if len(fields) > 2 && len(fields[2]) > 0 {
+			switch fields[2] {
+			case "required":
+				d.Required = true
+			case "optional":
+				d.Required = false
+			default:
+				return nil, fmt.Errorf("invalid shared asset %q, expecting required or optional", item)
+			}
+		}
+		if len(fields) > 3 && len(fields[3]) > 0 {
+			validator, ok := AssetValidators[fields[3]]
+			if !ok {
+				return nil, fmt.Errorf("invalid validator for shared asset %q. Must be one of: %s",
+					item, strings.Join(assetValidatorNames(), ", "))
+			}
+			d.Validator = validator
+		}
+		descriptors = append(descriptors, d)
+	}
+	return descriptors, nil
+}
+
+// loadSharedAssets reads all described files from disk
+func loadSharedAssets(fs fileutil.Filesystem, descriptors []AssetDescriptor) (*SharedAssets, error) {
+	files := make(map[string]string)
+	for _, d := range descriptors {
+		content, err := readFileIfExists(fs, d.Path)
+		if err != nil {
+			return nil, fmt.Errorf("shared asset %q could not be loaded [%v]", d.Path, err)
+		}
+		if content == nil {
+			if d.Required {
+				return nil, fmt.Errorf("required shared asset %q is missing", d.Path)
+			}
+			log.Printf("Optional shared asset %q not present, not sharing", d.Path)
+			continue
+		}
+		files[d.Name] = string(content)
+	}
+	return newSharedAssets(files)
+}
+
+// newSharedAssets creates the current version of SharedAssets from file contents
+func newSharedAssets(files map[string]string) (*SharedAssets, error) {
+	createdBy, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	sharedAssets := &SharedAssets{
+		Version:   SharedAssetsVersion,
+		Created:   time.Now().UTC(),
+		CreatedBy: createdBy,
+		Files:     make(map[string]SharedAsset),
+	}
+	for name, content := range files {
+		sharedAssets.Files[name] = SharedAsset{
+			Content: content,
+			SHA256:  checksum(content),
+		}
+	}
+	return sharedAssets, nil
+}
+
+// parseSharedAssets will de-serialize and validate shared assets of any supported version
+func parseSharedAssets(assets string, descriptors []AssetDescriptor) (*SharedAssets, error) {
+	if len(assets) == 0 {
+		return nil, fmt.Errorf("shared assets are empty")
+	}
+	versioned := struct {
+		Version *int `json:"version"`
+	}{}
+	if err := json.Unmarshal([]byte(assets), &versioned); err != nil {
+		return nil, fmt.Errorf("shared assets could not be parsed [%v]", err)
+	}
+	var sharedAssets *SharedAssets
+	switch {
+	case versioned.Version == nil:
+		legacy := legacySharedAssets{}
+		if err := json.Unmarshal([]byte(assets), &legacy); err != nil {
+			return nil, fmt.Errorf("version 0 shared assets could not be parsed [%v]", err)
+		}
+		sharedAssets = legacy.upgrade()
+	case *versioned.Version > SharedAssetsVersion:
+		return nil, fmt.Errorf("shared assets version %d is newer than supported version %d",
+			*versioned.Version, SharedAssetsVersion)
+	default:
+		sharedAssets = &SharedAssets{}
+		if err := json.Unmarshal([]byte(assets), sharedAssets); err != nil {
+			return nil, fmt.Errorf("version %d shared assets could not be parsed [%v]", *versioned.Version, err)
+		}
+		if sharedAssets.Created.IsZero() {
+			return nil, fmt.Errorf("shared assets missing creation time")
+		}
+		if len(sharedAssets.CreatedBy) == 0 {
+			return nil, fmt.Errorf("shared assets missing creator")
+		}
+	}
+	if err := sharedAssets.validate(descriptors); err != nil {
+		return nil, fmt.Errorf("invalid shared assets created by %q at %v: %v",
+			sharedAssets.CreatedBy, sharedAssets.Created, err)
+	}
+	return sharedAssets, nil
+}
+
+// upgrade converts version 0 shared assets to the current format
+func (l legacySharedAssets) upgrade() *SharedAssets {
+	sharedAssets := &SharedAssets{Files: make(map[string]SharedAsset)}
+	for name, content := range map[string]string{
+		kubeadmconstants.ServiceAccountPublicKeyName:  l.SaPub,
+		kubeadmconstants.ServiceAccountPrivateKeyName: l.SaKey,
+		kubeadmconstants.FrontProxyCACertName:         l.FrontProxyCa,
+		kubeadmconstants.FrontProxyCAKeyName:          l.FrontProxyCaKey,
+	} {
+		if len(content) == 0 {
+			continue
+		}
+		// Version 0 had no checksums so trust the content
+		sharedAssets.Files[name] = SharedAsset{Content: content, SHA256: checksum(content)}
+	}
+	return sharedAssets
+}
+
+// validate checks all described files are present (when required), unmodified and valid
+func (s *SharedAssets) validate(descriptors []AssetDescriptor) error {
+	for name, file := range s.Files {
+		if checksum(file.Content) != file.SHA256 {
+			return fmt.Errorf("checksum mismatch for %q", name)
+		}
+	}
+	described := make(map[string]bool)
+	for _, d := range descriptors {
+		described[d.Name] = true
+		file, ok := s.Files[d.Name]
+		if !ok || len(file.Content) == 0 {
+			if d.Required {
+				return fmt.Errorf("missing %q", d.Name)
+			}
+			continue
+		}
+		if d.Validator == nil {
+			continue
+		}
+		if err := d.Validator(file.Content, s.Files); err != nil {
+			return fmt.Errorf("%q is not valid: %v", d.Name, err)
+		}
+	}
+	for name := range s.Files {
+		if !described[name] {
+			log.Printf("Shared asset %q not declared on this master, ignoring", name)
+		}
+	}
+	return nil
+}
+
+// validateCerts checks content contains PEM certificates which are valid now
+func validateCerts(content string, files map[string]SharedAsset) error {
+	certs, err := certutil.ParseCertsPEM([]byte(content))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, cert := range certs {
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("the certificate %q is not valid yet", cert.Subject.CommonName)
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("the certificate %q has expired", cert.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// validateCaCert checks content starts with a valid CA certificate
+func validateCaCert(content string, files map[string]SharedAsset) error {
+	if err := validateCerts(content, files); err != nil {
+		return err
+	}
+	certs, _ := certutil.ParseCertsPEM([]byte(content))
+	if !certs[0].IsCA {
+		return fmt.Errorf("the certificate is not a CA")
+	}
+	return nil
+}
+
+// validateKey checks content is a PEM private key
+func validateKey(content string, files map[string]SharedAsset) error {
+	_, err := certutil.ParsePrivateKeyPEM([]byte(content))
+	return err
+}
+
+// validatePublicKey checks content is a PEM public key
+func validatePublicKey(content string, files map[string]SharedAsset) error {
+	_, err := parsePublicKeyPEM([]byte(content))
+	return err
+}
+
+// pairedWith validates content and then checks its public key matches the private key in the named file
+func pairedWith(validator AssetValidator, keyName string) AssetValidator {
+	return func(content string, files map[string]SharedAsset) error {
+		if err := validator(content, files); err != nil {
+			return err
+		}
+		key, err := certutil.ParsePrivateKeyPEM([]byte(files[keyName].Content))
+		if err != nil {
+			return fmt.Errorf("private key %q could not be parsed [%v]", keyName, err)
+		}
+		var pub interface{}
+		if certs, err := certutil.ParseCertsPEM([]byte(content)); err == nil {
+			pub = certs[0].PublicKey
+		} else if pub, err = parsePublicKeyPEM([]byte(content)); err != nil {
+			return err
+		}
+		if !keysMatch(pub, key) {
+			return fmt.Errorf("does not match private key %q", keyName)
+		}
+		return nil
+	}
+}
+
+func assetValidatorNames() []string {
+	names := []string{}
+	for name := range AssetValidators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readFileIfExists returns nil content when a file doesn't exist
+func readFileIfExists(fs fileutil.Filesystem, fileName string) ([]byte, error) {
+	content, err := fs.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// checksum returns the hex encoded sha256 of content
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// parsePublicKeyPEM returns the first public key found in PEM data
+func parsePublicKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// keysMatch returns true if pub is the public part of key
+func keysMatch(pub interface{}, key interface{}) bool {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		p, ok := pub.(*rsa.PublicKey)
+		return ok && p.N.Cmp(k.N) == 0 && p.E == k.E
+	case *ecdsa.PrivateKey:
+		p, ok := pub.(*ecdsa.PublicKey)
+		return ok && p.X.Cmp(k.X) == 0 && p.Y.Cmp(k.Y) == 0
+	}
+	return false
+}
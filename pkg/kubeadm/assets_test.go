@@ -0,0 +1,230 @@
+package kubeadm
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+)
+
+func TestParseSharedAssets(t *testing.T) {
+	files := getTestAssetFiles(t)
+
+	// Current version
+	sharedAssets, err := newSharedAssets(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseSharedAssets(marshalTestAssets(t, sharedAssets), DefaultSharedAssets)
+	if err != nil {
+		t.Fatalf("failed parseSharedAssets with an error: %v", err)
+	}
+	if parsed.Version != SharedAssetsVersion {
+		t.Errorf("expected version %d but got %d", SharedAssetsVersion, parsed.Version)
+	}
+	if parsed.Files[kubeadmconstants.ServiceAccountPrivateKeyName].Content != files[kubeadmconstants.ServiceAccountPrivateKeyName] {
+		t.Errorf("expected service account key to be parsed")
+	}
+
+	// Version 0 (unversioned) format
+	legacy := legacySharedAssets{
+		SaPub:           files[kubeadmconstants.ServiceAccountPublicKeyName],
+		SaKey:           files[kubeadmconstants.ServiceAccountPrivateKeyName],
+		FrontProxyCa:    files[kubeadmconstants.FrontProxyCACertName],
+		FrontProxyCaKey: files[kubeadmconstants.FrontProxyCAKeyName],
+	}
+	if _, err := parseSharedAssets(marshalTestAssets(t, legacy), DefaultSharedAssets); err != nil {
+		t.Errorf("failed parseSharedAssets for version 0 with an error: %v", err)
+	}
+}
+
+func TestParseSharedAssetsInvalid(t *testing.T) {
+	files := getTestAssetFiles(t)
+	otherFiles := getTestAssetFiles(t)
+
+	var tests = []struct {
+		name   string
+		modify func(s *SharedAssets)
+	}{
+		{
+			name: "newer version",
+			modify: func(s *SharedAssets) {
+				s.Version = SharedAssetsVersion + 1
+			},
+		},
+		{
+			name: "missing creator",
+			modify: func(s *SharedAssets) {
+				s.CreatedBy = ""
+			},
+		},
+		{
+			name: "missing file",
+			modify: func(s *SharedAssets) {
+				delete(s.Files, kubeadmconstants.FrontProxyCAKeyName)
+			},
+		},
+		{
+			name: "checksum mismatch",
+			modify: func(s *SharedAssets) {
+				f := s.Files[kubeadmconstants.ServiceAccountPrivateKeyName]
+				f.Content = otherFiles[kubeadmconstants.ServiceAccountPrivateKeyName]
+				s.Files[kubeadmconstants.ServiceAccountPrivateKeyName] = f
+			},
+		},
+		{
+			name: "service account keys not paired",
+			modify: func(s *SharedAssets) {
+				content := otherFiles[kubeadmconstants.ServiceAccountPrivateKeyName]
+				s.Files[kubeadmconstants.ServiceAccountPrivateKeyName] = SharedAsset{Content: content, SHA256: checksum(content)}
+			},
+		},
+		{
+			name: "front proxy ca not paired",
+			modify: func(s *SharedAssets) {
+				content := otherFiles[kubeadmconstants.FrontProxyCAKeyName]
+				s.Files[kubeadmconstants.FrontProxyCAKeyName] = SharedAsset{Content: content, SHA256: checksum(content)}
+			},
+		},
+		{
+			name: "front proxy ca not a CA",
+			modify: func(s *SharedAssets) {
+				caCert, caKey, _ := pkiutil.NewCertificateAuthority(pkiutil.DefaultKeyType)
+				cert, key, _ := pkiutil.NewCertAndKey(caCert, caKey, certutil.Config{
+					CommonName: "notaca",
+					Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				}, pkiutil.DefaultKeyType)
+				certContent := string(certutil.EncodeCertPEM(cert))
+				keyPEM, _ := certutil.MarshalPrivateKeyToPEM(key)
+				keyContent := string(keyPEM)
+				s.Files[kubeadmconstants.FrontProxyCACertName] = SharedAsset{Content: certContent, SHA256: checksum(certContent)}
+				s.Files[kubeadmconstants.FrontProxyCAKeyName] = SharedAsset{Content: keyContent, SHA256: checksum(keyContent)}
+			},
+		},
+	}
+	for _, rt := range tests {
+		sharedAssets, err := newSharedAssets(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt.modify(sharedAssets)
+		if _, err := parseSharedAssets(marshalTestAssets(t, sharedAssets), DefaultSharedAssets); err == nil {
+			t.Errorf("failed parseSharedAssets, expected an error for %s", rt.name)
+		}
+	}
+
+	for _, assets := range []string{"", "not json", "{}"} {
+		if _, err := parseSharedAssets(assets, DefaultSharedAssets); err == nil {
+			t.Errorf("failed parseSharedAssets, expected an error for %q", assets)
+		}
+	}
+}
+
+func getTestAssetFiles(t *testing.T) map[string]string {
+	saKey, err := certutil.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("Couldn't create rsa Private Key")
+	}
+	saPub, err := certutil.EncodePublicKeyPEM(&saKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(pkiutil.DefaultKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKeyPEM, err := certutil.MarshalPrivateKeyToPEM(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{
+		kubeadmconstants.ServiceAccountPublicKeyName:  string(saPub),
+		kubeadmconstants.ServiceAccountPrivateKeyName: string(certutil.EncodePrivateKeyPEM(saKey)),
+		kubeadmconstants.FrontProxyCACertName:         string(certutil.EncodeCertPEM(caCert)),
+		kubeadmconstants.FrontProxyCAKeyName:          string(caKeyPEM),
+	}
+}
+
+func marshalTestAssets(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func TestParseAssetDescriptors(t *testing.T) {
+	descriptors, err := ParseAssetDescriptors("/etc/kubernetes/audit.yaml, /etc/kubernetes/encryption.yaml:0640:optional,/etc/etcd/ca.pem:0644:required:ca-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descriptors) != 3 {
+		t.Fatalf("expected 3 descriptors but got %d", len(descriptors))
+	}
+	if d := descriptors[0]; d.Path != "/etc/kubernetes/audit.yaml" || d.Mode != 0600 || !d.Required {
+		t.Errorf("unexpected defaults for %q: mode %v required %v", d.Path, d.Mode, d.Required)
+	}
+	if d := descriptors[1]; d.Mode != 0640 || d.Required {
+		t.Errorf("unexpected values for %q: mode %v required %v", d.Path, d.Mode, d.Required)
+	}
+	if d := descriptors[2]; d.Validator("not a cert", nil) == nil {
+		t.Errorf("expected ca-cert validator for %q", d.Path)
+	}
+
+	for _, declared := range []string{
+		"relative/path",
+		"/etc/file:notamode",
+		"/etc/file:0600:sometimes",
+		"/etc/file:0600:required:unknown",
+		"/etc/file:0600:required:none:extra",
+	} {
+		if _, err := ParseAssetDescriptors(declared); err == nil {
+			t.Errorf("failed ParseAssetDescriptors, expected an error for %q", declared)
+		}
+	}
+}
+
+func TestLoadSharedAssetsExtra(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	required := path.Join(tmpdir, "audit.yaml")
+	optional := path.Join(tmpdir, "missing.yaml")
+	if err := ioutil.WriteFile(required, []byte("rules: []"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	descriptors, err := ParseAssetDescriptors(required + "," + optional + ":0600:optional")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedAssets, err := loadSharedAssets(fileutil.OS, descriptors)
+	if err != nil {
+		t.Fatalf("failed loadSharedAssets with an error: %v", err)
+	}
+	if sharedAssets.Files[required].Content != "rules: []" {
+		t.Errorf("expected %q to be shared", required)
+	}
+	if _, ok := sharedAssets.Files[optional]; ok {
+		t.Errorf("expected missing optional %q not to be shared", optional)
+	}
+	if err := sharedAssets.validate(descriptors); err != nil {
+		t.Errorf("failed validate with an error: %v", err)
+	}
+
+	// Required file missing
+	os.Remove(required)
+	if _, err := loadSharedAssets(fileutil.OS, descriptors); err == nil {
+		t.Errorf("expected an error for missing required asset %q", required)
+	}
+}
@@ -1,11 +1,10 @@
 package kubeadm
 
 import (
-	"crypto/rsa"
+	"bytes"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
@@ -13,15 +12,17 @@ import (
 	"strconv"
 	"strings"
 
-	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"k8s.io/client-go/tools/clientcmd"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 
 	log "github.com/Sirupsen/logrus"
 
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
 	"github.com/UKHomeOffice/keto-k8/pkg/constants"
+	"github.com/UKHomeOffice/keto-k8/pkg/dryrun"
 	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
-	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
 )
 
 // TODO: Add mockable interface for testing this package without reference to the real kubeadm
@@ -35,6 +36,12 @@ var (
 	// PkiDir - The directory kubeadm will store all pki assets
 	PkiDir string = kubeadmconstants.KubernetesDir + "/pki"
 
+	// PkiBackupDir - The directory where shared assets are backed up before being replaced
+	PkiBackupDir string = kubeadmconstants.KubernetesDir + "/pki-backup"
+
+	// PkiBackupsKept - The number of shared asset backups to keep
+	PkiBackupsKept = 5
+
 	// CaCertFile the name of the Kube CA cert file (as used by kubeadm)
 	CaCertFile string = kubeadmconstants.KubernetesDir + "/pki" + "/" + kubeadmconstants.CACertAndKeyBaseName + ".crt"
 
@@ -56,14 +63,12 @@ type Config struct {
 	APIServerExtraArgs         map[string]string
 	ControllerManagerExtraArgs map[string]string
 	SchedulerExtraArgs         map[string]string
-}
+	ExtraSharedAssets          []AssetDescriptor
+	// DryRun records all changes instead of making them (when set)
+	DryRun *dryrun.Recorder
 
-// SharedAssets - the data to be shared between all kubernetes masters
-type SharedAssets struct {
-	FrontProxyCa    string
-	FrontProxyCaKey string
-	SaPub           string
-	SaKey           string
+	// manifestChecksums records the manifests last written (see ReconcileManifests)
+	manifestChecksums map[string]string
 }
 
 // Kubeadmer allows for mocking out this lib for testing
@@ -72,6 +77,10 @@ type Kubeadmer interface {
 	CreateKubeConfig() (err error)
 	CreatePKI() (err error)
 	LoadAndSerializeAssets() (assets string, err error)
+	ReconcileManifests() (rewritten bool, err error)
+	ReloadAssets(assets string) (err error)
+	RetireAssets(assets string) (retired string, err error)
+	RotateAssets(assets string) (rotated string, err error)
 	SaveAssets(assets string) (err error)
 	UpdateMasterRoleLabelsAndTaints() error
 	WriteManifests() (err error)
@@ -83,74 +92,70 @@ var _ Kubeadmer = (*Config)(nil)
 // LoadAndSerializeAssets getting assets off disk into a serialized string
 // Return an error if there are no assets (and empty string)
 func (k *Config) LoadAndSerializeAssets() (assets string, err error) {
-	assets = ""
-
-	var saPub *rsa.PublicKey
-	var saKey *rsa.PrivateKey
-	saKey, err = pkiutil.TryLoadKeyFromDisk(PkiDir, kubeadmconstants.ServiceAccountKeyBaseName)
-	if err != nil {
-		return "", fmt.Errorf("SA private key could not be loaded properly [%v]", err)
-	}
-	saPub, err = pkiutil.TryLoadPublicKeyFromDisk(PkiDir, kubeadmconstants.ServiceAccountKeyBaseName)
+	descriptors := k.SharedAssetDescriptors()
+	sharedAssets, err := loadSharedAssets(k.fs(), descriptors)
 	if err != nil {
-		return "", fmt.Errorf("SA public key could not be loaded properly [%v]", err)
-	}
-
-	var frontProxyCACert *x509.Certificate
-	var frontProxyCAKey *rsa.PrivateKey
-	frontProxyCACert, frontProxyCAKey, err = pkiutil.TryLoadCertAndKeyFromDisk(PkiDir, kubeadmconstants.FrontProxyCACertAndKeyBaseName)
-	if err != nil || frontProxyCACert == nil || frontProxyCAKey == nil {
-		return "", fmt.Errorf("Front proxy certificate and/or key existed but they could not be loaded properly")
+		return "", err
 	}
-
-	// The certificate and key could be loaded, but the certificate is not a CA
-	if !frontProxyCACert.IsCA {
-		return "", fmt.Errorf("certificate and key could be loaded but the certificate is not a CA")
-	}
-
-	saPubPemBytes, _ := certutil.EncodePublicKeyPEM(saPub)
-	// Re-encode the values now we've checked them...
-	sharedAssets := &SharedAssets{
-		SaPub:           string(saPubPemBytes[:]),
-		SaKey:           string(certutil.EncodePrivateKeyPEM(saKey)[:]),
-		FrontProxyCa:    string(certutil.EncodeCertPEM(frontProxyCACert)[:]),
-		FrontProxyCaKey: string(certutil.EncodePrivateKeyPEM(frontProxyCAKey)[:]),
+	// Check the values before sharing them...
+	if err = sharedAssets.validate(descriptors); err != nil {
+		return "", err
 	}
 
 	// Now json encode the structure
-	assetsBytes, _ := json.Marshal(sharedAssets)
-	assets = string(assetsBytes)
-
-	return assets, nil
+	assetsBytes, err := json.Marshal(sharedAssets)
+	if err != nil {
+		return "", err
+	}
+	return string(assetsBytes), nil
 }
 
-// SaveAssets - will persist assets to disk
+// SaveAssets - will validate and then persist assets to disk
+// Nothing is written unless all assets are valid
 func (k *Config) SaveAssets(assets string) (err error) {
-	pkiDir := PkiDir + "/"
-	sharedAssets := SharedAssets{}
-	json.Unmarshal([]byte(assets), &sharedAssets)
-
-	// Now save each of the pem files...
-	err = ioutil.WriteFile(pkiDir+kubeadmconstants.ServiceAccountPublicKeyName, []byte(sharedAssets.SaPub), 0644)
+	descriptors := k.SharedAssetDescriptors()
+	sharedAssets, err := parseSharedAssets(assets, descriptors)
 	if err != nil {
-		return fmt.Errorf("Service Account public key could not saved [%v]", err)
+		return err
 	}
-	err = ioutil.WriteFile(pkiDir+kubeadmconstants.ServiceAccountPrivateKeyName, []byte(sharedAssets.SaKey), 0600)
-	if err != nil {
-		return fmt.Errorf("Service Account private key could not saved [%v]", err)
+	log.Printf("Saving shared assets version %d created by %q at %v",
+		sharedAssets.Version, sharedAssets.CreatedBy, sharedAssets.Created)
+
+	// Now save all the files together (or none at all)...
+	files := []fileutil.AtomicFile{}
+	for _, d := range descriptors {
+		file, ok := sharedAssets.Files[d.Name]
+		if !ok {
+			continue
+		}
+		// Files already saved are left alone (so repeated saves don't create backups)
+		if current, err := k.fs().ReadFile(d.Path); err == nil && string(current) == file.Content {
+			continue
+		}
+		files = append(files, fileutil.AtomicFile{Path: d.Path, Content: []byte(file.Content), Mode: d.Mode})
 	}
-	err = ioutil.WriteFile(pkiDir+kubeadmconstants.FrontProxyCACertName, []byte(sharedAssets.FrontProxyCa), 0644)
-	if err != nil {
-		return fmt.Errorf("Front proxy public ca cert could not saved [%v]", err)
+	if len(files) == 0 {
+		log.Printf("Shared assets already saved")
+		return nil
 	}
-	err = ioutil.WriteFile(pkiDir+kubeadmconstants.FrontProxyCAKeyName, []byte(sharedAssets.FrontProxyCaKey), 0600)
-	if err != nil {
-		return fmt.Errorf("Front proxy private key could not saved [%v]", err)
+	if err = k.fs().WriteFilesAtomically(files, PkiBackupDir); err != nil {
+		return fmt.Errorf("Shared assets could not be saved [%v]", err)
+	}
+	if k.DryRun != nil {
+		return nil
+	}
+	if err = fileutil.PruneBackups(PkiBackupDir, PkiBackupsKept); err != nil {
+		log.Warnf("Unable to remove old shared asset backups from %q [%v]", PkiBackupDir, err)
 	}
-
 	return nil
 }
 
+// RollbackAssets - will restore the shared assets replaced by the last SaveAssets
+// Returns the name of the backup restored
+func RollbackAssets() (backup string, err error) {
+	return fileutil.RestoreLatestBackup(PkiBackupDir)
+}
+
 // CreatePKI - generates all PKI assests on to disk
 func (k *Config) CreatePKI() (err error) {
 	apiHost := ""
@@ -159,8 +164,22 @@ func (k *Config) CreatePKI() (err error) {
 	}
 	log.Printf("Using host:%q", apiHost)
 	args := append(cmdOptsCerts, apiHost)
+	if k.DryRun != nil {
+		// kubeadm only creates missing certs so is run against a copy of the pki
+		certDir, err := k.DryRun.Stage(PkiDir)
+		defer os.RemoveAll(certDir)
+		if err != nil {
+			return err
+		}
+		kubeadmOut, err := runKubeadm(*k, append(args, "--cert-dir", certDir))
+		log.Printf("Output:\n%s", kubeadmOut)
+		if err != nil {
+			return err
+		}
+		return k.DryRun.Collect(certDir, PkiDir)
+	}
 	kubeadmOut, err := runKubeadm(*k, args)
-	log.Printf("Output:\n" + kubeadmOut)
+	log.Printf("Output:\n%s", kubeadmOut)
 	return err
 }
 
@@ -235,6 +254,11 @@ func GetKubeadmCfg(kmmCfg Config) (cfg *kubeadmapi.MasterConfiguration, err erro
 
 // Run kubeadm to create a kubeconfig file...
 func createAKubeCfg(cfg Config, file string, cn string, org string) (err error) {
+	filePath := kubeadmconstants.KubernetesDir + "/" + file
+	if kubeCfgCurrent(cfg.fs(), filePath, cfg.APIServer.String(), CaCertFile) {
+		log.Printf("Using existing:%q", filePath)
+		return nil
+	}
 	args := append(cmdOptsKubeconfig,
 		"--client-name", cn,
 		"--server", cfg.APIServer.String())
@@ -243,17 +267,72 @@ func createAKubeCfg(cfg Config, file string, cn string, org string) (err error)
 		args = append(args,
 			"--organization", org)
 	}
+	if cfg.DryRun != nil {
+		// Use the pki as it would be
+		certDir, err := cfg.DryRun.Stage(PkiDir)
+		defer os.RemoveAll(certDir)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--cert-dir", certDir)
+	}
 
 	kubecfgContents, err := runKubeadm(cfg, args)
 	if err != nil {
 		return fmt.Errorf("Error running kubeadm:%s", kubecfgContents)
 	}
-	filePath := kubeadmconstants.KubernetesDir + "/" + file
 	log.Printf("Saving:%q", filePath)
-	err = ioutil.WriteFile(filePath, []byte(kubecfgContents), 0600)
+	err = cfg.fs().WriteFile(filePath, []byte(kubecfgContents), 0600)
 	return err
 }
 
+// kubeCfgCurrent checks if a kubeconfig file already exists for the server (so can be re-used)
+// It must also trust the current CA and have a client cert signed by it which hasn't expired (e.g. a
+// kubeconfig left from before the PKI was re-created is replaced).
+func kubeCfgCurrent(fs fileutil.Filesystem, filePath string, server string, caFile string) bool {
+	content, err := fs.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+	kubeCfg, err := clientcmd.Load(content)
+	if err != nil {
+		log.Printf("Existing kubeconfig %q can't be parsed [%v]", filePath, err)
+		return false
+	}
+	current := kubeCfg.Contexts[kubeCfg.CurrentContext]
+	if current == nil {
+		return false
+	}
+	cluster := kubeCfg.Clusters[current.Cluster]
+	user := kubeCfg.AuthInfos[current.AuthInfo]
+	if cluster == nil || user == nil || cluster.Server != server {
+		return false
+	}
+	caCert, err := fs.ReadFile(caFile)
+	if err != nil || !bytes.Equal(bytes.TrimSpace(caCert), bytes.TrimSpace(cluster.CertificateAuthorityData)) {
+		return false
+	}
+	clientCerts, err := certutil.ParseCertsPEM(user.ClientCertificateData)
+	if err != nil {
+		return false
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caCert)
+	_, err = clientCerts[0].Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// fs is where files are changed (or recorded on a dry run)
+func (k *Config) fs() fileutil.Filesystem {
+	if k.DryRun != nil {
+		return k.DryRun
+	}
+	return fileutil.OS
+}
+
 func runKubeadm(cfg Config, cmdArgs []string) (out string, err error) {
 	var cmdOut []byte
 
@@ -3,17 +3,22 @@ package kubeadm
 //go:generate mockery -dir $GOPATH/src/github.com/UKHomeOffice/keto-k8/pkg/kubeadm -name=Kubeadmer
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
 	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
 	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 const pkiPath = "/etc/kubernetes/pki"
@@ -89,6 +94,15 @@ func TestSaveAssets(t *testing.T) {
 	if err := k.SaveAssets(assets); err != nil {
 		t.Error(err)
 	}
+
+	// Saving the same assets again mustn't create another backup
+	backups, _ := ioutil.ReadDir(PkiBackupDir)
+	if err := k.SaveAssets(assets); err != nil {
+		t.Error(err)
+	}
+	if again, _ := ioutil.ReadDir(PkiBackupDir); len(again) != len(backups) {
+		t.Errorf("expected %d backups after saving unchanged assets but got %d", len(backups), len(again))
+	}
 }
 
 func getKubeadmCfg() (*Config, error) {
@@ -132,9 +146,9 @@ func createCa() (err error) {
 	// use cfssl for now as we use it internally for most CA's
 	var (
 		cert *x509.Certificate
-		key  *rsa.PrivateKey
+		key  crypto.Signer
 	)
-	if cert, key, err = pkiutil.NewCertificateAuthority(); err != nil {
+	if cert, key, err = pkiutil.NewCertificateAuthority(pkiutil.DefaultKeyType); err != nil {
 		return err
 	}
 	if err := pkiutil.WriteCertAndKey(pkiPath, pkiCaName, cert, key); err != nil {
@@ -142,3 +156,61 @@ func createCa() (err error) {
 	}
 	return nil
 }
+
+func TestKubeCfgCurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubecfg-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const server = "https://kube-api.example.com:443"
+	caFile := filepath.Join(dir, "ca.crt")
+	caCert, caKey, err := pkiutil.NewCertificateAuthority("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(caFile, certutil.EncodeCertPEM(caCert), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientCert, _, err := pkiutil.NewCertAndKey(caCert, caKey, certutil.Config{
+		CommonName: "kubernetes-admin",
+		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCaCert, _, err := pkiutil.NewCertificateAuthority("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKubeCfg := func(name, server string, caCert, clientCert *x509.Certificate) string {
+		kubeCfg := clientcmdapi.NewConfig()
+		kubeCfg.Clusters["kubernetes"] = &clientcmdapi.Cluster{
+			Server:                   server,
+			CertificateAuthorityData: certutil.EncodeCertPEM(caCert),
+		}
+		kubeCfg.AuthInfos["admin"] = &clientcmdapi.AuthInfo{ClientCertificateData: certutil.EncodeCertPEM(clientCert)}
+		kubeCfg.Contexts["admin@kubernetes"] = &clientcmdapi.Context{Cluster: "kubernetes", AuthInfo: "admin"}
+		kubeCfg.CurrentContext = "admin@kubernetes"
+		file := filepath.Join(dir, name)
+		if err := clientcmd.WriteToFile(*kubeCfg, file); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	if file := writeKubeCfg("current.conf", server, caCert, clientCert); !kubeCfgCurrent(fileutil.OS, file, server, caFile) {
+		t.Error("expected a kubeconfig for the server and CA to be current")
+	}
+	for name, file := range map[string]string{
+		"missing":        filepath.Join(dir, "missing.conf"),
+		"another server": writeKubeCfg("server.conf", "https://other.example.com:443", caCert, clientCert),
+		"another CA":     writeKubeCfg("ca.conf", server, otherCaCert, clientCert),
+		"unsigned cert":  writeKubeCfg("cert.conf", server, caCert, otherCaCert),
+	} {
+		if kubeCfgCurrent(fileutil.OS, file, server, caFile) {
+			t.Errorf("expected a kubeconfig with %s not to be current", name)
+		}
+	}
+}
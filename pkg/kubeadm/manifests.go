@@ -1,10 +1,55 @@
 package kubeadm
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/fileutil"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 	"k8s.io/kubernetes/cmd/kubeadm/app/master"
 )
 
+// ManifestsDir - The directory the kubelet will start static pods from
+var ManifestsDir = path.Join(kubeadmconstants.KubernetesDir, kubeadmconstants.ManifestsSubDirName)
+
+// The static pods written by WriteManifests (named as the kubeadm master package names them)
+const (
+	kubeAPIServer         = "kube-apiserver"
+	kubeControllerManager = "kube-controller-manager"
+	kubeScheduler         = "kube-scheduler"
+)
+
+// controlPlaneComponents - the static pods written by WriteManifests
+// (etcd may also be a static pod so only these are ever touched)
+var controlPlaneComponents = []string{
+	kubeAPIServer,
+	kubeControllerManager,
+	kubeScheduler,
+}
+
+// manifestCheckPeriod is long enough for the kubelet to notice static pod manifest changes
+// (the kubelet default --file-check-frequency is 20s)
+const manifestCheckPeriod = 30 * time.Second
+
+// apiServerHealthTimeout is the time allowed for a restarted API server to become healthy
+const apiServerHealthTimeout = 5 * time.Minute
+
+// healthPollInterval is how often a restarted API server is checked
+var healthPollInterval = 5 * time.Second
+
+// apiServerName is always a name in the API server cert (which may not be for 127.0.0.1)
+const apiServerName = "kubernetes"
+
 // WriteManifests - will save kubernetes master manifests from kmm config struct
 func (k *Config) WriteManifests() (err error) {
 	// Get config into kubeadm format
@@ -12,5 +57,140 @@ func (k *Config) WriteManifests() (err error) {
 	if kubeadmapiCfg, err = GetKubeadmCfg(*k); err != nil {
 		return err
 	}
-	return master.WriteStaticPodManifests(kubeadmapiCfg, k.MasterCount)
+	if k.DryRun != nil {
+		return k.dryRunManifests(kubeadmapiCfg)
+	}
+	if err = master.WriteStaticPodManifests(kubeadmapiCfg, k.MasterCount); err != nil {
+		return err
+	}
+	// Remember what was written to detect any drift
+	k.manifestChecksums, err = manifestChecksums(k.fs())
+	return err
+}
+
+// dryRunManifests writes the manifests to a temporary kubernetes dir and records any differences
+func (k *Config) dryRunManifests(kubeadmapiCfg *kubeadmapi.MasterConfiguration) (err error) {
+	kubeDir, err := ioutil.TempDir("", "kmm-dry-run-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(kubeDir)
+	kubernetesDir := kubeadmapi.GlobalEnvParams.KubernetesDir
+	kubeadmapi.GlobalEnvParams.KubernetesDir = kubeDir
+	err = master.WriteStaticPodManifests(kubeadmapiCfg, k.MasterCount)
+	kubeadmapi.GlobalEnvParams.KubernetesDir = kubernetesDir
+	if err != nil {
+		return err
+	}
+	// The manifests refer to the kubernetes dir so must be compared as if written there
+	staging := path.Join(kubeDir, kubeadmconstants.ManifestsSubDirName)
+	infos, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		manifest := path.Join(staging, info.Name())
+		content, err := ioutil.ReadFile(manifest)
+		if err != nil {
+			return err
+		}
+		content = []byte(strings.Replace(string(content), kubeDir, kubernetesDir, -1))
+		if err = ioutil.WriteFile(manifest, content, info.Mode()); err != nil {
+			return err
+		}
+	}
+	return k.DryRun.Collect(staging, ManifestsDir)
+}
+
+// ReconcileManifests - will re-write the control plane manifests if they have changed (or been
+// removed) since last written. Returns true if re-written.
+func (k *Config) ReconcileManifests() (rewritten bool, err error) {
+	current, err := manifestChecksums(k.fs())
+	if err != nil {
+		return false, err
+	}
+	if k.manifestChecksums != nil && reflect.DeepEqual(current, k.manifestChecksums) {
+		return false, nil
+	}
+	log.Printf("Control plane manifests changed, re-writing...")
+	return true, k.WriteManifests()
+}
+
+// RestartControlPlane - will restart the control plane static pods so they use any updated files
+// The manifests are removed until the kubelet has stopped the pods and then written again.
+// Returns once the local API server is healthy again.
+func (k *Config) RestartControlPlane() (err error) {
+	log.Printf("Stopping control plane...")
+	for _, component := range controlPlaneComponents {
+		if err = k.fs().Remove(manifestPath(component)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	time.Sleep(manifestCheckPeriod)
+	log.Printf("Starting control plane...")
+	if err = k.WriteManifests(); err != nil {
+		return err
+	}
+	if k.DryRun != nil {
+		return nil
+	}
+	kubeadmapiCfg, err := GetKubeadmCfg(*k)
+	if err != nil {
+		return err
+	}
+	caCert, err := ioutil.ReadFile(CaCertFile)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCert) {
+		return fmt.Errorf("no certificates found in %q", CaCertFile)
+	}
+	healthz := fmt.Sprintf("https://127.0.0.1:%d/healthz", kubeadmapiCfg.API.BindPort)
+	return waitForHealthy(healthz, &tls.Config{RootCAs: roots, ServerName: apiServerName}, apiServerHealthTimeout)
+}
+
+// waitForHealthy polls a healthz URL until it returns ok or the timeout
+func waitForHealthy(url string, tlsConfig *tls.Config, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout:   healthPollInterval,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				log.Printf("%s is healthy", url)
+				return nil
+			}
+			err = fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to be healthy [%v]", url, err)
+		}
+		log.Printf("Waiting for %s to be healthy: %v", url, err)
+		time.Sleep(healthPollInterval)
+	}
+}
+
+// manifestChecksums returns the checksum of each control plane manifest (empty when missing)
+func manifestChecksums(fs fileutil.Filesystem) (map[string]string, error) {
+	checksums := make(map[string]string)
+	for _, component := range controlPlaneComponents {
+		content, err := readFileIfExists(fs, manifestPath(component))
+		if err != nil {
+			return nil, err
+		}
+		if content != nil {
+			checksums[component] = checksum(string(content))
+		}
+	}
+	return checksums, nil
+}
+
+func manifestPath(component string) string {
+	return path.Join(ManifestsDir, component+".yaml")
 }
@@ -1,7 +1,14 @@
 package kubeadm
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 )
 
 
@@ -23,4 +30,49 @@ func TestWriteManifests(t *testing.T) {
 	if err := k.WriteManifests(); err != nil {
 		t.Error(err)
 	}
+
+	// No drift
+	if rewritten, err := k.ReconcileManifests(); err != nil || rewritten {
+		t.Errorf("expected no re-write but got %v, error: %v", rewritten, err)
+	}
+
+	// Drift
+	if err := os.Remove(manifestPath(kubeScheduler)); err != nil {
+		t.Fatal(err)
+	}
+	if rewritten, err := k.ReconcileManifests(); err != nil || !rewritten {
+		t.Errorf("expected a re-write but got %v, error: %v", rewritten, err)
+	}
+}
+
+func TestWaitForHealthy(t *testing.T) {
+	healthPollInterval = 10 * time.Millisecond
+	checks := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks++
+		if checks < 3 {
+			http.Error(w, "[-]etcd failed", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+	cert, err := x509.ParseCertificate(server.TLS.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	if err = waitForHealthy(server.URL+"/healthz", &tls.Config{RootCAs: roots, ServerName: "example.com"}, time.Second); err != nil {
+		t.Error(err)
+	}
+	if checks != 3 {
+		t.Errorf("expected 3 health checks but got %d", checks)
+	}
+
+	// The API server cert must be trusted
+	if err = waitForHealthy(server.URL+"/healthz", &tls.Config{ServerName: "example.com"}, 50*time.Millisecond); err == nil {
+		t.Error(fmt.Errorf("expected an error waiting for an untrusted server"))
+	}
 }
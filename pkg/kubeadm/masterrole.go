@@ -3,6 +3,8 @@ package kubeadm
 import (
 	"path"
 
+	"github.com/UKHomeOffice/keto-k8/pkg/dryrun"
+
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 	kubemaster "k8s.io/kubernetes/cmd/kubeadm/app/master"
@@ -11,6 +13,10 @@ import (
 
 // UpdateMasterRoleLabelsAndTaints will apply the master role taints and labels
 func (cfg *Config) UpdateMasterRoleLabelsAndTaints() error {
+	if cfg.DryRun != nil {
+		cfg.DryRun.Note(dryrun.KindResource, "master role labels and taints", dryrun.ActionApply, "")
+		return nil
+	}
 
 	adminKubeConfigPath := path.Join(kubeadmapi.GlobalEnvParams.KubernetesDir, kubeadmconstants.AdminKubeConfigFileName)
 	client, err := kubemaster.CreateClientAndWaitForAPI(adminKubeConfigPath)
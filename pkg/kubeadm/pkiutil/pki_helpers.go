@@ -5,6 +5,8 @@ Added:
  TryLoadPublicKeyFromDisk to support loading the public SA key...
  TryLoadAnyCertFromDisk
  TryLoadAnyKeyFromDisk
+ NewPrivateKey and KeyTypeOf to support RSA and ECDSA keys (keys are a crypto.Signer)
+ NewCertificateAuthorityWithConfig to create a CA with a given subject and validity
 
  Internalised certutil (from k8s.io/client-go/util/cert)
  */
@@ -28,6 +30,10 @@ limitations under the License.
 package pkiutil
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -35,25 +41,90 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
 )
 
-// TODO: It should be able to generate different types of private keys, at least: RSA and ECDSA (and in the future maybe Ed25519 as well)
 // TODO: See if it makes sense to move this package directly to pkg/util/cert
 
-// NewCertificateAuthority create a new CA
-func NewCertificateAuthority() (*x509.Certificate, *rsa.PrivateKey, error) {
-	key, err := certutil.NewPrivateKey()
-	if err != nil {
-		return nil, nil, fmt.Errorf("unable to create private key [%v]", err)
+// The types of private key which can be generated
+const (
+	KeyTypeRSA2048   string = "rsa-2048"
+	KeyTypeRSA4096   string = "rsa-4096"
+	KeyTypeECDSAP256 string = "ecdsa-p256"
+	KeyTypeECDSAP384 string = "ecdsa-p384"
+)
+
+// DefaultKeyType is the type of private key generated unless another is specified
+const DefaultKeyType string = KeyTypeRSA2048
+
+// DefaultCaValidity is how long a new CA is valid for unless another validity is specified
+const DefaultCaValidity time.Duration = 10 * 365 * 24 * time.Hour
+
+// KeyTypes are the types of private key which can be generated
+var KeyTypes = []string{KeyTypeRSA2048, KeyTypeRSA4096, KeyTypeECDSAP256, KeyTypeECDSAP384}
+
+// ValidateKeyType checks a key type can be generated (blank is the default key type)
+func ValidateKeyType(keyType string) error {
+	if len(keyType) == 0 {
+		return nil
 	}
+	for _, supported := range KeyTypes {
+		if keyType == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid key type %q, must be one of: %s", keyType, strings.Join(KeyTypes, ", "))
+}
 
+// NewPrivateKey creates a private key of a key type (blank is the default key type)
+func NewPrivateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA2048, "":
+		return rsa.GenerateKey(cryptorand.Reader, 2048)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(cryptorand.Reader, 4096)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), cryptorand.Reader)
+	}
+	return nil, ValidateKeyType(keyType)
+}
+
+// KeyTypeOf returns the key type of a private key (e.g. to generate another of the same type)
+func KeyTypeOf(key crypto.Signer) (string, error) {
+	var keyType string
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		keyType = fmt.Sprintf("rsa-%d", k.N.BitLen())
+	case *ecdsa.PrivateKey:
+		keyType = fmt.Sprintf("ecdsa-p%d", k.Curve.Params().BitSize)
+	default:
+		return "", fmt.Errorf("unsupported private key type %T", key)
+	}
+	return keyType, ValidateKeyType(keyType)
+}
+
+// NewCertificateAuthority create a new CA with a key of keyType (blank is the default key type)
+func NewCertificateAuthority(keyType string) (*x509.Certificate, crypto.Signer, error) {
 	config := certutil.Config{
 		CommonName: "kubernetes",
 	}
-	cert, err := certutil.NewSelfSignedCACert(config, key)
+	return NewCertificateAuthorityWithConfig(config, DefaultCaValidity, keyType)
+}
+
+// NewCertificateAuthorityWithConfig create a new CA with the subject of config, valid for validity, with a key
+// of keyType (blank is the default key type)
+func NewCertificateAuthorityWithConfig(config certutil.Config, validity time.Duration, keyType string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := NewPrivateKey(keyType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create private key [%v]", err)
+	}
+
+	cert, err := certutil.NewSelfSignedCACertWithValidity(config, key, validity)
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to create self-signed certificate [%v]", err)
 	}
@@ -61,9 +132,9 @@ func NewCertificateAuthority() (*x509.Certificate, *rsa.PrivateKey, error) {
 	return cert, key, nil
 }
 
-// NewCertAndKey - new cert and key
-func NewCertAndKey(caCert *x509.Certificate, caKey *rsa.PrivateKey, config certutil.Config) (*x509.Certificate, *rsa.PrivateKey, error) {
-	key, err := certutil.NewPrivateKey()
+// NewCertAndKey - new cert and a key of keyType (blank is the default key type)
+func NewCertAndKey(caCert *x509.Certificate, caKey crypto.Signer, config certutil.Config, keyType string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := NewPrivateKey(keyType)
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to create private key [%v]", err)
 	}
@@ -77,7 +148,7 @@ func NewCertAndKey(caCert *x509.Certificate, caKey *rsa.PrivateKey, config certu
 }
 
 // WriteCertAndKey - save new key and cert to disk at pkiPath as name
-func WriteCertAndKey(pkiPath string, name string, cert *x509.Certificate, key *rsa.PrivateKey) error {
+func WriteCertAndKey(pkiPath string, name string, cert *x509.Certificate, key crypto.Signer) error {
 	if err := WriteKey(pkiPath, name, key); err != nil {
 		return err
 	}
@@ -104,13 +175,17 @@ func WriteCert(pkiPath, name string, cert *x509.Certificate) error {
 }
 
 // WriteKey - writes a private key as name at pkiPath
-func WriteKey(pkiPath, name string, key *rsa.PrivateKey) error {
+func WriteKey(pkiPath, name string, key crypto.Signer) error {
 	if key == nil {
 		return fmt.Errorf("private key cannot be nil when writing to file")
 	}
 
+	encoded, err := certutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return err
+	}
 	privateKeyPath := pathForKey(pkiPath, name)
-	if err := certutil.WriteKey(privateKeyPath, certutil.EncodePrivateKeyPEM(key)); err != nil {
+	if err := certutil.WriteKey(privateKeyPath, encoded); err != nil {
 		return fmt.Errorf("unable to write private key to file %q: [%v]", privateKeyPath, err)
 	}
 
@@ -118,7 +193,7 @@ func WriteKey(pkiPath, name string, key *rsa.PrivateKey) error {
 }
 
 // WritePublicKey - writes a public key as name at pkiPath
-func WritePublicKey(pkiPath, name string, key *rsa.PublicKey) error {
+func WritePublicKey(pkiPath, name string, key crypto.PublicKey) error {
 	if key == nil {
 		return fmt.Errorf("public key cannot be nil when writing to file")
 	}
@@ -151,7 +226,7 @@ func CertOrKeyExist(pkiPath, name string) bool {
 }
 
 // TryLoadCertAndKeyFromDisk tries to load a cert and a key from the disk and validates that they are valid
-func TryLoadCertAndKeyFromDisk(pkiPath, name string) (*x509.Certificate, *rsa.PrivateKey, error) {
+func TryLoadCertAndKeyFromDisk(pkiPath, name string) (*x509.Certificate, crypto.Signer, error) {
 	cert, err := TryLoadCertFromDisk(pkiPath, name)
 	if err != nil {
 		return nil, nil, err
@@ -196,14 +271,14 @@ func TryLoadAnyCertFromDisk(certificatePath string) (*x509.Certificate, error) {
 }
 
 // TryLoadKeyFromDisk tries to load the key from the disk and validates that it is valid
-func TryLoadKeyFromDisk(pkiPath, name string) (*rsa.PrivateKey, error) {
+func TryLoadKeyFromDisk(pkiPath, name string) (crypto.Signer, error) {
 	privateKeyPath := pathForKey(pkiPath, name)
 	key, err := TryLoadAnyKeyFromDisk(privateKeyPath)
 	return key, err
 }
 
 // TryLoadAnyKeyFromDisk tries to load the key from the disk and validates that it is valid
-func TryLoadAnyKeyFromDisk(privateKeyPath string) (*rsa.PrivateKey, error) {
+func TryLoadAnyKeyFromDisk(privateKeyPath string) (crypto.Signer, error) {
 
 	// Parse the private key from a file
 	privKey, err := certutil.PrivateKeyFromFile(privateKeyPath)
@@ -211,20 +286,22 @@ func TryLoadAnyKeyFromDisk(privateKeyPath string) (*rsa.PrivateKey, error) {
 		return nil, fmt.Errorf("couldn't load the private key file %s: %v", privateKeyPath, err)
 	}
 
-	// Allow RSA format only
-	var key *rsa.PrivateKey
+	// Allow RSA and ECDSA formats only
+	var key crypto.Signer
 	switch k := privKey.(type) {
 	case *rsa.PrivateKey:
 		key = k
+	case *ecdsa.PrivateKey:
+		key = k
 	default:
-		return nil, fmt.Errorf("the private key file %s isn't in RSA format", privateKeyPath)
+		return nil, fmt.Errorf("the private key file %s isn't in RSA or ECDSA format", privateKeyPath)
 	}
 
 	return key, nil
 }
 
 // TryLoadPublicKeyFromDisk - will verify a Public key and return it if OK
-func TryLoadPublicKeyFromDisk(pkiPath, name string) (*rsa.PublicKey, error) {
+func TryLoadPublicKeyFromDisk(pkiPath, name string) (crypto.PublicKey, error) {
 	publicKeyPath := pathForPublicKey(pkiPath, name)
 
 	pubBytes, err := ioutil.ReadFile(publicKeyPath)
@@ -233,11 +310,13 @@ func TryLoadPublicKeyFromDisk(pkiPath, name string) (*rsa.PublicKey, error) {
 	}
 	block, _ := pem.Decode(pubBytes)
 	pubkeyInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
-	pubkey, ok := pubkeyInterface.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("Error parsing public key %q", publicKeyPath)
+	switch pubkey := pubkeyInterface.(type) {
+	case *rsa.PublicKey:
+		return pubkey, nil
+	case *ecdsa.PublicKey:
+		return pubkey, nil
 	}
-	return pubkey, nil
+	return nil, fmt.Errorf("Error parsing public key %q", publicKeyPath)
 }
 
 func pathsForCertAndKey(pkiPath, name string) (string, string) {
@@ -23,12 +23,13 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
 )
 
 func TestNewCertificateAuthority(t *testing.T) {
-	cert, key, err := NewCertificateAuthority()
+	cert, key, err := NewCertificateAuthority(DefaultKeyType)
 
 	if cert == nil {
 		t.Errorf(
@@ -48,6 +49,56 @@ func TestNewCertificateAuthority(t *testing.T) {
 	}
 }
 
+func TestNewCertificateAuthorityWithConfig(t *testing.T) {
+	config := certutil.Config{CommonName: "Keto ETCD CA", Organization: []string{"ETCD"}}
+	cert, key, err := NewCertificateAuthorityWithConfig(config, 24*time.Hour, KeyTypeECDSAP256)
+	if err != nil {
+		t.Fatalf("failed NewCertificateAuthorityWithConfig with an error: %v", err)
+	}
+	if !cert.IsCA || cert.Subject.CommonName != "Keto ETCD CA" || len(cert.Subject.Organization) != 1 {
+		t.Errorf("unexpected CA subject %v", cert.Subject)
+	}
+	if cert.NotAfter.After(time.Now().Add(24 * time.Hour)) {
+		t.Errorf("expected the CA to expire within a day but got %v", cert.NotAfter)
+	}
+	if keyType, err := KeyTypeOf(key); err != nil || keyType != KeyTypeECDSAP256 {
+		t.Errorf("expected a %s key but got %s [%v]", KeyTypeECDSAP256, keyType, err)
+	}
+}
+
+func TestNewPrivateKey(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Couldn't create tmpdir")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, keyType := range KeyTypes {
+		key, err := NewPrivateKey(keyType)
+		if err != nil {
+			t.Fatalf("failed NewPrivateKey for %s with an error: %v", keyType, err)
+		}
+		if err = WriteKey(tmpdir, keyType, key); err != nil {
+			t.Fatalf("failed WriteKey for %s with an error: %v", keyType, err)
+		}
+		loaded, err := TryLoadKeyFromDisk(tmpdir, keyType)
+		if err != nil {
+			t.Fatalf("failed TryLoadKeyFromDisk for %s with an error: %v", keyType, err)
+		}
+		if actual, err := KeyTypeOf(loaded); err != nil || actual != keyType {
+			t.Errorf(
+				"failed KeyTypeOf:\n\texpected: %s\n\t  actual: %s (%v)",
+				keyType,
+				actual,
+				err,
+			)
+		}
+	}
+	if _, err := NewPrivateKey("dsa-1024"); err == nil {
+		t.Errorf("failed NewPrivateKey, expected an error for an unsupported key type")
+	}
+}
+
 func TestNewCertAndKey(t *testing.T) {
 	var tests = []struct {
 		caKeySize int
@@ -76,7 +127,7 @@ func TestNewCertAndKey(t *testing.T) {
 			Organization: []string{"test"},
 			Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 		}
-		_, _, actual := NewCertAndKey(caCert, caKey, config)
+		_, _, actual := NewCertAndKey(caCert, caKey, config, DefaultKeyType)
 		if (actual == nil) != rt.expected {
 			t.Errorf(
 				"failed NewCertAndKey:\n\texpected: %t\n\t  actual: %t",
@@ -87,6 +138,27 @@ func TestNewCertAndKey(t *testing.T) {
 	}
 }
 
+func TestNewCertAndKeyECDSA(t *testing.T) {
+	caCert, caKey, err := NewCertificateAuthority(KeyTypeECDSAP384)
+	if err != nil {
+		t.Fatalf("failed NewCertificateAuthority with an error: %v", err)
+	}
+	config := certutil.Config{
+		CommonName: "test",
+		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, key, err := NewCertAndKey(caCert, caKey, config, KeyTypeECDSAP256)
+	if err != nil {
+		t.Fatalf("failed NewCertAndKey with an error: %v", err)
+	}
+	if err = cert.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("failed NewCertAndKey, not signed by the CA: %v", err)
+	}
+	if keyType, _ := KeyTypeOf(key); keyType != KeyTypeECDSAP256 {
+		t.Errorf("failed NewCertAndKey, expected a %s key but got %s", KeyTypeECDSAP256, keyType)
+	}
+}
+
 func TestWriteCertAndKey(t *testing.T) {
 	tmpdir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -220,7 +292,7 @@ func TestTryLoadCertAndKeyFromDisk(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 
-	caCert, caKey, err := NewCertificateAuthority()
+	caCert, caKey, err := NewCertificateAuthority(DefaultKeyType)
 	if err != nil {
 		t.Errorf(
 			"failed to create cert and key with an error: %v",
@@ -270,7 +342,7 @@ func TestTryLoadCertFromDisk(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 
-	caCert, _, err := NewCertificateAuthority()
+	caCert, _, err := NewCertificateAuthority(DefaultKeyType)
 	if err != nil {
 		t.Errorf(
 			"failed to create cert and key with an error: %v",
@@ -320,7 +392,7 @@ func TestTryLoadKeyFromDisk(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 
-	_, caKey, err := NewCertificateAuthority()
+	_, caKey, err := NewCertificateAuthority(DefaultKeyType)
 	if err != nil {
 		t.Errorf(
 			"failed to create cert and key with an error: %v",
@@ -0,0 +1,216 @@
+package kubeadm
+
+import (
+	"crypto"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+)
+
+// AssetRotation - records a shared key rotation where the previous keys are still trusted
+type AssetRotation struct {
+	Started time.Time `json:"started"`
+	// Revision is the shared assets revision which introduced the new keys
+	Revision int `json:"revision"`
+}
+
+// trustedAssets - the files which must trust both the new and previous keys during a rotation
+// (the new key or cert is always first)
+var trustedAssets = []string{
+	kubeadmconstants.ServiceAccountPublicKeyName,
+	kubeadmconstants.FrontProxyCACertName,
+}
+
+// AssetsRevision - returns the revision of serialized shared assets and if a rotation is in progress
+func AssetsRevision(assets string) (revision int, rotating bool, err error) {
+	revisioned := struct {
+		Revision int            `json:"revision"`
+		Rotation *AssetRotation `json:"rotation"`
+	}{}
+	if err = json.Unmarshal([]byte(assets), &revisioned); err != nil {
+		return 0, false, fmt.Errorf("shared assets could not be parsed [%v]", err)
+	}
+	return revisioned.Revision, revisioned.Rotation != nil, nil
+}
+
+// RotateAssets - returns a new revision of the shared assets with new service account and front proxy keys
+// The previous service account public key and front proxy CA are kept (after the new ones) so
+// the apiserver still accepts anything signed with the previous keys until RetireAssets
+func (k *Config) RotateAssets(assets string) (rotated string, err error) {
+	sharedAssets, err := parseSharedAssets(assets, k.SharedAssetDescriptors())
+	if err != nil {
+		return "", err
+	}
+	if sharedAssets.Rotation != nil {
+		return "", fmt.Errorf("shared assets rotation to revision %d already in progress since %v, retire previous keys first",
+			sharedAssets.Rotation.Revision, sharedAssets.Rotation.Started)
+	}
+	files, err := newRotatedFiles(sharedAssets.Files)
+	if err != nil {
+		return "", err
+	}
+	for name, content := range files {
+		for _, trusted := range trustedAssets {
+			if name == trusted {
+				content = content + sharedAssets.Files[name].Content
+			}
+		}
+		sharedAssets.Files[name] = SharedAsset{Content: content, SHA256: checksum(content)}
+	}
+	if err = sharedAssets.revise(); err != nil {
+		return "", err
+	}
+	sharedAssets.Rotation = &AssetRotation{
+		Started:  sharedAssets.Created,
+		Revision: sharedAssets.Revision,
+	}
+	log.Printf("Rotated shared keys as revision %d", sharedAssets.Revision)
+	return k.serializeAssets(sharedAssets)
+}
+
+// RetireAssets - returns a new revision of the shared assets no longer trusting the keys replaced by RotateAssets
+func (k *Config) RetireAssets(assets string) (retired string, err error) {
+	sharedAssets, err := parseSharedAssets(assets, k.SharedAssetDescriptors())
+	if err != nil {
+		return "", err
+	}
+	if sharedAssets.Rotation == nil {
+		return "", fmt.Errorf("no shared assets rotation in progress, nothing to retire")
+	}
+	for _, name := range trustedAssets {
+		content, err := firstPEMBlock(sharedAssets.Files[name].Content)
+		if err != nil {
+			return "", fmt.Errorf("unable to retire previous keys from %q [%v]", name, err)
+		}
+		sharedAssets.Files[name] = SharedAsset{Content: content, SHA256: checksum(content)}
+	}
+	if err = sharedAssets.revise(); err != nil {
+		return "", err
+	}
+	sharedAssets.Rotation = nil
+	log.Printf("Retired previous shared keys as revision %d", sharedAssets.Revision)
+	return k.serializeAssets(sharedAssets)
+}
+
+// ReloadAssets - will save a new revision of the shared assets, re-create any certificates
+// they sign and restart the control plane to use them
+func (k *Config) ReloadAssets(assets string) (err error) {
+	if err = k.SaveAssets(assets); err != nil {
+		return err
+	}
+	// The front proxy client cert is signed by the (possibly new) front proxy CA
+	for _, name := range []string{
+		kubeadmconstants.FrontProxyClientCertName,
+		kubeadmconstants.FrontProxyClientKeyName,
+	} {
+		if err = k.fs().Remove(path.Join(PkiDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err = k.CreatePKI(); err != nil {
+		return err
+	}
+	return k.RestartControlPlane()
+}
+
+// revise updates the metadata for a new revision of the shared assets
+func (s *SharedAssets) revise() error {
+	createdBy, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	s.Version = SharedAssetsVersion
+	s.Revision++
+	s.Created = time.Now().UTC()
+	s.CreatedBy = createdBy
+	return nil
+}
+
+// serializeAssets checks shared assets are still valid before serializing them
+func (k *Config) serializeAssets(sharedAssets *SharedAssets) (string, error) {
+	if err := sharedAssets.validate(k.SharedAssetDescriptors()); err != nil {
+		return "", err
+	}
+	assetsBytes, err := json.Marshal(sharedAssets)
+	if err != nil {
+		return "", err
+	}
+	return string(assetsBytes), nil
+}
+
+// newRotatedFiles generates a new service account key pair and front proxy CA
+// The new keys are the same type as the current keys.
+func newRotatedFiles(current map[string]SharedAsset) (map[string]string, error) {
+	saKey, err := newKeyLike(current[kubeadmconstants.ServiceAccountPrivateKeyName].Content)
+	if err != nil {
+		return nil, fmt.Errorf("failure while creating service account key [%v]", err)
+	}
+	saPub, err := certutil.EncodePublicKeyPEM(saKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failure while encoding service account public key [%v]", err)
+	}
+	saKeyPEM, err := certutil.MarshalPrivateKeyToPEM(saKey)
+	if err != nil {
+		return nil, fmt.Errorf("failure while encoding service account key [%v]", err)
+	}
+	frontProxyKeyType, err := keyTypeOf(current[kubeadmconstants.FrontProxyCAKeyName].Content)
+	if err != nil {
+		return nil, fmt.Errorf("failure while creating front proxy CA [%v]", err)
+	}
+	frontProxyCaCert, frontProxyCaKey, err := pkiutil.NewCertificateAuthority(frontProxyKeyType)
+	if err != nil {
+		return nil, fmt.Errorf("failure while creating front proxy CA [%v]", err)
+	}
+	frontProxyCaKeyPEM, err := certutil.MarshalPrivateKeyToPEM(frontProxyCaKey)
+	if err != nil {
+		return nil, fmt.Errorf("failure while encoding front proxy CA key [%v]", err)
+	}
+	return map[string]string{
+		kubeadmconstants.ServiceAccountPublicKeyName:  string(saPub),
+		kubeadmconstants.ServiceAccountPrivateKeyName: string(saKeyPEM),
+		kubeadmconstants.FrontProxyCACertName:         string(certutil.EncodeCertPEM(frontProxyCaCert)),
+		kubeadmconstants.FrontProxyCAKeyName:          string(frontProxyCaKeyPEM),
+	}, nil
+}
+
+// newKeyLike generates a private key of the same type as a PEM encoded key
+func newKeyLike(content string) (crypto.Signer, error) {
+	keyType, err := keyTypeOf(content)
+	if err != nil {
+		return nil, err
+	}
+	return pkiutil.NewPrivateKey(keyType)
+}
+
+// keyTypeOf returns the key type of a PEM encoded key (the default key type when there's no key)
+func keyTypeOf(content string) (string, error) {
+	if len(content) == 0 {
+		return pkiutil.DefaultKeyType, nil
+	}
+	key, err := certutil.ParsePrivateKeyPEM([]byte(content))
+	if err != nil {
+		return "", err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", fmt.Errorf("unsupported private key type %T", key)
+	}
+	return pkiutil.KeyTypeOf(signer)
+}
+
+// firstPEMBlock returns only the first PEM block from content
+func firstPEMBlock(content string) (string, error) {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return "", fmt.Errorf("no PEM data found")
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
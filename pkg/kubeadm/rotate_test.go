@@ -0,0 +1,122 @@
+package kubeadm
+
+import (
+	"crypto"
+	"strings"
+	"testing"
+
+	certutil "github.com/UKHomeOffice/keto-k8/pkg/client-go/util/cert"
+	"github.com/UKHomeOffice/keto-k8/pkg/kubeadm/pkiutil"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+)
+
+func TestRotateAndRetireAssets(t *testing.T) {
+	files := getTestAssetFiles(t)
+	sharedAssets, err := newSharedAssets(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &Config{}
+
+	rotated, err := k.RotateAssets(marshalTestAssets(t, sharedAssets))
+	if err != nil {
+		t.Fatalf("failed RotateAssets with an error: %v", err)
+	}
+	if revision, rotating, err := AssetsRevision(rotated); err != nil || revision != 1 || !rotating {
+		t.Errorf("expected revision 1 rotating but got revision %d rotating %v, error: %v", revision, rotating, err)
+	}
+	rotatedAssets, err := parseSharedAssets(rotated, DefaultSharedAssets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{kubeadmconstants.ServiceAccountPrivateKeyName, kubeadmconstants.FrontProxyCAKeyName} {
+		if rotatedAssets.Files[name].Content == files[name] {
+			t.Errorf("expected a new %q", name)
+		}
+	}
+	// The previous public key and CA must still be trusted
+	for _, name := range trustedAssets {
+		content := rotatedAssets.Files[name].Content
+		if strings.HasPrefix(content, files[name]) || !strings.HasSuffix(content, files[name]) {
+			t.Errorf("expected %q to trust the new and then previous keys", name)
+		}
+	}
+	if _, err := k.RotateAssets(rotated); err == nil {
+		t.Errorf("expected an error rotating whilst a rotation is in progress")
+	}
+
+	retired, err := k.RetireAssets(rotated)
+	if err != nil {
+		t.Fatalf("failed RetireAssets with an error: %v", err)
+	}
+	if revision, rotating, err := AssetsRevision(retired); err != nil || revision != 2 || rotating {
+		t.Errorf("expected revision 2 not rotating but got revision %d rotating %v, error: %v", revision, rotating, err)
+	}
+	retiredAssets, err := parseSharedAssets(retired, DefaultSharedAssets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range trustedAssets {
+		if strings.Contains(retiredAssets.Files[name].Content, files[name]) {
+			t.Errorf("expected %q to no longer trust the previous keys", name)
+		}
+	}
+	if _, err := k.RetireAssets(retired); err == nil {
+		t.Errorf("expected an error retiring with no rotation in progress")
+	}
+}
+
+func TestRotateAssetsKeyType(t *testing.T) {
+	files := getTestAssetFiles(t)
+	saKey, err := pkiutil.NewPrivateKey(pkiutil.KeyTypeECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(pkiutil.KeyTypeECDSAP384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range map[string]crypto.Signer{
+		kubeadmconstants.ServiceAccountPrivateKeyName: saKey,
+		kubeadmconstants.FrontProxyCAKeyName:          caKey,
+	} {
+		keyPEM, err := certutil.MarshalPrivateKeyToPEM(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(keyPEM)
+	}
+	saPub, err := certutil.EncodePublicKeyPEM(saKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[kubeadmconstants.ServiceAccountPublicKeyName] = string(saPub)
+	files[kubeadmconstants.FrontProxyCACertName] = string(certutil.EncodeCertPEM(caCert))
+	sharedAssets, err := newSharedAssets(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &Config{}
+
+	rotated, err := k.RotateAssets(marshalTestAssets(t, sharedAssets))
+	if err != nil {
+		t.Fatalf("failed RotateAssets with an error: %v", err)
+	}
+	rotatedAssets, err := parseSharedAssets(rotated, DefaultSharedAssets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new keys are the same type as the previous keys
+	for name, expected := range map[string]string{
+		kubeadmconstants.ServiceAccountPrivateKeyName: pkiutil.KeyTypeECDSAP256,
+		kubeadmconstants.FrontProxyCAKeyName:          pkiutil.KeyTypeECDSAP384,
+	} {
+		content := rotatedAssets.Files[name].Content
+		if content == files[name] {
+			t.Errorf("expected a new %q", name)
+		}
+		if keyType, err := keyTypeOf(content); err != nil || keyType != expected {
+			t.Errorf("expected a %s key for %q but got %s [%v]", expected, name, keyType, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package network
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
+)
+
+const calicoPodCidr = "192.168.0.0/16"
+
+var calicoOptions = options{
+	name:           "calico",
+	podNetworkCidr: calicoPodCidr,
+	backends:       []string{BackendIPIP, BackendBGP},
+	mtu:            true,
+	iface:          true,
+	images:         []string{"calico-node", "calico-cni", "calico-kube-controllers", "calicoctl"},
+	ipPools:        true,
+	ipipModes:      []string{IPIPModeAlways, IPIPModeCrossSubnet},
+	datastores:     []string{DatastoreKubernetes, DatastoreEtcd},
+	cniConfigs:     []string{"10-calico.conf", "calico-kubeconfig"},
+}
+
+// CalicoNetworkProvider - a struct to represent the concrete implementation of a Calico network.Provider
+// Routes are distributed with BGP and traffic is encapsulated with IPIP (unless using the bgp backend).
+type CalicoNetworkProvider struct {
+	cfg Config
+}
+
+// NewCalicoNetworkProvider - a factory method to initialise and return a Calico specific network.Provider
+func NewCalicoNetworkProvider(cfg Config) (Provider, error) {
+	if err := calicoOptions.validate(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Backend == BackendBGP && len(cfg.IPIPMode) > 0 {
+		return nil, fmt.Errorf("The IPIP mode can't be set for calico with the %s backend", BackendBGP)
+	}
+	if cfg.Datastore == DatastoreEtcd {
+		if err := validateEtcdEndpoints(cfg.Etcd.Endpoints); err != nil {
+			return nil, err
+		}
+		if len(cfg.Etcd.ClientCertFileName) == 0 || len(cfg.Etcd.ClientKeyFileName) == 0 {
+			return nil, fmt.Errorf("An etcd client cert and key are required for the calico %s datastore", DatastoreEtcd)
+		}
+	}
+	return &CalicoNetworkProvider{cfg: cfg}, nil
+}
+
+// Name - will return the Calico NetworkProvider name
+func (fnp *CalicoNetworkProvider) Name() string {
+	return calicoOptions.name
+}
+
+// PodNetworkCidr - will return the Calico pod network CIDR
+func (fnp *CalicoNetworkProvider) PodNetworkCidr() string {
+	return calicoOptions.data(fnp.cfg).Network
+}
+
+// CNIConfigs - will return the Calico CNI config files
+func (fnp *CalicoNetworkProvider) CNIConfigs() []string {
+	return calicoOptions.cniConfigs
+}
+
+// Create - will create the K8 network resources (Calico)
+func (fnp *CalicoNetworkProvider) Create(a k8client.Applier) error {
+	return deploy(a, fnp)
+}
+
+// Manifest - will return the K8 network resources (Calico)
+// The etcd client certs are read for the etcd datastore.
+func (fnp *CalicoNetworkProvider) Manifest() (string, error) {
+	data := calicoOptions.data(fnp.cfg)
+	if data.Backend == BackendBGP {
+		data.IPIPMode = "off"
+	}
+	if data.Datastore == DatastoreEtcd {
+		var err error
+		if data.Etcd, err = etcdData(fnp.cfg); err != nil {
+			return "", err
+		}
+	}
+	return renderTemplate(fnp.Name(), calicoYaml, data, fnp.cfg.Images)
+}
+
+// etcdData returns the etcd connection using the calico client cert
+func etcdData(cfg Config) (data EtcdData, err error) {
+	data.Endpoints = cfg.Etcd.Endpoints
+	if data.CA, err = readEtcdFile("CA", cfg.Etcd.CaFileName); err != nil {
+		return data, err
+	}
+	if data.Cert, err = readEtcdFile("client cert", cfg.Etcd.ClientCertFileName); err != nil {
+		return data, err
+	}
+	if data.Key, err = readEtcdFile("client key", cfg.Etcd.ClientKeyFileName); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// readEtcdFile returns the base64 encoded content of an etcd TLS file (empty if not set)
+func readEtcdFile(description, fileName string) (string, error) {
+	if len(fileName) == 0 {
+		return "", nil
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("Error reading etcd %s for calico [%v]", description, err)
+	}
+	return base64.StdEncoding.EncodeToString(content), nil
+}
+
+// validateEtcdEndpoints checks the etcd endpoints can be reached from every node (not just the masters)
+func validateEtcdEndpoints(endpoints string) error {
+	if len(endpoints) == 0 {
+		return fmt.Errorf("Etcd endpoints are required for the calico %s datastore", DatastoreEtcd)
+	}
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("Invalid etcd endpoint %q [%v]", endpoint, err)
+		}
+		host := u.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return fmt.Errorf("Etcd endpoint %q can't be reached by calico from every node", endpoint)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package network
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+)
+
+func TestCalicoDefaults(t *testing.T) {
+	np, err := CreateProvider("calico", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.PodNetworkCidr() != calicoPodCidr {
+		t.Errorf("expected default pod network CIDR %q but got %q", calicoPodCidr, np.PodNetworkCidr())
+	}
+	manifest, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"name: DATASTORE_TYPE",
+		`"subnet": "usePodCidr"`,
+		"name: ippools.crd.projectcalico.org",
+		"cidr: 192.168.0.0/16",
+		"mode: always",
+		"image: quay.io/calico/node:v2.5.1",
+	} {
+		if !strings.Contains(manifest, expected) {
+			t.Errorf("expected %q in the calico manifest", expected)
+		}
+	}
+	for _, unexpected := range []string{"calico-etcd-secrets", "calico-kube-controllers", "FELIX_IPINIPMTU", "IP_AUTODETECTION_METHOD"} {
+		if strings.Contains(manifest, unexpected) {
+			t.Errorf("unexpected %q in the default calico manifest", unexpected)
+		}
+	}
+}
+
+func TestCalicoConfig(t *testing.T) {
+	cfg := Config{
+		PodNetworkCidr: "172.16.0.0/16",
+		IPPools:        []string{"172.17.0.0/16"},
+		IPIPMode:       IPIPModeCrossSubnet,
+		MTU:            1440,
+		Interface:      "eth1",
+	}
+	np, err := CreateProvider("calico", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"cidr: 172.16.0.0/16",
+		"cidr: 172.17.0.0/16",
+		"mode: cross-subnet",
+		`value: "cross-subnet"`,
+		`"mtu": 1440,`,
+		`value: "interface=eth1"`,
+	} {
+		if !strings.Contains(manifest, expected) {
+			t.Errorf("expected %q in the calico manifest", expected)
+		}
+	}
+
+	// The ip pools job is replaced when the pools change
+	cfg.IPPools = nil
+	np, err = CreateProvider("calico", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobName(manifest) == jobName(changed) {
+		t.Errorf("expected a new ip pools job name when the pools change")
+	}
+}
+
+func TestCalicoBGP(t *testing.T) {
+	np, err := CreateProvider("calico", Config{Backend: BackendBGP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(manifest, "enabled: false") || !strings.Contains(manifest, `value: "off"`) {
+		t.Errorf("expected IPIP to be disabled with the bgp backend")
+	}
+	if _, err := CreateProvider("calico", Config{Backend: BackendBGP, IPIPMode: IPIPModeAlways}); err == nil {
+		t.Error("expected an error setting the IPIP mode with the bgp backend")
+	}
+}
+
+func TestCalicoEtcd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calico-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certs := map[string]string{"ca.pem": "ca", "client.pem": "cert", "client-key.pem": "key"}
+	for name, content := range certs {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := Config{
+		Datastore: DatastoreEtcd,
+		Etcd: etcd.Client{
+			Endpoints:          "https://10.0.0.1:2379,https://10.0.0.2:2379",
+			CaFileName:         filepath.Join(dir, "ca.pem"),
+			ClientCertFileName: filepath.Join(dir, "client.pem"),
+			ClientKeyFileName:  filepath.Join(dir, "client-key.pem"),
+		},
+	}
+	np, err := CreateProvider("calico", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`etcd_endpoints: "https://10.0.0.1:2379,https://10.0.0.2:2379"`,
+		"etcd-ca: " + base64.StdEncoding.EncodeToString([]byte("ca")),
+		"etcd-cert: " + base64.StdEncoding.EncodeToString([]byte("cert")),
+		"etcd-key: " + base64.StdEncoding.EncodeToString([]byte("key")),
+		`"type": "calico-ipam"`,
+		"name: calico-kube-controllers",
+	} {
+		if !strings.Contains(manifest, expected) {
+			t.Errorf("expected %q in the calico manifest", expected)
+		}
+	}
+	if strings.Contains(manifest, "kind: CustomResourceDefinition") {
+		t.Errorf("unexpected custom resources with the etcd datastore")
+	}
+
+	// The client cert and key may be in the same file
+	cfg.Etcd.ClientKeyFileName = cfg.Etcd.ClientCertFileName
+	if np, err = CreateProvider("calico", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if manifest, err = np.Manifest(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(manifest, "etcd-key: "+base64.StdEncoding.EncodeToString([]byte("cert"))) {
+		t.Errorf("expected the etcd key from the client cert file in the calico manifest")
+	}
+
+	os.Remove(cfg.Etcd.CaFileName)
+	if _, err := np.Manifest(); err == nil {
+		t.Error("expected an error for a missing etcd client cert")
+	}
+}
+
+func TestCalicoInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Datastore: DatastoreEtcd},
+		{Datastore: DatastoreEtcd, Etcd: etcd.Client{Endpoints: "https://127.0.0.1:2379"}},
+		{Datastore: DatastoreEtcd, Etcd: etcd.Client{Endpoints: "https://10.0.0.1:2379", CaFileName: "ca.pem"}},
+		{Datastore: DatastoreEtcd, Etcd: etcd.Client{Endpoints: "https://10.0.0.1:2379", ClientCertFileName: "calico.pem"}},
+		{Datastore: DatastoreEtcd, Etcd: etcd.Client{Endpoints: "https://10.0.0.1:2379,http://localhost:2379"}},
+		{Datastore: "consul"},
+		{IPPools: []string{"172.17.0.0"}},
+		{IPIPMode: "never"},
+		{Backend: BackendVxlan},
+	} {
+		if _, err := CreateProvider("calico", cfg); err == nil {
+			t.Errorf("expected an error for calico with %+v", cfg)
+		}
+	}
+	for _, cfg := range []Config{{IPPools: []string{"172.17.0.0/16"}}, {IPIPMode: IPIPModeAlways}, {Datastore: DatastoreEtcd}} {
+		if _, err := CreateProvider("flannel", cfg); err == nil {
+			t.Errorf("expected an error for flannel with %+v", cfg)
+		}
+	}
+}
+
+func jobName(manifest string) string {
+	for _, line := range strings.Split(manifest, "\n") {
+		if strings.Contains(line, "name: calico-ippools-") {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
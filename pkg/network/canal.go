@@ -1,26 +1,53 @@
 package network
 
+import "github.com/UKHomeOffice/keto-k8/pkg/k8client"
+
 const canalPodCidr = "10.244.0.0/16"
 
-// CanalNetworkProvider  - a struct to represent the concrete implementation of a Weave network.Provider
-type CanalNetworkProvider struct {}
+var canalOptions = options{
+	name:           "canal",
+	podNetworkCidr: canalPodCidr,
+	backends:       []string{BackendVxlan, BackendHostGw},
+	mtu:            true,
+	iface:          true,
+	images:         []string{"calico-node", "calico-cni", "flannel"},
+	cniConfigs:     []string{"10-calico.conf", "calico-kubeconfig"},
+}
+
+// CanalNetworkProvider  - a struct to represent the concrete implementation of a Canal network.Provider
+type CanalNetworkProvider struct {
+	cfg Config
+}
 
 // NewCanalNetworkProvider - a factory method to initialise and return a Canal specific network.Provider
-func NewCanalNetworkProvider() (Provider) {
-	return &CanalNetworkProvider{}
+func NewCanalNetworkProvider(cfg Config) (Provider, error) {
+	if err := canalOptions.validate(cfg); err != nil {
+		return nil, err
+	}
+	return &CanalNetworkProvider{cfg: cfg}, nil
 }
 
 // Name - will return the Canal NetworkProvider name
 func (fnp *CanalNetworkProvider) Name() string {
-	return "canal"
+	return canalOptions.name
 }
 
-// PodNetworkCidr - will return the Canal NetworkProvider name
+// PodNetworkCidr - will return the Canal pod network CIDR
 func (fnp *CanalNetworkProvider) PodNetworkCidr() string {
-	return canalPodCidr
+	return canalOptions.data(fnp.cfg).Network
+}
+
+// CNIConfigs - will return the Canal CNI config files
+func (fnp *CanalNetworkProvider) CNIConfigs() []string {
+	return canalOptions.cniConfigs
 }
 
 // Create - will create the K8 network resources (Canal)
-func (fnp *CanalNetworkProvider) Create() (error) {
-	return renderandDeploy(canalPodCidr, canalYaml)
+func (fnp *CanalNetworkProvider) Create(a k8client.Applier) error {
+	return deploy(a, fnp)
+}
+
+// Manifest - will return the K8 network resources (Canal)
+func (fnp *CanalNetworkProvider) Manifest() (string, error) {
+	return renderTemplate(fnp.Name(), canalYaml, canalOptions.data(fnp.cfg), fnp.cfg.Images)
 }
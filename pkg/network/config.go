@@ -0,0 +1,218 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/etcd"
+)
+
+// The backends used to carry traffic between nodes
+const (
+	BackendVxlan  = "vxlan"
+	BackendHostGw = "host-gw"
+	BackendIPIP   = "ipip"
+	BackendBGP    = "bgp"
+)
+
+// The IPIP modes (when traffic is encapsulated)
+const (
+	IPIPModeAlways      = "always"
+	IPIPModeCrossSubnet = "cross-subnet"
+)
+
+// The datastores network state can be kept in
+const (
+	DatastoreKubernetes = "kubernetes"
+	DatastoreEtcd       = "etcd"
+)
+
+// Config is the configuration of a network provider
+// Any option not set uses the providers default.
+type Config struct {
+	// PodNetworkCidr is the range pod IPs are allocated from
+	PodNetworkCidr string
+	// MTU of the pod network interfaces
+	MTU int
+	// Backend is how traffic is carried between nodes (vxlan / host-gw / ipip / bgp)
+	Backend string
+	// IPPools are extra ranges pod IPs can be allocated from
+	IPPools []string
+	// IPIPMode is when traffic is encapsulated by the ipip backend (always / cross-subnet)
+	IPIPMode string
+	// Datastore is where the network state is kept (kubernetes / etcd)
+	Datastore string
+	// Etcd is the etcd cluster used by the etcd datastore (with a client cert issued to the network provider)
+	Etcd etcd.Client
+	// Interface is the host interface used between nodes (the interface of the default route when blank)
+	Interface string
+	// Images replaces the default container images by name (e.g. flannel=my.registry/flannel:v0.7.1)
+	Images map[string]string
+}
+
+// TemplateData is the configuration available to network manifest templates
+// Images are available with the image function e.g. {{ image "flannel" "quay.io/coreos/flannel:v0.7.1" }}
+// and the hash function returns a short hash of its arguments e.g. to name objects which can't be updated.
+type TemplateData struct {
+	Network   string
+	MTU       int
+	Backend   string
+	Interface string
+	// IPPools are all the ranges pod IPs are allocated from (the pod network first)
+	IPPools   []string
+	IPIPMode  string
+	Datastore string
+	Etcd      EtcdData
+}
+
+// EtcdData is the etcd datastore connection (with the contents of any client certs base64 encoded)
+type EtcdData struct {
+	Endpoints string
+	CA        string
+	Cert      string
+	Key       string
+}
+
+// hashLength is the number of hex characters returned by the hash template function
+const hashLength = 10
+
+// options describes the options a provider supports (and its defaults)
+type options struct {
+	name           string
+	podNetworkCidr string
+	backends       []string
+	mtu            bool
+	iface          bool
+	images         []string
+	ipPools        bool
+	ipipModes      []string
+	datastores     []string
+	cniConfigs     []string
+}
+
+// ParseImages parses image overrides from a list of name=image
+func ParseImages(images []string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, image := range images {
+		parts := strings.SplitN(image, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("Invalid network image %q, must be name=image", image)
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
+
+// validate checks only the options a provider supports are set
+func (o options) validate(cfg Config) error {
+	if len(cfg.PodNetworkCidr) > 0 {
+		if _, _, err := net.ParseCIDR(cfg.PodNetworkCidr); err != nil {
+			return fmt.Errorf("Invalid pod network CIDR %q for %s [%v]", cfg.PodNetworkCidr, o.name, err)
+		}
+	}
+	if cfg.MTU < 0 || (cfg.MTU > 0 && !o.mtu) {
+		return fmt.Errorf("The MTU can't be set for %s", o.name)
+	}
+	if len(cfg.Interface) > 0 && !o.iface {
+		return fmt.Errorf("The network interface can't be set for %s", o.name)
+	}
+	if err := validateChoice(o.name, "network backend", cfg.Backend, o.backends); err != nil {
+		return err
+	}
+	for _, pool := range cfg.IPPools {
+		if !o.ipPools {
+			return fmt.Errorf("IP pools can't be set for %s", o.name)
+		}
+		if _, _, err := net.ParseCIDR(pool); err != nil {
+			return fmt.Errorf("Invalid IP pool %q for %s [%v]", pool, o.name, err)
+		}
+	}
+	if err := validateChoice(o.name, "IPIP mode", cfg.IPIPMode, o.ipipModes); err != nil {
+		return err
+	}
+	if err := validateChoice(o.name, "datastore", cfg.Datastore, o.datastores); err != nil {
+		return err
+	}
+	for name := range cfg.Images {
+		if !contains(o.images, name) {
+			return fmt.Errorf("Unknown image %q for %s. Must be one of: %s", name, o.name, strings.Join(o.images, ", "))
+		}
+	}
+	return nil
+}
+
+// data returns the template data for a config (using the defaults for any option not set)
+func (o options) data(cfg Config) TemplateData {
+	data := TemplateData{
+		Network:   cfg.PodNetworkCidr,
+		MTU:       cfg.MTU,
+		Backend:   cfg.Backend,
+		Interface: cfg.Interface,
+	}
+	if len(data.Network) == 0 {
+		data.Network = o.podNetworkCidr
+	}
+	if len(data.Backend) == 0 && len(o.backends) > 0 {
+		data.Backend = o.backends[0]
+	}
+	if o.ipPools {
+		data.IPPools = append([]string{data.Network}, cfg.IPPools...)
+	}
+	data.IPIPMode = cfg.IPIPMode
+	if len(data.IPIPMode) == 0 && len(o.ipipModes) > 0 {
+		data.IPIPMode = o.ipipModes[0]
+	}
+	data.Datastore = cfg.Datastore
+	if len(data.Datastore) == 0 && len(o.datastores) > 0 {
+		data.Datastore = o.datastores[0]
+	}
+	return data
+}
+
+// validateChoice checks an option (when set) is one of the choices a provider supports
+func validateChoice(provider, option, value string, choices []string) error {
+	if len(value) == 0 || contains(choices, value) {
+		return nil
+	}
+	if len(choices) == 0 {
+		return fmt.Errorf("The %s can't be set for %s", option, provider)
+	}
+	return fmt.Errorf("Invalid %s %q for %s. Must be one of: %s", option, value, provider, strings.Join(choices, ", "))
+}
+
+// renderTemplate renders a manifest template with the template data and image overrides
+func renderTemplate(name, manifest string, data TemplateData, images map[string]string) (string, error) {
+	funcs := template.FuncMap{
+		"image": func(name, image string) string {
+			if override, ok := images[name]; ok {
+				return override
+			}
+			return image
+		},
+		"hash": func(values ...interface{}) string {
+			return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprint(values...))))[:hashLength]
+		},
+	}
+	t, err := template.New(name).Funcs(funcs).Parse(manifest)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing %s manifest [%v]", name, err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("Error rendering %s manifest [%v]", name, err)
+	}
+	return b.String(), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	cfg := Config{
+		PodNetworkCidr: "172.16.0.0/16",
+		MTU:            8981,
+		Backend:        BackendHostGw,
+		Interface:      "eth1",
+		Images:         map[string]string{"flannel": "my.registry/flannel:v0.7.1"},
+	}
+	np, err := CreateProvider("canal", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.PodNetworkCidr() != cfg.PodNetworkCidr {
+		t.Errorf("expected pod network CIDR %q but got %q", cfg.PodNetworkCidr, np.PodNetworkCidr())
+	}
+	manifest, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"Network": "172.16.0.0/16"`,
+		`"Type": "host-gw"`,
+		`"mtu": 8981,`,
+		`canal_iface: "eth1"`,
+		"image: my.registry/flannel:v0.7.1",
+		"image: quay.io/calico/node:v1.2.1",
+	} {
+		if !strings.Contains(manifest, expected) {
+			t.Errorf("expected %q in the canal manifest", expected)
+		}
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	np, err := CreateProvider("flannel", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.PodNetworkCidr() != flannelPodCidr {
+		t.Errorf("expected default pod network CIDR %q but got %q", flannelPodCidr, np.PodNetworkCidr())
+	}
+	manifest, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(manifest, `"Type": "vxlan"`) || strings.Contains(manifest, "--iface") {
+		t.Errorf("expected the default flannel backend and interface")
+	}
+}
+
+func TestWeaveConfig(t *testing.T) {
+	np, err := CreateProvider("weave", Config{PodNetworkCidr: "172.16.0.0/16", MTU: 1376})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// kubeadm must never allocate node CIDRs for weave
+	if np.PodNetworkCidr() != weavePodCidr {
+		t.Errorf("expected pod network CIDR %q for kubeadm but got %q", weavePodCidr, np.PodNetworkCidr())
+	}
+	manifest, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"IPALLOC_RANGE", `value: "172.16.0.0/16"`, "WEAVE_MTU", `value: "1376"`} {
+		if !strings.Contains(manifest, expected) {
+			t.Errorf("expected %q in the weave manifest", expected)
+		}
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	invalid := map[string]Config{
+		"flannel": {MTU: 1400},
+		"weave":   {Backend: BackendVxlan},
+		"canal":   {Backend: BackendIPIP},
+	}
+	for name, cfg := range invalid {
+		if _, err := CreateProvider(name, cfg); err == nil {
+			t.Errorf("expected an error for %s with %+v", name, cfg)
+		}
+	}
+	for _, cfg := range []Config{
+		{PodNetworkCidr: "10.244.0.0"},
+		{Interface: "eth1", Images: map[string]string{"weave-kube": "weave"}},
+	} {
+		if _, err := CreateProvider("flannel", cfg); err == nil {
+			t.Errorf("expected an error for flannel with %+v", cfg)
+		}
+	}
+}
+
+func TestStaleCNIConfigs(t *testing.T) {
+	providers := map[string]Provider{}
+	for _, name := range []string{"flannel", "canal", "calico"} {
+		np, err := CreateProvider(name, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers[name] = np
+	}
+	if stale := StaleCNIConfigs(providers["flannel"], providers["canal"]); len(stale) != 1 || stale[0] != "10-flannel.conf" {
+		t.Errorf("expected the flannel CNI config to be stale but got %v", stale)
+	}
+	if stale := StaleCNIConfigs(providers["canal"], providers["calico"]); len(stale) != 0 {
+		t.Errorf("expected no stale CNI configs sharing names but got %v", stale)
+	}
+}
+
+func TestParseImages(t *testing.T) {
+	images, err := ParseImages([]string{"flannel=my.registry/flannel:v0.7.1"})
+	if err != nil || images["flannel"] != "my.registry/flannel:v0.7.1" {
+		t.Errorf("unexpected images %v, error:%v", images, err)
+	}
+	if _, err := ParseImages([]string{"flannel"}); err == nil {
+		t.Error("expected an error for an image without a name")
+	}
+}
@@ -0,0 +1,177 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
+	"github.com/ghodss/yaml"
+)
+
+// MetadataFileName is the metadata file of an external provider directory
+const MetadataFileName = "provider.yaml"
+
+// ExternalMetadata describes an external network provider and the options its manifests support
+type ExternalMetadata struct {
+	Name           string `json:"name"`
+	PodNetworkCidr string `json:"podNetworkCidr,omitempty"`
+	// Manifests is a file or directory of manifest templates (relative to the metadata file)
+	// The default is every other yaml file in the directory of the metadata file.
+	Manifests string   `json:"manifests,omitempty"`
+	Backends  []string `json:"backends,omitempty"`
+	MTU       bool     `json:"mtu,omitempty"`
+	Interface bool     `json:"interface,omitempty"`
+	Images    []string `json:"images,omitempty"`
+	// CNIConfigs are the files written to the CNI config directory of each node (removed when migrating away)
+	CNIConfigs []string `json:"cniConfigs,omitempty"`
+}
+
+// ExternalNetworkProvider - a network.Provider deploying user supplied manifest templates
+type ExternalNetworkProvider struct {
+	options  options
+	manifest string
+	cfg      Config
+}
+
+// LoadExternalProvider - will register a network.Provider from a metadata file (or a directory containing one)
+// The manifests are validated by rendering them with the default configuration.
+func LoadExternalProvider(path string) (name string, err error) {
+	metadataFile := path
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("Error loading network provider [%v]", err)
+	}
+	if info.IsDir() {
+		metadataFile = filepath.Join(path, MetadataFileName)
+	}
+	content, err := ioutil.ReadFile(metadataFile)
+	if err != nil {
+		return "", fmt.Errorf("Error loading network provider [%v]", err)
+	}
+	var md ExternalMetadata
+	if err = yaml.Unmarshal(content, &md); err != nil {
+		return "", fmt.Errorf("Error parsing network provider metadata %q [%v]", metadataFile, err)
+	}
+	if len(md.Name) == 0 {
+		return "", fmt.Errorf("Network provider metadata %q must have a name", metadataFile)
+	}
+	if _, registered := Factories[md.Name]; registered {
+		return "", fmt.Errorf("Network provider %q loaded from %q is already registered", md.Name, path)
+	}
+	for _, file := range md.CNIConfigs {
+		if file != filepath.Base(file) || file == "." || file == ".." {
+			return "", fmt.Errorf("Invalid CNI config %q for network provider %q, must be a file name", file, md.Name)
+		}
+	}
+	manifest, err := readManifests(metadataFile, md.Manifests)
+	if err != nil {
+		return "", err
+	}
+
+	o := options{
+		name:           md.Name,
+		podNetworkCidr: md.PodNetworkCidr,
+		backends:       md.Backends,
+		mtu:            md.MTU,
+		iface:          md.Interface,
+		images:         md.Images,
+		cniConfigs:     md.CNIConfigs,
+	}
+	factory := func(cfg Config) (Provider, error) {
+		if err := o.validate(cfg); err != nil {
+			return nil, err
+		}
+		return &ExternalNetworkProvider{options: o, manifest: manifest, cfg: cfg}, nil
+	}
+	np, err := factory(Config{PodNetworkCidr: md.PodNetworkCidr})
+	if err != nil {
+		return "", err
+	}
+	rendered, err := np.Manifest()
+	if err != nil {
+		return "", err
+	}
+	objs, err := k8client.Decode(rendered)
+	if err != nil {
+		return "", fmt.Errorf("Invalid manifests for network provider %q [%v]", md.Name, err)
+	}
+	if len(objs) == 0 {
+		return "", fmt.Errorf("No resources in the manifests for network provider %q", md.Name)
+	}
+	Register(factory)
+	log.Printf("Loaded network provider %q from %q", md.Name, path)
+	return md.Name, nil
+}
+
+// Name - will return the external NetworkProvider name (from its metadata)
+func (enp *ExternalNetworkProvider) Name() string {
+	return enp.options.name
+}
+
+// PodNetworkCidr - will return the external NetworkProvider pod network CIDR
+func (enp *ExternalNetworkProvider) PodNetworkCidr() string {
+	return enp.options.data(enp.cfg).Network
+}
+
+// CNIConfigs - will return the external NetworkProvider CNI config files (from its metadata)
+func (enp *ExternalNetworkProvider) CNIConfigs() []string {
+	return enp.options.cniConfigs
+}
+
+// Create - will create the K8 network resources (from the external manifests)
+func (enp *ExternalNetworkProvider) Create(a k8client.Applier) error {
+	return deploy(a, enp)
+}
+
+// Manifest - will return the K8 network resources (from the external manifests)
+func (enp *ExternalNetworkProvider) Manifest() (string, error) {
+	return renderTemplate(enp.Name(), enp.manifest, enp.options.data(enp.cfg), enp.cfg.Images)
+}
+
+// readManifests reads the manifest templates for a metadata file
+// Every yaml file in a directory is used (in name order) except the metadata file.
+func readManifests(metadataFile, manifests string) (string, error) {
+	path := filepath.Dir(metadataFile)
+	if len(manifests) > 0 {
+		path = manifests
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(metadataFile), path)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("Error loading network provider manifests [%v]", err)
+	}
+	if !info.IsDir() {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("Error loading network provider manifests [%v]", err)
+		}
+		return string(content), nil
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return "", fmt.Errorf("Error loading network provider manifests [%v]", err)
+	}
+	var docs []string
+	for _, file := range files {
+		name := filepath.Join(path, file.Name())
+		ext := filepath.Ext(name)
+		if file.IsDir() || (ext != ".yaml" && ext != ".yml") || name == filepath.Clean(metadataFile) {
+			continue
+		}
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("Error loading network provider manifests [%v]", err)
+		}
+		docs = append(docs, string(content))
+	}
+	if len(docs) == 0 {
+		return "", fmt.Errorf("No network provider manifests found in %q", path)
+	}
+	return strings.Join(docs, "\n---\n"), nil
+}
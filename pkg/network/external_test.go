@@ -0,0 +1,133 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMetadata = `
+name: test-cni
+podNetworkCidr: 172.20.0.0/16
+mtu: true
+images:
+- agent
+cniConfigs:
+- 10-test.conf
+`
+
+const testManifest = `
+apiVersion: extensions/v1beta1
+kind: DaemonSet
+metadata:
+  name: test-cni
+  namespace: kube-system
+spec:
+  template:
+    spec:
+      containers:
+      - name: agent
+        image: {{ image "agent" "example.com/agent:v1" }}
+        env:
+        - name: POD_CIDR
+          value: "{{ .Network }}"
+        - name: MTU
+          value: "{{ .MTU }}"
+`
+
+func testProviderDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "network-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadExternalProvider(t *testing.T) {
+	dir := testProviderDir(t, map[string]string{
+		MetadataFileName: testMetadata,
+		"daemonset.yaml": testManifest,
+		"README.md":      "not a manifest",
+	})
+	defer os.RemoveAll(dir)
+	defer delete(Factories, "test-cni")
+
+	name, err := LoadExternalProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "test-cni" {
+		t.Errorf("expected provider name from the metadata but got %q", name)
+	}
+	np, err := CreateProvider(name, Config{MTU: 8981, Images: map[string]string{"agent": "my.registry/agent:v2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.PodNetworkCidr() != "172.20.0.0/16" {
+		t.Errorf("expected the default pod network CIDR from the metadata but got %q", np.PodNetworkCidr())
+	}
+	manifest, err := np.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"image: my.registry/agent:v2", `value: "172.20.0.0/16"`, `value: "8981"`} {
+		if !strings.Contains(manifest, expected) {
+			t.Errorf("expected %q in the manifest but got:\n%s", expected, manifest)
+		}
+	}
+
+	if configs := np.CNIConfigs(); len(configs) != 1 || configs[0] != "10-test.conf" {
+		t.Errorf("expected the CNI configs from the metadata but got %v", configs)
+	}
+
+	// Options not in the metadata aren't supported
+	if _, err := CreateProvider(name, Config{Backend: BackendVxlan}); err == nil {
+		t.Error("expected an error for an unsupported backend")
+	}
+	// Names are unique
+	if _, err := LoadExternalProvider(dir); err == nil {
+		t.Error("expected an error loading a provider twice")
+	}
+}
+
+func TestLoadExternalProviderFile(t *testing.T) {
+	dir := testProviderDir(t, map[string]string{
+		"test.yaml":    "name: test-cni-file\nmanifests: cni.tmpl\n",
+		"cni.tmpl":     testManifest,
+		"ignored.yaml": "not: a manifest",
+	})
+	defer os.RemoveAll(dir)
+	defer delete(Factories, "test-cni-file")
+
+	if _, err := LoadExternalProvider(filepath.Join(dir, "test.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateProvider("test-cni-file", Config{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadExternalProviderInvalid(t *testing.T) {
+	for _, files := range []map[string]string{
+		{MetadataFileName: "podNetworkCidr: 172.20.0.0/16\n", "cni.yaml": testManifest},
+		{MetadataFileName: "name: flannel\n", "cni.yaml": testManifest},
+		{MetadataFileName: "name: test-invalid\n", "cni.yaml": "{{ .Unknown }}"},
+		{MetadataFileName: "name: test-invalid\npodNetworkCidr: 172.20.0.0\n", "cni.yaml": testManifest},
+		{MetadataFileName: "name: test-invalid\ncniConfigs: [../10-test.conf]\n", "cni.yaml": testManifest},
+		{MetadataFileName: "name: test-invalid\n"},
+	} {
+		dir := testProviderDir(t, files)
+		if name, err := LoadExternalProvider(dir); err == nil {
+			t.Errorf("expected an error loading %v", files)
+			delete(Factories, name)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -1,26 +1,52 @@
 package network
 
+import "github.com/UKHomeOffice/keto-k8/pkg/k8client"
+
 const flannelPodCidr = "10.244.0.0/16"
 
+var flannelOptions = options{
+	name:           "flannel",
+	podNetworkCidr: flannelPodCidr,
+	backends:       []string{BackendVxlan, BackendHostGw},
+	iface:          true,
+	images:         []string{"flannel"},
+	cniConfigs:     []string{"10-flannel.conf"},
+}
+
 // FlannelNetworkProvider - a struct to represent the concrete implementation of a Flannel NetworkProvider
-type FlannelNetworkProvider struct {}
+type FlannelNetworkProvider struct {
+	cfg Config
+}
 
 // NewFlannelNetworkProvider - a factory method to initialise and return a Flannel specific NetworkProvider
-func NewFlannelNetworkProvider() (Provider) {
-	return &FlannelNetworkProvider{}
+func NewFlannelNetworkProvider(cfg Config) (Provider, error) {
+	if err := flannelOptions.validate(cfg); err != nil {
+		return nil, err
+	}
+	return &FlannelNetworkProvider{cfg: cfg}, nil
 }
 
 // Name - will return the Flannel NetworkProvider name
 func (fnp *FlannelNetworkProvider) Name() string {
-	return "flannel"
+	return flannelOptions.name
 }
 
-// PodNetworkCidr - will return the Canal NetworkProvider name
+// PodNetworkCidr - will return the Flannel pod network CIDR
 func (fnp *FlannelNetworkProvider) PodNetworkCidr() string {
-	return flannelPodCidr
+	return flannelOptions.data(fnp.cfg).Network
+}
+
+// CNIConfigs - will return the Flannel CNI config files
+func (fnp *FlannelNetworkProvider) CNIConfigs() []string {
+	return flannelOptions.cniConfigs
 }
 
 // Create - will create the K8 network resources
-func (fnp *FlannelNetworkProvider) Create() (error) {
-	return renderandDeploy(flannelPodCidr, flannelYaml)
+func (fnp *FlannelNetworkProvider) Create(a k8client.Applier) error {
+	return deploy(a, fnp)
+}
+
+// Manifest - will return the K8 network resources
+func (fnp *FlannelNetworkProvider) Manifest() (string, error) {
+	return renderTemplate(fnp.Name(), flannelYaml, flannelOptions.data(fnp.cfg), fnp.cfg.Images)
 }
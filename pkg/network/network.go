@@ -1,24 +1,30 @@
 package network
 
 import (
-	"bytes"
 	"fmt"
 	"strings"
-	"text/template"
 
-	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
 	log "github.com/Sirupsen/logrus"
+	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
 )
 
 // Provider is an abstract interface for Network.
 type Provider interface {
 	Name() string
-	Create() error
+	Create(a k8client.Applier) error
+	// Manifest returns the resources created
+	Manifest() (string, error)
 	PodNetworkCidr() string
+	// CNIConfigs are the files the provider writes to the CNI config directory of each node
+	CNIConfigs() []string
 }
 
+// Component labels the network resources so any no longer required are pruned (even if the provider changes)
+const Component = "network"
+
 // ProviderFactory - Interface definition for a network.provider implementation
-type ProviderFactory func() (Provider)
+// A factory must accept an empty Config (all defaults) but return an error for any option it doesn't support.
+type ProviderFactory func(cfg Config) (Provider, error)
 
 // Factories - a map of provider creation factory implementations stored by name
 var Factories = make(map[string]ProviderFactory)
@@ -29,7 +35,11 @@ func Register(factory ProviderFactory) {
 	if factory == nil {
 		log.Panicf("NetworkProvider factory does not exist.")
 	}
-	name := factory().Name()
+	p, err := factory(Config{})
+	if err != nil {
+		log.Panicf("NetworkProvider factory failed with default config: %v", err)
+	}
+	name := p.Name()
 	_, registered := Factories[name]
 	if registered {
 		log.Errorf("Datastore factory %s already registered. Ignoring.", name)
@@ -37,8 +47,8 @@ func Register(factory ProviderFactory) {
 	Factories[name] = factory
 }
 
-// CreateProvider - will return a network.Provider implementation from a name
-func CreateProvider(networkProvider string) (Provider, error) {
+// CreateProvider - will return a network.Provider implementation from a name and configuration
+func CreateProvider(networkProvider string, cfg Config) (Provider, error) {
 	networkProviderFactory, ok := Factories[networkProvider]
 	if !ok {
 		// Factory has not been registered.
@@ -50,7 +60,7 @@ func CreateProvider(networkProvider string) (Provider, error) {
 		return nil,
 			fmt.Errorf("Invalid NetworkProvider name. Must be one of: %s", strings.Join(availableProviders, ", "))
 	}
-	return networkProviderFactory(), nil
+	return networkProviderFactory(cfg)
 }
 
 // Will register providers and set a default provider...
@@ -58,28 +68,24 @@ func init() {
 	Register(NewFlannelNetworkProvider)
 	Register(NewWeaveNetworkProvider)
 	Register(NewCanalNetworkProvider)
+	Register(NewCalicoNetworkProvider)
 }
 
-func renderandDeploy(podNetworkCidr, cniYaml string) (error) {
-	k8Definition, err := renderCniYaml(podNetworkCidr, cniYaml)
-	if err != nil {
-		return err
+// StaleCNIConfigs - will return the CNI config files written by a provider which aren't written by its replacement
+func StaleCNIConfigs(from, to Provider) []string {
+	var stale []string
+	for _, file := range from.CNIConfigs() {
+		if !contains(to.CNIConfigs(), file) {
+			stale = append(stale, file)
+		}
 	}
-	return k8client.Apply(string(k8Definition[:]))
+	return stale
 }
 
-// Grab the resources for deploying a network
-func renderCniYaml(podNetworkCidr, cniYaml string) ([]byte, error) {
-	data := struct {
-		Network	string
-	}{
-		Network: podNetworkCidr,
-	}
-	t := template.Must(template.New("cniYaml").Parse(cniYaml))
-	var b bytes.Buffer
-	if err := t.Execute(&b, data); err != nil {
-		return b.Bytes(), err
+func deploy(a k8client.Applier, np Provider) error {
+	k8Definition, err := np.Manifest()
+	if err != nil {
+		return err
 	}
-
-	return b.Bytes(), nil
+	return k8client.ApplyAndPrune(a, Component, k8Definition)
 }
@@ -0,0 +1,54 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
+	k8clientMocks "github.com/UKHomeOffice/keto-k8/pkg/k8client/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreate(t *testing.T) {
+	for name := range Factories {
+		np, err := CreateProvider(name, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := &k8clientMocks.Applier{}
+		a.On("Apply", mock.AnythingOfType("string")).Return(nil)
+		a.On("Prune", Component, mock.AnythingOfType("string")).Return(nil)
+		if err := np.Create(a); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		a.AssertNumberOfCalls(t, "Apply", 1)
+		objs, err := k8client.Decode(a.Calls[0].Arguments.String(0))
+		if err != nil {
+			t.Errorf("%s: expected valid resources but got %v", name, err)
+		}
+		if len(objs) == 0 {
+			t.Errorf("%s: expected resources to be applied", name)
+		}
+		for _, obj := range objs {
+			if obj.GetLabels()[k8client.ComponentLabel] != Component {
+				t.Errorf("%s: expected %s/%s labelled as the %s component", name, obj.GetKind(), obj.GetName(), Component)
+			}
+		}
+		a.AssertCalled(t, "Prune", Component, a.Calls[0].Arguments.String(0))
+	}
+}
+
+func TestCreateError(t *testing.T) {
+	applyErr := errors.New("forbidden")
+	a := &k8clientMocks.Applier{}
+	a.On("Apply", mock.AnythingOfType("string")).Return(applyErr)
+	np, err := NewFlannelNetworkProvider(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := np.Create(a); err != applyErr {
+		t.Errorf("expected %v but got %v", applyErr, err)
+	}
+	// Nothing is pruned unless all the resources are applied
+	a.AssertNotCalled(t, "Prune", Component, mock.AnythingOfType("string"))
+}
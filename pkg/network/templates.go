@@ -71,7 +71,7 @@ data:
     {
       "Network": "{{ .Network }}",
       "Backend": {
-        "Type": "vxlan"
+        "Type": "{{ .Backend }}"
       }
     }
 ---
@@ -100,8 +100,8 @@ spec:
       serviceAccountName: flannel
       containers:
       - name: kube-flannel
-        image: quay.io/coreos/flannel:v0.7.1-amd64
-        command: [ "/opt/bin/flanneld", "--ip-masq", "--kube-subnet-mgr" ]
+        image: {{ image "flannel" "quay.io/coreos/flannel:v0.7.1-amd64" }}
+        command: [ "/opt/bin/flanneld", "--ip-masq", "--kube-subnet-mgr"{{ if .Interface }}, "--iface={{ .Interface }}"{{ end }} ]
         securityContext:
           privileged: true
         env:
@@ -119,7 +119,7 @@ spec:
         - name: flannel-cfg
           mountPath: /etc/kube-flannel/
       - name: install-cni
-        image: quay.io/coreos/flannel:v0.7.1-amd64
+        image: {{ image "flannel" "quay.io/coreos/flannel:v0.7.1-amd64" }}
         command: [ "/bin/sh", "-c", "set -e -x; cp -f /etc/kube-flannel/cni-conf.json /etc/cni/net.d/10-flannel.conf; while true; do sleep 3600; done" ]
         volumeMounts:
         - name: cni
@@ -275,7 +275,7 @@ data:
   # The interface used by canal for host <-> host communication.
   # If left blank, then the interface is chosen using the node's
   # default route.
-  canal_iface: ""
+  canal_iface: "{{ .Interface }}"
 
   # Whether or not to masquerade traffic to destinations not within
   # the pod network.
@@ -287,7 +287,8 @@ data:
         "name": "k8s-pod-network",
         "type": "calico",
         "log_level": "info",
-        "datastore_type": "kubernetes",
+        "datastore_type": "kubernetes",{{ if .MTU }}
+        "mtu": {{ .MTU }},{{ end }}
         "hostname": "__KUBERNETES_NODE_NAME__",
         "ipam": {
             "type": "host-local",
@@ -308,7 +309,7 @@ data:
     {
       "Network": "{{ .Network }}",
       "Backend": {
-        "Type": "vxlan"
+        "Type": "{{ .Backend }}"
       }
     }
 
@@ -350,7 +351,7 @@ spec:
         # container programs network policy and routes on each
         # host.
         - name: calico-node
-          image: quay.io/calico/node:v1.2.1
+          image: {{ image "calico-node" "quay.io/calico/node:v1.2.1" }}
           env:
             # Use Kubernetes API as the backing datastore.
             - name: DATASTORE_TYPE
@@ -397,7 +398,7 @@ spec:
         # This container installs the Calico CNI binaries
         # and CNI network config file on each node.
         - name: install-cni
-          image: quay.io/calico/cni:v1.8.3
+          image: {{ image "calico-cni" "quay.io/calico/cni:v1.8.3" }}
           command: ["/install-cni.sh"]
           env:
             # The CNI network config to install on each node.
@@ -418,7 +419,7 @@ spec:
         # This container runs flannel using the kube-subnet-mgr backend
         # for allocating subnets.
         - name: kube-flannel
-          image: quay.io/coreos/flannel:v0.7.1
+          image: {{ image "flannel" "quay.io/coreos/flannel:v0.7.1" }}
           command: [ "/opt/bin/flanneld", "--ip-masq", "--kube-subnet-mgr" ]
           securityContext:
             privileged: true
@@ -537,9 +538,20 @@ spec:
       hostPID: true
       containers:
         - name: weave
-          image: weaveworks/weave-kube:1.9.5
+          image: {{ image "weave-kube" "weaveworks/weave-kube:1.9.5" }}
           command:
             - /home/weave/launch.sh
+{{- if or .Network .MTU }}
+          env:
+{{- if .Network }}
+            - name: IPALLOC_RANGE
+              value: "{{ .Network }}"
+{{- end }}
+{{- if .MTU }}
+            - name: WEAVE_MTU
+              value: "{{ .MTU }}"
+{{- end }}
+{{- end }}
           livenessProbe:
             initialDelaySeconds: 30
             httpGet:
@@ -565,7 +577,7 @@ spec:
             requests:
               cpu: 10m
         - name: weave-npc
-          image: weaveworks/weave-npc:1.9.5
+          image: {{ image "weave-npc" "weaveworks/weave-npc:1.9.5" }}
           resources:
             requests:
               cpu: 10m
@@ -599,3 +611,612 @@ spec:
           hostPath:
             path: /lib/modules
 `
+
+const calicoYaml = `# Based on https://docs.projectcalico.org/v2.5/getting-started/kubernetes/installation/hosted/
+# (calico.yaml for the etcd datastore and kubernetes-datastore/calico-networking for the kubernetes datastore)
+kind: ConfigMap
+apiVersion: v1
+metadata:
+  name: calico-config
+  namespace: kube-system
+data:
+{{- if eq .Datastore "etcd" }}
+  # The location of the etcd cluster.
+  etcd_endpoints: "{{ .Etcd.Endpoints }}"
+  # The etcd client certs (mounted from the calico-etcd-secrets secret).
+  etcd_ca: "{{ if .Etcd.CA }}/calico-secrets/etcd-ca{{ end }}"
+  etcd_cert: "{{ if .Etcd.Cert }}/calico-secrets/etcd-cert{{ end }}"
+  etcd_key: "{{ if .Etcd.Key }}/calico-secrets/etcd-key{{ end }}"
+{{- end }}
+  # Configure the Calico backend to use.
+  calico_backend: "bird"
+
+  # The CNI network configuration to install on each node.
+  cni_network_config: |-
+    {
+        "name": "k8s-pod-network",
+        "cniVersion": "0.1.0",
+        "type": "calico",
+        "log_level": "info",{{ if .MTU }}
+        "mtu": {{ .MTU }},{{ end }}
+{{- if eq .Datastore "etcd" }}
+        "etcd_endpoints": "__ETCD_ENDPOINTS__",
+        "etcd_key_file": "__ETCD_KEY_FILE__",
+        "etcd_cert_file": "__ETCD_CERT_FILE__",
+        "etcd_ca_cert_file": "__ETCD_CA_CERT_FILE__",
+        "ipam": {
+            "type": "calico-ipam"
+        },
+{{- else }}
+        "datastore_type": "kubernetes",
+        "nodename": "__KUBERNETES_NODE_NAME__",
+        "ipam": {
+            "type": "host-local",
+            "subnet": "usePodCidr"
+        },
+{{- end }}
+        "policy": {
+            "type": "k8s",
+            "k8s_auth_token": "__SERVICEACCOUNT_TOKEN__"
+        },
+        "kubernetes": {
+            "k8s_api_root": "https://__KUBERNETES_SERVICE_HOST__:__KUBERNETES_SERVICE_PORT__",
+            "kubeconfig": "__KUBECONFIG_FILEPATH__"
+        }
+    }
+
+  # The IP pools (applied by the calico-ippools job).
+  ippools.yaml: |
+{{- range .IPPools }}
+    - apiVersion: v1
+      kind: ipPool
+      metadata:
+        cidr: {{ . }}
+      spec:
+        ipip:
+          enabled: {{ if eq $.IPIPMode "off" }}false{{ else }}true{{ end }}
+{{- if ne $.IPIPMode "off" }}
+          mode: {{ $.IPIPMode }}
+{{- end }}
+        nat-outgoing: true
+{{- end }}
+{{- if eq .Datastore "etcd" }}
+
+---
+
+# The etcd client certs used by all calico components.
+apiVersion: v1
+kind: Secret
+type: Opaque
+metadata:
+  name: calico-etcd-secrets
+  namespace: kube-system
+data:
+{{- if .Etcd.CA }}
+  etcd-ca: {{ .Etcd.CA }}
+{{- end }}
+{{- if .Etcd.Cert }}
+  etcd-cert: {{ .Etcd.Cert }}
+{{- end }}
+{{- if .Etcd.Key }}
+  etcd-key: {{ .Etcd.Key }}
+{{- end }}
+{{- else }}
+
+---
+
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: globalfelixconfigs.crd.projectcalico.org
+spec:
+  scope: Cluster
+  group: crd.projectcalico.org
+  version: v1
+  names:
+    kind: GlobalFelixConfig
+    plural: globalfelixconfigs
+    singular: globalfelixconfig
+
+---
+
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: globalbgpconfigs.crd.projectcalico.org
+spec:
+  scope: Cluster
+  group: crd.projectcalico.org
+  version: v1
+  names:
+    kind: GlobalBGPConfig
+    plural: globalbgpconfigs
+    singular: globalbgpconfig
+
+---
+
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: ippools.crd.projectcalico.org
+spec:
+  scope: Cluster
+  group: crd.projectcalico.org
+  version: v1
+  names:
+    kind: IPPool
+    plural: ippools
+    singular: ippool
+
+---
+
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: globalnetworkpolicies.crd.projectcalico.org
+spec:
+  scope: Cluster
+  group: crd.projectcalico.org
+  version: v1
+  names:
+    kind: GlobalNetworkPolicy
+    plural: globalnetworkpolicies
+    singular: globalnetworkpolicy
+{{- end }}
+
+---
+
+kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1beta1
+metadata:
+  name: calico-node
+rules:
+  - apiGroups: [""]
+    resources:
+      - namespaces
+    verbs:
+      - get
+      - list
+      - watch
+  - apiGroups: [""]
+    resources:
+      - pods/status
+    verbs:
+      - update
+  - apiGroups: [""]
+    resources:
+      - pods
+    verbs:
+      - get
+      - list
+      - watch
+  - apiGroups: [""]
+    resources:
+      - nodes
+    verbs:
+      - get
+      - list
+      - update
+      - watch
+  - apiGroups: ["extensions"]
+    resources:
+      - networkpolicies
+    verbs:
+      - get
+      - list
+      - watch
+  - apiGroups: ["crd.projectcalico.org"]
+    resources:
+      - globalfelixconfigs
+      - globalbgpconfigs
+      - ippools
+      - globalnetworkpolicies
+    verbs:
+      - create
+      - get
+      - list
+      - update
+      - watch
+
+---
+
+apiVersion: rbac.authorization.k8s.io/v1beta1
+kind: ClusterRoleBinding
+metadata:
+  name: calico-node
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: calico-node
+subjects:
+- kind: ServiceAccount
+  name: calico-node
+  namespace: kube-system
+
+---
+
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: calico-node
+  namespace: kube-system
+
+---
+
+# This manifest installs the calico/node container, as well
+# as the Calico CNI plugins and network config on
+# each master and worker node in a Kubernetes cluster.
+kind: DaemonSet
+apiVersion: extensions/v1beta1
+metadata:
+  name: calico-node
+  namespace: kube-system
+  labels:
+    k8s-app: calico-node
+spec:
+  selector:
+    matchLabels:
+      k8s-app: calico-node
+  template:
+    metadata:
+      labels:
+        k8s-app: calico-node
+      annotations:
+        scheduler.alpha.kubernetes.io/critical-pod: ''
+    spec:
+      hostNetwork: true
+      serviceAccountName: calico-node
+      tolerations:
+        # Allow the pod to run on the master.
+        - key: node-role.kubernetes.io/master
+          effect: NoSchedule
+        # Mark the pod as a critical add-on for rescheduling.
+        - key: "CriticalAddonsOnly"
+          operator: "Exists"
+      # Minimize downtime during a rolling upgrade or deletion; tell Kubernetes to do a "force
+      # deletion": https://kubernetes.io/docs/concepts/workloads/pods/pod/#termination-of-pods.
+      terminationGracePeriodSeconds: 0
+      containers:
+        # Runs calico/node container on each Kubernetes node.  This
+        # container programs network policy and routes on each
+        # host.
+        - name: calico-node
+          image: {{ image "calico-node" "quay.io/calico/node:v2.5.1" }}
+          env:
+{{- if eq .Datastore "etcd" }}
+            # The location of the Calico etcd cluster.
+            - name: ETCD_ENDPOINTS
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_endpoints
+            - name: ETCD_CA_CERT_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_ca
+            - name: ETCD_KEY_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_key
+            - name: ETCD_CERT_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_cert
+{{- else }}
+            # Use Kubernetes API as the backing datastore.
+            - name: DATASTORE_TYPE
+              value: "kubernetes"
+            # Wait for the datastore.
+            - name: WAIT_FOR_DATASTORE
+              value: "true"
+            # Set based on the k8s node name.
+            - name: NODENAME
+              valueFrom:
+                fieldRef:
+                  fieldPath: spec.nodeName
+{{- end }}
+            # Enable BGP.  Disable to enforce policy only.
+            - name: CALICO_NETWORKING_BACKEND
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: calico_backend
+            # Cluster type to identify the deployment type
+            - name: CLUSTER_TYPE
+              value: "k8s,bgp"
+            # Disable file logging so 'kubectl logs' works.
+            - name: CALICO_DISABLE_FILE_LOGGING
+              value: "true"
+            # Set Felix endpoint to host default action to ACCEPT.
+            - name: FELIX_DEFAULTENDPOINTTOHOSTACTION
+              value: "ACCEPT"
+            # The default IP pool created when no pools exist.
+            - name: CALICO_IPV4POOL_CIDR
+              value: "{{ .Network }}"
+            - name: CALICO_IPV4POOL_IPIP
+              value: "{{ .IPIPMode }}"
+            # Disable IPv6 on Kubernetes.
+            - name: FELIX_IPV6SUPPORT
+              value: "false"
+            # Set Felix logging to "info"
+            - name: FELIX_LOGSEVERITYSCREEN
+              value: "info"
+{{- if .MTU }}
+            # Set MTU for tunnel device used if ipip is enabled
+            - name: FELIX_IPINIPMTU
+              value: "{{ .MTU }}"
+{{- end }}
+            # Auto-detect the BGP IP address.
+            - name: IP
+              value: ""
+{{- if .Interface }}
+            - name: IP_AUTODETECTION_METHOD
+              value: "interface={{ .Interface }}"
+{{- end }}
+            - name: FELIX_HEALTHENABLED
+              value: "true"
+          securityContext:
+            privileged: true
+          resources:
+            requests:
+              cpu: 250m
+          livenessProbe:
+            httpGet:
+              path: /liveness
+              port: 9099
+            periodSeconds: 10
+            initialDelaySeconds: 10
+            failureThreshold: 6
+          readinessProbe:
+            httpGet:
+              path: /readiness
+              port: 9099
+            periodSeconds: 10
+          volumeMounts:
+            - mountPath: /lib/modules
+              name: lib-modules
+              readOnly: true
+            - mountPath: /var/run/calico
+              name: var-run-calico
+              readOnly: false
+{{- if eq .Datastore "etcd" }}
+            - mountPath: /calico-secrets
+              name: etcd-certs
+{{- end }}
+        # This container installs the Calico CNI binaries
+        # and CNI network config file on each node.
+        - name: install-cni
+          image: {{ image "calico-cni" "quay.io/calico/cni:v1.10.0" }}
+          command: ["/install-cni.sh"]
+          env:
+{{- if eq .Datastore "etcd" }}
+            # The location of the Calico etcd cluster.
+            - name: ETCD_ENDPOINTS
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_endpoints
+{{- end }}
+            # The CNI network config to install on each node.
+            - name: CNI_NETWORK_CONFIG
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: cni_network_config
+            # Set the hostname based on the k8s node name.
+            - name: KUBERNETES_NODE_NAME
+              valueFrom:
+                fieldRef:
+                  fieldPath: spec.nodeName
+          volumeMounts:
+            - mountPath: /host/opt/cni/bin
+              name: cni-bin-dir
+            - mountPath: /host/etc/cni/net.d
+              name: cni-net-dir
+{{- if eq .Datastore "etcd" }}
+            - mountPath: /calico-secrets
+              name: etcd-certs
+{{- end }}
+      volumes:
+        # Used by calico/node.
+        - name: lib-modules
+          hostPath:
+            path: /lib/modules
+        - name: var-run-calico
+          hostPath:
+            path: /var/run/calico
+        # Used to install CNI.
+        - name: cni-bin-dir
+          hostPath:
+            path: /opt/cni/bin
+        - name: cni-net-dir
+          hostPath:
+            path: /etc/cni/net.d
+{{- if eq .Datastore "etcd" }}
+        # Mount in the etcd TLS secrets.
+        - name: etcd-certs
+          secret:
+            secretName: calico-etcd-secrets
+
+---
+
+# This manifest deploys the Calico Kubernetes controllers (only required with the etcd datastore).
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: calico-kube-controllers
+  namespace: kube-system
+  labels:
+    k8s-app: calico-kube-controllers
+  annotations:
+    scheduler.alpha.kubernetes.io/critical-pod: ''
+spec:
+  # The controllers can only have a single active instance.
+  replicas: 1
+  strategy:
+    type: Recreate
+  template:
+    metadata:
+      name: calico-kube-controllers
+      namespace: kube-system
+      labels:
+        k8s-app: calico-kube-controllers
+    spec:
+      # The controllers must run in the host network namespace so that
+      # it isn't governed by policy that would prevent it from working.
+      hostNetwork: true
+      tolerations:
+        - key: node-role.kubernetes.io/master
+          effect: NoSchedule
+        - key: "CriticalAddonsOnly"
+          operator: "Exists"
+      serviceAccountName: calico-kube-controllers
+      containers:
+        - name: calico-kube-controllers
+          image: {{ image "calico-kube-controllers" "quay.io/calico/kube-controllers:v1.0.0" }}
+          env:
+            # The location of the Calico etcd cluster.
+            - name: ETCD_ENDPOINTS
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_endpoints
+            - name: ETCD_CA_CERT_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_ca
+            - name: ETCD_KEY_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_key
+            - name: ETCD_CERT_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_cert
+          volumeMounts:
+            - mountPath: /calico-secrets
+              name: etcd-certs
+      volumes:
+        - name: etcd-certs
+          secret:
+            secretName: calico-etcd-secrets
+
+---
+
+kind: ClusterRole
+apiVersion: rbac.authorization.k8s.io/v1beta1
+metadata:
+  name: calico-kube-controllers
+rules:
+  - apiGroups:
+    - ""
+    - extensions
+    resources:
+      - pods
+      - namespaces
+      - networkpolicies
+      - nodes
+    verbs:
+      - watch
+      - list
+
+---
+
+kind: ClusterRoleBinding
+apiVersion: rbac.authorization.k8s.io/v1beta1
+metadata:
+  name: calico-kube-controllers
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: calico-kube-controllers
+subjects:
+- kind: ServiceAccount
+  name: calico-kube-controllers
+  namespace: kube-system
+
+---
+
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: calico-kube-controllers
+  namespace: kube-system
+{{- end }}
+
+---
+
+# Applies the IP pools (including the IPIP mode of the default pool which calico/node only sets when created).
+# A job can't be updated so it's named after the pools and replaced (and pruned) when they change.
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: calico-ippools-{{ hash .IPPools .IPIPMode .Datastore }}
+  namespace: kube-system
+spec:
+  template:
+    metadata:
+      name: calico-ippools
+    spec:
+      hostNetwork: true
+      restartPolicy: OnFailure
+      serviceAccountName: calico-node
+      tolerations:
+        - key: node-role.kubernetes.io/master
+          effect: NoSchedule
+      containers:
+        - name: calicoctl
+          image: {{ image "calicoctl" "quay.io/calico/ctl:v1.5.0" }}
+          command: ["/calicoctl", "apply", "-f", "/etc/calico/ippools.yaml"]
+          env:
+{{- if eq .Datastore "etcd" }}
+            - name: ETCD_ENDPOINTS
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_endpoints
+            - name: ETCD_CA_CERT_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_ca
+            - name: ETCD_KEY_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_key
+            - name: ETCD_CERT_FILE
+              valueFrom:
+                configMapKeyRef:
+                  name: calico-config
+                  key: etcd_cert
+{{- else }}
+            - name: DATASTORE_TYPE
+              value: "kubernetes"
+{{- end }}
+          volumeMounts:
+            - mountPath: /etc/calico
+              name: ippools
+{{- if eq .Datastore "etcd" }}
+            - mountPath: /calico-secrets
+              name: etcd-certs
+{{- end }}
+      volumes:
+        - name: ippools
+          configMap:
+            name: calico-config
+            items:
+              - key: ippools.yaml
+                path: ippools.yaml
+{{- if eq .Datastore "etcd" }}
+        - name: etcd-certs
+          secret:
+            secretName: calico-etcd-secrets
+{{- end }}
+`
@@ -1,27 +1,52 @@
 package network
 
+import "github.com/UKHomeOffice/keto-k8/pkg/k8client"
+
 // We must configure a null param here: See https://github.com/kubernetes/kubernetes/issues/36575
+// A configured pod network CIDR is only given to weave itself (as the IP allocation range).
 const weavePodCidr = ""
 
+var weaveOptions = options{
+	name:       "weave",
+	mtu:        true,
+	images:     []string{"weave-kube", "weave-npc"},
+	cniConfigs: []string{"10-weave.conf"},
+}
+
 // WeaveNetworkProvider  - a struct to represent the concrete implementation of a Weave network.Provider
-type WeaveNetworkProvider struct {}
+type WeaveNetworkProvider struct {
+	cfg Config
+}
 
 // NewWeaveNetworkProvider - a factory method to initialise and return a Weave specific network.Provider
-func NewWeaveNetworkProvider() (Provider) {
-	return &WeaveNetworkProvider{}
+func NewWeaveNetworkProvider(cfg Config) (Provider, error) {
+	if err := weaveOptions.validate(cfg); err != nil {
+		return nil, err
+	}
+	return &WeaveNetworkProvider{cfg: cfg}, nil
 }
 
 // Name - will return the Weave NetworkProvider name
 func (fnp *WeaveNetworkProvider) Name() string {
-	return "weave"
+	return weaveOptions.name
 }
 
-// PodNetworkCidr - will return the Canal NetworkProvider name
+// PodNetworkCidr - will return the pod network CIDR for kubeadm (always empty for Weave)
 func (fnp *WeaveNetworkProvider) PodNetworkCidr() string {
 	return weavePodCidr
 }
 
+// CNIConfigs - will return the Weave CNI config files
+func (fnp *WeaveNetworkProvider) CNIConfigs() []string {
+	return weaveOptions.cniConfigs
+}
+
 // Create - will create the K8 network resources (Weave)
-func (fnp *WeaveNetworkProvider) Create() (error) {
-	return renderandDeploy(weavePodCidr, weaveYaml)
+func (fnp *WeaveNetworkProvider) Create(a k8client.Applier) error {
+	return deploy(a, fnp)
+}
+
+// Manifest - will return the K8 network resources (Weave)
+func (fnp *WeaveNetworkProvider) Manifest() (string, error) {
+	return renderTemplate(fnp.Name(), weaveYaml, weaveOptions.data(fnp.cfg), fnp.cfg.Images)
 }
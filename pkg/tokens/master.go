@@ -8,16 +8,20 @@ import (
 	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
 )
 
+// Component labels the keto-tokens resources so any no longer required are pruned
+const Component = "keto-tokens"
+
 // Deploy creates keto-tokens k8 resources
-func Deploy(clusterName string) (error) {
-	k8Definition, err := getDeployment(clusterName)
+func Deploy(a k8client.Applier, clusterName string) (error) {
+	k8Definition, err := Manifest(clusterName)
 	if err != nil {
 		return err
 	}
-	return k8client.Apply(k8Definition)
+	return k8client.ApplyAndPrune(a, Component, k8Definition)
 }
 
-func getDeployment(clusterName string) (string, error) {
+// Manifest returns the keto-tokens k8 resources
+func Manifest(clusterName string) (string, error) {
 
 	data := struct {
 		ClusterName	string
@@ -0,0 +1,41 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/UKHomeOffice/keto-k8/pkg/k8client"
+	k8clientMocks "github.com/UKHomeOffice/keto-k8/pkg/k8client/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDeploy(t *testing.T) {
+	a := &k8clientMocks.Applier{}
+	a.On("Apply", mock.AnythingOfType("string")).Return(nil)
+	a.On("Prune", Component, mock.AnythingOfType("string")).Return(nil)
+	if err := Deploy(a, "test-cluster"); err != nil {
+		t.Fatal(err)
+	}
+	a.AssertNumberOfCalls(t, "Apply", 1)
+	resources := a.Calls[0].Arguments.String(0)
+	objs, err := k8client.Decode(resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]bool{}
+	for _, obj := range objs {
+		kinds[obj.GetKind()] = true
+		if obj.GetLabels()[k8client.ComponentLabel] != Component {
+			t.Errorf("expected %s/%s labelled as the %s component", obj.GetKind(), obj.GetName(), Component)
+		}
+	}
+	for _, kind := range []string{"ClusterRole", "ServiceAccount", "ClusterRoleBinding", "DaemonSet"} {
+		if !kinds[kind] {
+			t.Errorf("expected a %s to be deployed", kind)
+		}
+	}
+	if !strings.Contains(resources, "test-cluster") {
+		t.Errorf("expected the cluster name in the resources")
+	}
+	a.AssertCalled(t, "Prune", Component, resources)
+}